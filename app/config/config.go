@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -9,6 +11,10 @@ import (
 	"github.com/magiconair/properties"
 )
 
+var (
+	ErrorIcePortRange = errors.New("invalid ice.port range, expected 0 to disable or 1-65535 with min <= max")
+)
+
 var Config ConfigST
 var configPath string
 
@@ -24,11 +30,59 @@ type ConfigST struct {
 	Recordings struct {
 		Folder string `json:"folders" properties:"folders,default=recordings"`
 	} `json:"recordings" properties:"recordings"`
+	Media struct {
+		Folder string `json:"folder" properties:"folder,default=media"`
+	} `json:"media" properties:"media"`
 	Ice struct {
 		Servers    []string `json:"servers" properties:"servers,default="`
 		Username   string   `json:"username" properties:"username,default="`
 		Credential string   `json:"credential" properties:"credential,default="`
+		Port       struct {
+			Min int `json:"min" properties:"min,default=0"`
+			Max int `json:"max" properties:"max,default=0"`
+		} `json:"port" properties:"port"`
+		UDPMux struct {
+			Port int `json:"port" properties:"port,default=0"`
+		} `json:"udp_mux" properties:"udp_mux"`
+		TCPMux struct {
+			Port int  `json:"port" properties:"port,default=0"`
+			Only bool `json:"only" properties:"only,default=false"`
+		} `json:"tcp_mux" properties:"tcp_mux"`
+		NAT1To1 struct {
+			IPs           []string `json:"ips" properties:"ips,default="`
+			CandidateType string   `json:"candidate_type" properties:"candidate_type,default=host"`
+		} `json:"nat_1to1" properties:"nat_1to1"`
 	} `json:"ice" properties:"ice"`
+	RTMP struct {
+		Enabled bool `json:"enabled" properties:"enabled,default=false"`
+		Port    int  `json:"port" properties:"port,default=1935"`
+		Timeout struct {
+			Seconds int `json:"seconds" properties:"seconds,default=10"`
+		} `json:"timeout" properties:"timeout"`
+	} `json:"rtmp" properties:"rtmp"`
+	Onvif struct {
+		Timeout struct {
+			Seconds int `json:"seconds" properties:"seconds,default=5"`
+		} `json:"timeout" properties:"timeout"`
+		Discover struct {
+			TimeoutMS int `json:"timeout_ms" properties:"timeout_ms,default=3000"`
+		} `json:"discover" properties:"discover"`
+	} `json:"onvif" properties:"onvif"`
+	Snapshot struct {
+		Timeout struct {
+			Seconds int `json:"seconds" properties:"seconds,default=5"`
+		} `json:"timeout" properties:"timeout"`
+		Cache struct {
+			MS int `json:"ms" properties:"ms,default=2000"`
+		} `json:"cache" properties:"cache"`
+	} `json:"snapshot" properties:"snapshot"`
+	Transcode struct {
+		Audio struct {
+			Enabled bool   `json:"enabled" properties:"enabled,default=true"`
+			Codec   string `json:"codec" properties:"codec,default=pcma"`
+			FFmpeg  string `json:"ffmpeg" properties:"ffmpeg,default=ffmpeg"`
+		} `json:"audio" properties:"audio"`
+	} `json:"transcode" properties:"transcode"`
 	RTSP struct {
 		Connect struct {
 			Timeout struct {
@@ -40,11 +94,21 @@ type ConfigST struct {
 				Seconds int `json:"seconds" properties:"seconds,default=10"`
 			} `json:"timeout" properties:"timeout"`
 		} `json:"io" properties:"io"`
+		OnDemand struct {
+			Idle struct {
+				Seconds int `json:"seconds" properties:"seconds,default=30"`
+			} `json:"idle" properties:"idle"`
+		} `json:"on_demand" properties:"on_demand"`
 		Playback struct {
 			CodecDelayMS int `json:"codec_delay_ms" properties:"codec_delay_ms,default=3000"`
 		} `json:"playback" properties:"playback"`
 		Debug bool `json:"debug" properties:"debug,default=false"`
 	} `json:"rtsp" properties:"rtsp"`
+	Shutdown struct {
+		Timeout struct {
+			Seconds int `json:"seconds" properties:"seconds,default=10"`
+		} `json:"timeout" properties:"timeout"`
+	} `json:"shutdown" properties:"shutdown"`
 }
 
 func loadConfig(configPath string) error {
@@ -63,10 +127,28 @@ func loadConfig(configPath string) error {
 	if err != nil {
 		return err
 	}
-	decode_err := p.Decode(&Config)
+	var config ConfigST
+	decode_err := p.Decode(&config)
 	if decode_err != nil {
 		return decode_err
 	}
+	if err := validateConfig(&config); err != nil {
+		return err
+	}
+	Config = config
+	return nil
+}
+
+// validateConfig rejects values that would otherwise be ignored or wrap
+// around where they are used.
+func validateConfig(config *ConfigST) error {
+	min, max := config.Ice.Port.Min, config.Ice.Port.Max
+	if min == 0 && max == 0 {
+		return nil
+	}
+	if min < 1 || max < 1 || min > 65535 || max > 65535 || min > max {
+		return fmt.Errorf("%w, got %d-%d", ErrorIcePortRange, min, max)
+	}
 	return nil
 }
 
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigIcePorts(t *testing.T) {
+	tests := []struct {
+		min, max string
+		err      error
+	}{
+		{"0", "0", nil},
+		{"50000", "50100", nil},
+		{"50000", "50000", nil},
+		{"50100", "50000", ErrorIcePortRange},
+		{"50000", "0", ErrorIcePortRange},
+		{"0", "50000", ErrorIcePortRange},
+		{"-1", "50000", ErrorIcePortRange},
+		{"50000", "70000", ErrorIcePortRange},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "config.properties")
+		if err := os.WriteFile(path, []byte("ice.port.min="+test.min+"\nice.port.max="+test.max+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		Config.Ice.Port.Min, Config.Ice.Port.Max = 1, 2
+		err := loadConfig(path)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s-%s: expected %v, got %v", test.min, test.max, test.err, err)
+		}
+		if err != nil && (Config.Ice.Port.Min != 1 || Config.Ice.Port.Max != 2) {
+			t.Fatalf("%s-%s: an invalid config was applied", test.min, test.max)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/live"
 	"github.com/aicacia/streams/app/models"
 	"github.com/aicacia/streams/app/services"
 	"github.com/gofiber/fiber/v2"
@@ -33,8 +38,12 @@ func GetCameras(c *fiber.Ctx) error {
 			})
 		}
 	}
+	redacted := make([]*models.CameraST, len(cameras))
+	for i, camera := range cameras {
+		redacted[i] = camera.Redacted()
+	}
 	c.Status(http.StatusOK)
-	return c.JSON(cameras)
+	return c.JSON(redacted)
 }
 
 // Auth GetCameraById
@@ -61,7 +70,7 @@ func GetCameraById(c *fiber.Ctx) error {
 		})
 	}
 	c.Status(http.StatusOK)
-	return c.JSON(camera)
+	return c.JSON(camera.Redacted())
 }
 
 // Auth PostCreateCamera
@@ -96,7 +105,7 @@ func PostCreateCamera(c *fiber.Ctx) error {
 		})
 	}
 	c.Status(http.StatusCreated)
-	return c.JSON(camera)
+	return c.JSON(camera.Redacted())
 }
 
 // Auth PatchUpdateCamera
@@ -132,7 +141,7 @@ func PatchUpdateCamera(c *fiber.Ctx) error {
 		})
 	}
 	c.Status(http.StatusOK)
-	return c.JSON(camera)
+	return c.JSON(camera.Redacted())
 }
 
 // Auth DeleteCamera
@@ -164,3 +173,178 @@ func DeleteCamera(c *fiber.Ctx) error {
 	c.Status(http.StatusNoContent)
 	return c.Send(nil)
 }
+
+// Auth PostDiscoverCameras
+//
+//		@Summary		Discover Cameras
+//		@Description	find ONVIF cameras on the LAN with WS-Discovery and read their stream profiles, each candidate camera can be sent to create camera or created right away with create, devices that already have a camera are not created again and address must be a multicast or link local ip:port
+//		@Tags			cameras
+//		@Accept			json
+//		@Produce		json
+//	    @Param			discover	body    models.DiscoverBodyST	true	"Discover Cameras"
+//		@Success		200	{object}	[]services.CameraCandidateST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/cameras/discover [post]
+func PostDiscoverCameras(c *fiber.Ctx) error {
+	var body models.DiscoverBodyST
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			log.Println(err)
+			c.Status(http.StatusBadRequest)
+			return c.JSON(models.ResponseErrorST{
+				Error: err.Error(),
+			})
+		}
+	}
+	candidates, err := services.DiscoverCameras(&body)
+	if errors.Is(err, services.ErrorDiscoverAddress) {
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	} else if err != nil {
+		log.Println(err)
+		c.Status(http.StatusInternalServerError)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(candidates)
+}
+
+// Auth GetCameraSnapshot
+//
+//		@Summary		Camera Snapshot
+//		@Description	get a still image from the camera url, proxied with the camera credentials and cached for a short time
+//		@Tags			cameras
+//		@Produce		image/jpeg
+//	    @Param			cameraId	path		string	true	"Camera ID"
+//		@Success		200	{file}	    file
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Failure		502	{object}	models.ResponseErrorST
+//		@Router			/cameras/{cameraId}/snapshot [get]
+func GetCameraSnapshot(c *fiber.Ctx) error {
+	snapshot, err := services.GetCameraSnapshot(c.Params("cameraId"))
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, services.ErrorCameraNoSnapshotUrl) {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.Status(http.StatusBadGateway)
+		}
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, snapshot.ContentType)
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", config.Config.Snapshot.Cache.MS/1000))
+	c.Set(fiber.HeaderLastModified, snapshot.Ts.Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+	return c.Send(snapshot.Body)
+}
+
+// Auth GetCameraStatus
+//
+//		@Summary		Get Camera Status
+//		@Description	get the connection state, traffic and viewers of every stream of the camera and whether it is recording
+//		@Tags			cameras
+//		@Accept			json
+//		@Produce		json
+//	    @Param			cameraId	path		string	true	"Camera ID"
+//		@Success		200	{object}	models.CameraStatusST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/cameras/{cameraId}/status [get]
+func GetCameraStatus(c *fiber.Ctx) error {
+	camera, err := services.GetCamera(c.Params("cameraId"))
+	if err != nil {
+		log.Println(err)
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(live.CameraStatus(camera))
+}
+
+// Auth GetCamerasStatus
+//
+//	@Summary		Get Cameras Status
+//	@Description	get the status of all cameras
+//	@Tags			cameras
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	    []models.CameraStatusST
+//	@Failure		400	{object}	models.ResponseErrorST
+//	@Failure		401	{object}	models.ResponseErrorST
+//	@Failure		404	{object}	models.ResponseErrorST
+//	@Failure		500	{object}	models.ResponseErrorST
+//	@Router			/cameras/status [get]
+func GetCamerasStatus(c *fiber.Ctx) error {
+	cameras, err := services.ListCameras()
+	if err != nil {
+		log.Println(err)
+		if len(cameras) == 0 {
+			c.Status(http.StatusInternalServerError)
+			return c.JSON(models.ResponseErrorST{
+				Error: err.Error(),
+			})
+		}
+	}
+	statuses := make([]models.CameraStatusST, 0, len(cameras))
+	for _, camera := range cameras {
+		statuses = append(statuses, live.CameraStatus(camera))
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(statuses)
+}
+
+// Auth PostCameraPTZ
+//
+//		@Summary		Camera PTZ
+//		@Description	send an ONVIF PTZ command to the camera, pan, tilt and zoom are in the device's generic -1..1 space, timeout_ms limits a continuous move
+//		@Tags			cameras
+//		@Accept			json
+//		@Produce		json
+//	    @Param			cameraId	path		string	true	"Camera ID"
+//	    @Param			ptz	body    models.PTZBodyST	true	"PTZ Command"
+//		@Success		200	{object}	models.PTZResponseST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/cameras/{cameraId}/ptz [post]
+func PostCameraPTZ(c *fiber.Ctx) error {
+	var body models.PTZBodyST
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	response, err := services.CameraPTZ(c.Params("cameraId"), &body)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, fs.ErrNotExist) {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.Status(http.StatusBadRequest)
+		}
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(response)
+}
@@ -1,17 +1,56 @@
 package controllers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
-	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/live"
 	"github.com/aicacia/streams/app/models"
 	"github.com/aicacia/streams/app/rtsp"
+	"github.com/aicacia/streams/app/services"
 	"github.com/aicacia/streams/app/util"
-	webrtc "github.com/deepch/vdk/format/webrtcv3"
+	"github.com/aicacia/streams/app/webrtc"
 	"github.com/gofiber/fiber/v2"
 )
 
+// Auth GetLive
+//
+//		@Summary		Get Live Tracks
+//		@Description	get camera live tracks with codec details and the outputs that can play each track, a track that can not play live has a reason
+//		@Tags			cameras,live
+//		@Accept			json
+//		@Produce		json
+//	    @Param			cameraId	path		string	true	"Camera ID"
+//	    @Param			profile	query		string	false	"Camera profile, defaults to main"
+//		@Success		200	{object}	models.LiveST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/cameras/{cameraId}/live [get]
+func GetLive(c *fiber.Ctx) error {
+	cameraId := c.Params("cameraId")
+	profile := c.Query("profile", models.CameraProfileMain)
+	streamId, ok := cameraStreamId(c, cameraId, profile)
+	if !ok {
+		return nil
+	}
+	codecs := rtsp.GetCodecs(streamId)
+	if codecs == nil {
+		c.Status(http.StatusInternalServerError)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Failed to start local rtsp",
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(models.LiveST{
+		CameraId: cameraId,
+		Profile:  profile,
+		Tracks:   live.CodecsToTracks(codecs),
+	})
+}
+
 // Auth GetLiveCodecs
 //
 //		@Summary		Get Live Codecs
@@ -20,6 +59,7 @@ import (
 //		@Accept			json
 //		@Produce		json
 //	    @Param			cameraId	path		string	true	"Camera ID"
+//	    @Param			profile	query		string	false	"Camera profile, defaults to main"
 //		@Success		200	{object}	[]string
 //		@Failure		400	{object}	models.ResponseErrorST
 //		@Failure		401	{object}	models.ResponseErrorST
@@ -27,8 +67,11 @@ import (
 //		@Failure		500	{object}	models.ResponseErrorST
 //		@Router			/cameras/{cameraId}/live/codecs [get]
 func GetLiveCodecs(c *fiber.Ctx) error {
-	cameraId := c.Params("cameraId")
-	codecs := rtsp.GetCodecs(cameraId)
+	streamId, ok := cameraStreamId(c, c.Params("cameraId"), c.Query("profile"))
+	if !ok {
+		return nil
+	}
+	codecs := rtsp.GetCodecs(streamId)
 	if codecs == nil {
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
@@ -36,17 +79,18 @@ func GetLiveCodecs(c *fiber.Ctx) error {
 		})
 	}
 	c.Status(http.StatusOK)
-	return c.JSON(util.CodecsToStrings(codecs))
+	return c.JSON(util.CodecsToStrings(codecs, webrtc.IsCodecSupported))
 }
 
 // Auth PostLiveSdp
 //
 //		@Summary		Send live offer
-//		@Description	send live offer for camera by id
+//		@Description	send live offer for camera by id, a "metadata" data channel in the offer receives the wall clock time of every key frame and a time sync every second
 //		@Tags			cameras,live
 //		@Accept			json
 //		@Produce		json
 //	    @Param			cameraId	path		string	true	"Camera ID"
+//	    @Param			profile	query		string	false	"Camera profile, defaults to main"
 //	    @Param			offer	body    models.OfferBodyST	true	"Offer body"
 //		@Success		200	{object}	models.AnswerST
 //		@Failure		400	{object}	models.ResponseErrorST
@@ -55,7 +99,10 @@ func GetLiveCodecs(c *fiber.Ctx) error {
 //		@Failure		500	{object}	models.ResponseErrorST
 //		@Router			/cameras/{cameraId}/live/sdp [post]
 func PostLiveSdp(c *fiber.Ctx) error {
-	cameraId := c.Params("cameraId")
+	streamId, ok := cameraStreamId(c, c.Params("cameraId"), c.Query("profile"))
+	if !ok {
+		return nil
+	}
 	var body models.OfferBodyST
 	if err := c.BodyParser(&body); err != nil {
 		log.Println(err)
@@ -64,7 +111,7 @@ func PostLiveSdp(c *fiber.Ctx) error {
 			Error: "Invalid Request Body",
 		})
 	}
-	codecs := rtsp.GetCodecs(cameraId)
+	codecs := rtsp.GetCodecs(streamId)
 	if codecs == nil {
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
@@ -72,39 +119,46 @@ func PostLiveSdp(c *fiber.Ctx) error {
 		})
 	}
 
-	muxerWebRTC := webrtc.NewMuxer(webrtc.Options{
-		ICEServers:    config.Config.Ice.Servers,
-		ICEUsername:   config.Config.Ice.Username,
-		ICECredential: config.Config.Ice.Credential,
-		PortMin:       0,
-		PortMax:       0,
-	})
-	answer, err := muxerWebRTC.WriteHeader(codecs, body.OfferBase64)
-	if err != nil {
-		log.Println("WriteHeader", err)
+	// audio WebRTC can not play is transcoded once for every viewer
+	viewer, codecs := rtsp.AddTranscodedViewer(streamId)
+	if viewer == nil || codecs == nil {
+		log.Printf("Failed to create viewer for %s\n", streamId)
+		if viewer != nil {
+			rtsp.DeleteViewer(streamId, &viewer.Uuid)
+		}
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
-			Error: "Failed to Start stream",
+			Error: "Failed to Create viewer for stream",
 		})
 	}
-	viewer := rtsp.AddViewer(cameraId)
-	if viewer == nil {
-		log.Printf("Failed to create viewer for %s\n", cameraId)
+	muxerWebRTC := webrtc.NewMuxer(webrtc.DefaultOptions())
+	answer, err := muxerWebRTC.WriteHeader(codecs, body.OfferBase64)
+	if err != nil {
+		log.Println("WriteHeader", err)
+		rtsp.DeleteViewer(streamId, &viewer.Uuid)
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
-			Error: "Failed to Create viewer for stream",
+			Error: "Failed to Start stream",
 		})
 	}
 
 	go func() {
-		defer rtsp.DeleteViewer(cameraId, &viewer.Uuid)
+		defer rtsp.DeleteViewer(streamId, &viewer.Uuid)
 		defer muxerWebRTC.Close()
 
-		for packet := range viewer.Socket {
-			err = muxerWebRTC.WritePacket(*packet)
-			if err != nil {
-				log.Println("WritePacket", err)
+		for {
+			select {
+			case <-muxerWebRTC.Done():
 				return
+			case packet, ok := <-viewer.Socket:
+				if !ok {
+					return
+				}
+				muxerWebRTC.WriteMetadata(packet, rtsp.GetPacketTime(packet))
+				if err := muxerWebRTC.WritePacket(*packet); err != nil {
+					log.Println("WritePacket", err)
+					return
+				}
 			}
 		}
 	}()
@@ -114,3 +168,137 @@ func PostLiveSdp(c *fiber.Ctx) error {
 		AnswerBase64: answer,
 	})
 }
+
+// Auth PostLiveGrid
+//
+//		@Summary		Create live grid
+//		@Description	send a live offer for many cameras over one connection, every recvonly video transceiver in the offer is a slot and cameras are assigned to slots in order, the "grid" data channel receives the slot to camera mapping
+//		@Tags			live
+//		@Accept			json
+//		@Produce		json
+//	    @Param			offer	body    models.GridOfferBodyST	true	"Grid offer body"
+//		@Success		200	{object}	models.GridAnswerST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/live/grid [post]
+func PostLiveGrid(c *fiber.Ctx) error {
+	var body models.GridOfferBodyST
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Invalid Request Body",
+		})
+	}
+	answer, err := live.NewGrid(body.OfferBase64, body.CameraIds, body.Profile)
+	if err != nil {
+		log.Println("NewGrid", err)
+		switch {
+		case errors.Is(err, live.ErrorGridCameraNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, webrtc.ErrorGridInvalidOffer), errors.Is(err, webrtc.ErrorGridNoSlots):
+			c.Status(http.StatusBadRequest)
+		default:
+			c.Status(http.StatusInternalServerError)
+			return c.JSON(models.ResponseErrorST{
+				Error: "Failed to Start grid",
+			})
+		}
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(answer)
+}
+
+// Auth PatchLiveGrid
+//
+//		@Summary		Update live grid
+//		@Description	reassign the cameras of a live grid, include a new offer to renegotiate when more slots are needed
+//		@Tags			live
+//		@Accept			json
+//		@Produce		json
+//	    @Param			gridId	path		string	true	"Grid ID"
+//	    @Param			grid	body    models.GridUpdateBodyST	true	"Grid update body"
+//		@Success		200	{object}	models.GridAnswerST
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/live/grid/{gridId} [patch]
+func PatchLiveGrid(c *fiber.Ctx) error {
+	var body models.GridUpdateBodyST
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Invalid Request Body",
+		})
+	}
+	answer, err := live.UpdateGrid(c.Params("gridId"), body.OfferBase64, body.CameraIds, body.Profile)
+	if err != nil {
+		log.Println("UpdateGrid", err)
+		if err == live.ErrorGridNotFound || errors.Is(err, live.ErrorGridCameraNotFound) {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.Status(http.StatusBadRequest)
+		}
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(answer)
+}
+
+// Auth DeleteLiveGrid
+//
+//	@Summary		Delete live grid
+//	@Description	close a live grid and all of its camera viewers
+//	@Tags			live
+//	@Accept			json
+//	@Produce		json
+//	@Param			gridId	path		string	true	"Grid ID"
+//	@Success		204	{null}	    nil
+//	@Failure		400	{object}	models.ResponseErrorST
+//	@Failure		401	{object}	models.ResponseErrorST
+//	@Failure		404	{object}	models.ResponseErrorST
+//	@Failure		500	{object}	models.ResponseErrorST
+//	@Router			/live/grid/{gridId} [delete]
+func DeleteLiveGrid(c *fiber.Ctx) error {
+	err := live.DeleteGrid(c.Params("gridId"))
+	if err != nil {
+		log.Println(err)
+		c.Status(http.StatusNotFound)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusNoContent)
+	return c.Send(nil)
+}
+
+// cameraStreamId is the stream of a camera profile, it responds 404 and
+// returns false when the camera or the profile does not exist.
+func cameraStreamId(c *fiber.Ctx, cameraId, profile string) (string, bool) {
+	camera, err := services.GetCamera(cameraId)
+	if err != nil {
+		log.Println(err)
+		c.Status(http.StatusNotFound)
+		c.JSON(models.ResponseErrorST{
+			Error: "Camera Not Found",
+		})
+		return "", false
+	}
+	if !camera.HasProfile(profile) {
+		c.Status(http.StatusNotFound)
+		c.JSON(models.ResponseErrorST{
+			Error: "Camera Profile Not Found",
+		})
+		return "", false
+	}
+	return rtsp.StreamId(cameraId, profile), true
+}
@@ -6,11 +6,13 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/aicacia/streams/app/config"
 	"github.com/aicacia/streams/app/models"
 	"github.com/aicacia/streams/app/playback"
+	"github.com/aicacia/streams/app/rtsp"
+	"github.com/aicacia/streams/app/transcode"
 	"github.com/aicacia/streams/app/util"
-	webrtc "github.com/deepch/vdk/format/webrtcv3"
+	"github.com/aicacia/streams/app/webrtc"
+	"github.com/deepch/vdk/av"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -23,6 +25,7 @@ import (
 //		@Produce		json
 //	    @Param			cameraId	path		string	true	"Camera ID"
 //	    @Param			start	    query		int64	true	"Playback start time"
+//	    @Param			profile	query		string	false	"Camera profile, defaults to main"
 //		@Success		200	{object}	string
 //		@Failure		400	{object}	models.ResponseErrorST
 //		@Failure		401	{object}	models.ResponseErrorST
@@ -44,7 +47,11 @@ func PostCreatePlayback(c *fiber.Ctx) error {
 		t := time.UnixMilli(timestampMS).UTC()
 		start = &t
 	}
-	playbackId, err := playback.NewPlayback(cameraId, start)
+	streamId, ok := cameraStreamId(c, cameraId, c.Query("profile"))
+	if !ok {
+		return nil
+	}
+	playbackId, err := playback.NewPlayback(streamId, start)
 	if err != nil {
 		c.Status(http.StatusBadRequest)
 		return c.JSON(models.ResponseErrorST{
@@ -79,13 +86,13 @@ func GetPlaybackCodecs(c *fiber.Ctx) error {
 		})
 	}
 	c.Status(http.StatusOK)
-	return c.JSON(util.CodecsToStrings(codecs))
+	return c.JSON(util.CodecsToStrings(codecs, webrtc.IsCodecSupported))
 }
 
 // Auth PostPlaybackSdp
 //
 //		@Summary		Send playback offer
-//		@Description	send playback offer for camera by id
+//		@Description	send playback offer for camera by id, a "metadata" data channel in the offer receives the wall clock time of every key frame and a time sync every second
 //		@Tags			cameras,playback
 //		@Accept			json
 //		@Produce		json
@@ -115,16 +122,18 @@ func PostPlaybackSdp(c *fiber.Ctx) error {
 		})
 	}
 
-	muxerWebRTC := webrtc.NewMuxer(webrtc.Options{
-		ICEServers:    config.Config.Ice.Servers,
-		ICEUsername:   config.Config.Ice.Username,
-		ICECredential: config.Config.Ice.Credential,
-		PortMin:       0,
-		PortMax:       0,
-	})
+	// audio WebRTC can not play is transcoded, a playback has one viewer
+	audio, err := transcode.NewAudioStage(codecs)
+	if err == nil {
+		codecs = audio.Codecs()
+	}
+	muxerWebRTC := webrtc.NewMuxer(webrtc.DefaultOptions())
 	answer, err := muxerWebRTC.WriteHeader(codecs, body.OfferBase64)
 	if err != nil {
 		log.Println("WriteHeader", err)
+		if audio != nil {
+			audio.Close()
+		}
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
 			Error: "Failed to Start stream",
@@ -133,6 +142,10 @@ func PostPlaybackSdp(c *fiber.Ctx) error {
 	socket := playback.GetPlaybackSocket(playbackId)
 	if socket == nil {
 		log.Printf("Failed to create viewer for %s\n", playbackId)
+		muxerWebRTC.Close()
+		if audio != nil {
+			audio.Close()
+		}
 		c.Status(http.StatusInternalServerError)
 		return c.JSON(models.ResponseErrorST{
 			Error: "Failed to Create viewer for stream",
@@ -142,10 +155,32 @@ func PostPlaybackSdp(c *fiber.Ctx) error {
 	go func() {
 		defer playback.PlaybackDelete(playbackId)
 		defer muxerWebRTC.Close()
+		var audioPackets <-chan *av.Packet
+		if audio != nil {
+			defer audio.Close()
+			audioPackets = audio.Packets()
+		}
 
-		for packet := range socket {
-			err = muxerWebRTC.WritePacket(*packet)
-			if err != nil {
+		for {
+			var packet *av.Packet
+			select {
+			case p, ok := <-socket:
+				if !ok {
+					return
+				}
+				if audio != nil && audio.WritePacket(p) {
+					continue
+				}
+				packet = p
+			case p, ok := <-audioPackets:
+				if !ok {
+					audioPackets = nil
+					continue
+				}
+				packet = p
+			}
+			muxerWebRTC.WriteMetadata(packet, rtsp.GetPacketTime(packet))
+			if err := muxerWebRTC.WritePacket(*packet); err != nil {
 				log.Println("WritePacket", err)
 				return
 			}
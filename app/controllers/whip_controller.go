@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/rtsp"
+	"github.com/aicacia/streams/app/services"
+	"github.com/aicacia/streams/app/util"
+	"github.com/aicacia/streams/app/webrtc"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Auth PostWHIP
+//
+//		@Summary		WHIP publish
+//		@Description	publish H264 and Opus/G.711 over WebRTC into a whip camera, the body is the SDP offer and the camera stream key is sent as a bearer token
+//		@Tags			cameras,whip
+//		@Accept			application/sdp
+//		@Produce		application/sdp
+//	    @Param			cameraId	path		string	true	"Camera ID"
+//	    @Param			offer	body    string	true	"SDP offer"
+//		@Success		201	{string}	string
+//		@Failure		400	{object}	models.ResponseErrorST
+//		@Failure		401	{object}	models.ResponseErrorST
+//		@Failure		404	{object}	models.ResponseErrorST
+//		@Failure		409	{object}	models.ResponseErrorST
+//		@Failure		500	{object}	models.ResponseErrorST
+//		@Router			/whip/{cameraId} [post]
+func PostWHIP(c *fiber.Ctx) error {
+	cameraId := c.Params("cameraId")
+	camera, err := services.GetCamera(cameraId)
+	if err != nil {
+		log.Println(err)
+		c.Status(http.StatusNotFound)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Camera Not Found",
+		})
+	}
+	if camera.SourceType() != models.CameraSourceWHIP || camera.Disabled {
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: rtsp.ErrorPublisherWrongSource.Error(),
+		})
+	}
+	streamKey := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !util.SecretEquals(camera.StreamKey, streamKey) {
+		c.Status(http.StatusUnauthorized)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Invalid Stream Key",
+		})
+	}
+	offer := string(c.Body())
+	if offer == "" {
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: "Invalid Request Body",
+		})
+	}
+	publisher, answer, err := webrtc.NewWHIPPublisher(webrtc.DefaultOptions(), offer)
+	if err != nil {
+		log.Println("NewWHIPPublisher", err)
+		c.Status(http.StatusBadRequest)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	sessionId, err := rtsp.PublishWHIP(cameraId, publisher)
+	if err != nil {
+		log.Println("PublishWHIP", err)
+		publisher.Close()
+		if err == rtsp.ErrorPublisherBusy {
+			c.Status(http.StatusConflict)
+		} else {
+			c.Status(http.StatusInternalServerError)
+		}
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, "application/sdp")
+	c.Set(fiber.HeaderLocation, fmt.Sprintf("/whip/%s/%s", cameraId, sessionId))
+	c.Status(http.StatusCreated)
+	return c.SendString(answer)
+}
+
+// Auth DeleteWHIP
+//
+//	@Summary		End WHIP publish
+//	@Description	end a WHIP session, the camera waits for the next publisher
+//	@Tags			cameras,whip
+//	@Accept			json
+//	@Produce		json
+//	@Param			cameraId	path		string	true	"Camera ID"
+//	@Param			sessionId	path		string	true	"WHIP Session ID"
+//	@Success		200	{null}	    nil
+//	@Failure		400	{object}	models.ResponseErrorST
+//	@Failure		401	{object}	models.ResponseErrorST
+//	@Failure		404	{object}	models.ResponseErrorST
+//	@Failure		500	{object}	models.ResponseErrorST
+//	@Router			/whip/{cameraId}/{sessionId} [delete]
+func DeleteWHIP(c *fiber.Ctx) error {
+	err := rtsp.DeleteWHIP(c.Params("cameraId"), c.Params("sessionId"))
+	if err != nil {
+		log.Println(err)
+		c.Status(http.StatusNotFound)
+		return c.JSON(models.ResponseErrorST{
+			Error: err.Error(),
+		})
+	}
+	c.Status(http.StatusOK)
+	return c.Send(nil)
+}
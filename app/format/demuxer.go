@@ -3,7 +3,6 @@ package format
 import (
 	"bufio"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"os"
 	"time"
@@ -22,9 +21,9 @@ type Demuxer struct {
 
 const maxCapacity = 1024 * 1024 * 8
 
-func NewDemuxer(folder string, idx int8) (*Demuxer, error) {
+func NewDemuxer(folder string, segment int, idx int8) (*Demuxer, error) {
 	var codec av.CodecData
-	file, err := os.Open(folder + fmt.Sprintf("/%d", idx))
+	file, err := os.Open(segmentFile(folder, segment, idx))
 	if err != nil {
 		return nil, err
 	}
@@ -1,3 +1,88 @@
 package format
 
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deepch/vdk/av"
+)
+
 var StartCode = []byte{'\n', '\n', '\n', '\n'}
+
+func IsCodecSupported(codec av.CodecData) bool {
+	switch codec.Type() {
+	case av.H264, av.H265, av.AAC, av.PCM_ALAW, av.PCM_MULAW:
+		return true
+	default:
+		return false
+	}
+}
+
+// Segment is one header and its packets within a recording folder. A folder
+// holds a new segment each time the recording restarts or the codecs of the
+// stream change, segment 0 is stored as "<idx>" and later ones as
+// "<idx>.<segment>".
+type Segment struct {
+	Index   int
+	Streams []int8
+}
+
+func segmentFile(folder string, segment int, idx int8) string {
+	if segment == 0 {
+		return folder + fmt.Sprintf("/%d", idx)
+	}
+	return folder + fmt.Sprintf("/%d.%d", idx, segment)
+}
+
+// Segments lists the segments of a recording folder in the order they were
+// recorded.
+func Segments(folder string) ([]Segment, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	streams := make(map[int][]int8)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		idxStr, segmentStr, hasSegment := strings.Cut(entry.Name(), ".")
+		idx, err := strconv.ParseInt(idxStr, 10, 8)
+		if err != nil {
+			continue
+		}
+		segment := 0
+		if hasSegment {
+			if segment, err = strconv.Atoi(segmentStr); err != nil || segment <= 0 {
+				continue
+			}
+		}
+		streams[segment] = append(streams[segment], int8(idx))
+	}
+	segments := make([]Segment, 0, len(streams))
+	for index, idxs := range streams {
+		sort.Slice(idxs, func(i, j int) bool { return idxs[i] < idxs[j] })
+		segments = append(segments, Segment{Index: index, Streams: idxs})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Index < segments[j].Index })
+	return segments, nil
+}
+
+// OpenSegment opens a demuxer for each stream of segment.
+func OpenSegment(folder string, segment Segment) ([]*Demuxer, error) {
+	demuxers := make([]*Demuxer, 0, len(segment.Streams))
+	for _, idx := range segment.Streams {
+		demuxer, err := NewDemuxer(folder, segment.Index, idx)
+		if err != nil {
+			for _, demuxer := range demuxers {
+				demuxer.Close()
+			}
+			return nil, err
+		}
+		demuxers = append(demuxers, demuxer)
+	}
+	return demuxers, nil
+}
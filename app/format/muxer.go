@@ -2,7 +2,6 @@ package format
 
 import (
 	"encoding/binary"
-	"fmt"
 	"os"
 
 	"github.com/aicacia/streams/app/util"
@@ -11,10 +10,13 @@ import (
 )
 
 type Muxer struct {
-	folder string
-	files  []*os.File
+	folder  string
+	segment int
+	files   []*os.File
 }
 
+// NewMuxer starts a new segment in folder so it never overwrites what was
+// recorded there before.
 func NewMuxer(folder string) (*Muxer, error) {
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
 		err := os.MkdirAll(folder, os.ModePerm)
@@ -22,14 +24,22 @@ func NewMuxer(folder string) (*Muxer, error) {
 			return nil, err
 		}
 	}
-	return &Muxer{folder: folder, files: nil}, nil
+	segments, err := Segments(folder)
+	if err != nil {
+		return nil, err
+	}
+	segment := 0
+	if len(segments) > 0 {
+		segment = segments[len(segments)-1].Index + 1
+	}
+	return &Muxer{folder: folder, segment: segment, files: nil}, nil
 }
 
 func (element *Muxer) WriteHeader(streams []av.CodecData) error {
 	files := make([]*os.File, len(streams))
 
 	for idx, stream := range streams {
-		file, fileErr := os.Create(element.folder + fmt.Sprintf("/%d", idx))
+		file, fileErr := os.Create(segmentFile(element.folder, element.segment, int8(idx)))
 		if fileErr != nil {
 			return fileErr
 		}
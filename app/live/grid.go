@@ -0,0 +1,298 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/rtsp"
+	"github.com/aicacia/streams/app/services"
+	"github.com/aicacia/streams/app/webrtc"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrorGridNotFound       = errors.New("grid not found")
+	ErrorGridCameraNotFound = errors.New("grid camera not found")
+)
+
+var gridsMutex sync.RWMutex
+var grids = make(map[string]*gridST)
+
+type gridST struct {
+	mutex   sync.Mutex
+	uuid    uuid.UUID
+	muxer   *webrtc.GridMuxer
+	profile string
+	viewers map[int]*gridViewerST
+}
+
+// gridViewerST feeds one slot, cancel stops its worker and done is closed
+// once the worker can no longer touch the slot.
+type gridViewerST struct {
+	cameraId string
+	streamId string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// checkGridCameras returns ErrorGridCameraNotFound for the first camera
+// that does not exist, empty ids leave a slot empty.
+func checkGridCameras(cameraIds []string) error {
+	for _, cameraId := range cameraIds {
+		if cameraId == "" {
+			continue
+		}
+		if _, err := services.GetCamera(cameraId); err != nil {
+			return fmt.Errorf("%w %s", ErrorGridCameraNotFound, cameraId)
+		}
+	}
+	return nil
+}
+
+func NewGrid(offerBase64 string, cameraIds []string, profile string) (*models.GridAnswerST, error) {
+	if err := checkGridCameras(cameraIds); err != nil {
+		return nil, err
+	}
+	muxer := webrtc.NewGridMuxer(webrtc.DefaultOptions())
+	answer, err := muxer.WriteHeader(offerBase64)
+	if err != nil {
+		return nil, err
+	}
+	grid := &gridST{
+		uuid:    uuid.New(),
+		muxer:   muxer,
+		profile: profile,
+		viewers: make(map[int]*gridViewerST),
+	}
+	gridId := grid.uuid.String()
+	gridsMutex.Lock()
+	grids[gridId] = grid
+	gridsMutex.Unlock()
+	go gridWaitForClose(gridId, grid)
+
+	grid.assign(cameraIds)
+	return &models.GridAnswerST{
+		GridId:       gridId,
+		AnswerBase64: answer,
+		Tracks:       muxer.Tracks(),
+	}, nil
+}
+
+func UpdateGrid(gridId string, offerBase64 *string, cameraIds []string, profile *string) (*models.GridAnswerST, error) {
+	gridsMutex.RLock()
+	grid, ok := grids[gridId]
+	gridsMutex.RUnlock()
+	if !ok || grid == nil {
+		return nil, ErrorGridNotFound
+	}
+	if err := checkGridCameras(cameraIds); err != nil {
+		return nil, err
+	}
+	var answer string
+	if offerBase64 != nil {
+		var err error
+		answer, err = grid.muxer.Renegotiate(*offerBase64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if profile != nil {
+		grid.mutex.Lock()
+		grid.profile = *profile
+		grid.mutex.Unlock()
+	}
+	grid.assign(cameraIds)
+	return &models.GridAnswerST{
+		GridId:       gridId,
+		AnswerBase64: answer,
+		Tracks:       grid.muxer.Tracks(),
+	}, nil
+}
+
+func DeleteGrid(gridId string) error {
+	gridsMutex.RLock()
+	grid, ok := grids[gridId]
+	gridsMutex.RUnlock()
+	if !ok || grid == nil {
+		return ErrorGridNotFound
+	}
+	return grid.muxer.Close()
+}
+
+func gridWaitForClose(gridId string, grid *gridST) {
+	<-grid.muxer.Done()
+	grid.mutex.Lock()
+	for slot, viewer := range grid.viewers {
+		viewer.cancel()
+		delete(grid.viewers, slot)
+	}
+	grid.mutex.Unlock()
+	gridsMutex.Lock()
+	delete(grids, gridId)
+	gridsMutex.Unlock()
+	log.Printf("%s: Closed grid", gridId)
+}
+
+// assign hands the slots to cameraIds. Replaced workers are cancelled under
+// the lock and waited for without it, a worker can take up to the connect
+// timeout to notice.
+func (grid *gridST) assign(cameraIds []string) {
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+	for {
+		replaced := grid.cancelReplacedLocked(cameraIds)
+		if len(replaced) == 0 {
+			break
+		}
+		grid.mutex.Unlock()
+		for _, viewer := range replaced {
+			<-viewer.done
+		}
+		grid.mutex.Lock()
+	}
+	for slot := 0; slot < grid.muxer.SlotCount(); slot++ {
+		if _, ok := grid.viewers[slot]; ok {
+			continue
+		}
+		cameraId := gridSlotCamera(cameraIds, slot)
+		if err := grid.muxer.SetSlot(slot, cameraId); err != nil {
+			log.Printf("%s: Failed to set grid slot %d %s", grid.uuid, slot, err)
+			continue
+		}
+		if cameraId == "" {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		viewer := &gridViewerST{
+			cameraId: cameraId,
+			streamId: rtsp.StreamId(cameraId, grid.profile),
+			ctx:      ctx,
+			cancel:   cancel,
+			done:     make(chan struct{}),
+		}
+		grid.viewers[slot] = viewer
+		go gridViewerWorker(grid.muxer, slot, viewer)
+	}
+	if len(cameraIds) > grid.muxer.SlotCount() {
+		log.Printf("%s: Grid has %d slots for %d cameras, renegotiate with more video transceivers", grid.uuid, grid.muxer.SlotCount(), len(cameraIds))
+	}
+}
+
+func gridSlotCamera(cameraIds []string, slot int) string {
+	if slot < len(cameraIds) {
+		return cameraIds[slot]
+	}
+	return ""
+}
+
+// cancelReplacedLocked removes the viewers of the slots that get another
+// stream and returns them once cancelled.
+func (grid *gridST) cancelReplacedLocked(cameraIds []string) []*gridViewerST {
+	var replaced []*gridViewerST
+	for slot, viewer := range grid.viewers {
+		if viewer.streamId == rtsp.StreamId(gridSlotCamera(cameraIds, slot), grid.profile) {
+			continue
+		}
+		viewer.cancel()
+		delete(grid.viewers, slot)
+		replaced = append(replaced, viewer)
+	}
+	return replaced
+}
+
+const (
+	gridRetryMin = time.Second
+	gridRetryMax = 30 * time.Second
+)
+
+// gridViewerWorker feeds a slot until the grid moves on, when the stream
+// goes away the slot waits for it to come back.
+func gridViewerWorker(muxer *webrtc.GridMuxer, slot int, viewer *gridViewerST) {
+	defer close(viewer.done)
+	backoff := gridRetryMin
+	for {
+		streamed, retry := gridViewerStream(muxer, slot, viewer)
+		if !retry {
+			return
+		}
+		wait := time.Duration(0)
+		if streamed {
+			backoff = gridRetryMin
+		} else {
+			wait = backoff
+			if backoff < gridRetryMax {
+				backoff *= 2
+			}
+		}
+		select {
+		case <-viewer.ctx.Done():
+			return
+		case <-muxer.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// gridViewerStream feeds the slot with the stream until the stream goes
+// away or its codecs change, streamed is true once the slot got video.
+// Unless the slot is done it is retried.
+func gridViewerStream(muxer *webrtc.GridMuxer, slot int, viewer *gridViewerST) (streamed bool, retry bool) {
+	ctx, cancel := context.WithTimeout(viewer.ctx, time.Duration(config.Config.RTSP.Connect.Timeout.Seconds)*time.Second)
+	codecs := rtsp.WaitForCodecs(ctx, viewer.streamId)
+	cancel()
+	if viewer.ctx.Err() != nil {
+		return false, false
+	}
+	if codecs == nil {
+		log.Printf("%s: Stream Codec Not Found for grid slot %d", viewer.streamId, slot)
+		return false, true
+	}
+	videoIdx := int8(-1)
+	for idx, codec := range codecs {
+		if codec.Type() == av.H264 {
+			videoIdx = int8(idx)
+			muxer.SetSlotCodec(slot, codec.(h264parser.CodecData))
+			break
+		}
+	}
+	if videoIdx < 0 {
+		log.Printf("%s: No H264 video for grid slot %d", viewer.streamId, slot)
+		return false, true
+	}
+	// the socket closes on a codec change so the slot codec is read again
+	cameraViewer := rtsp.AddCodecsViewer(viewer.streamId)
+	if cameraViewer == nil {
+		log.Printf("%s: Failed to create viewer for grid slot %d", viewer.streamId, slot)
+		return false, true
+	}
+	defer rtsp.DeleteViewer(viewer.streamId, &cameraViewer.Uuid)
+
+	for {
+		select {
+		case <-viewer.ctx.Done():
+			return streamed, false
+		case packet, ok := <-cameraViewer.Socket:
+			if !ok {
+				return streamed || cameraViewer.CodecsChanged(), true
+			}
+			if packet.Idx != videoIdx {
+				continue
+			}
+			if err := muxer.WritePacket(slot, *packet); err != nil {
+				log.Println("WritePacket", err)
+				return streamed, false
+			}
+			streamed = true
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package live
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/webrtc"
+	pion "github.com/pion/webrtc/v3"
+)
+
+// dataChannelOffer is an offer without video transceivers.
+func dataChannelOffer(t *testing.T) string {
+	t.Helper()
+	pc, err := pion.NewPeerConnection(pion.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	if _, err := pc.CreateDataChannel("grid", nil); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(offer.SDP))
+}
+
+func TestNewGridErrors(t *testing.T) {
+	config.Config.Cameras.Folder = t.TempDir()
+	tests := []struct {
+		name      string
+		offer     string
+		cameraIds []string
+		err       error
+	}{
+		{name: "invalid offer", offer: "not base64!", err: webrtc.ErrorGridInvalidOffer},
+		{name: "no slots", offer: dataChannelOffer(t), err: webrtc.ErrorGridNoSlots},
+		{name: "unknown camera", offer: dataChannelOffer(t), cameraIds: []string{"", "missing"}, err: ErrorGridCameraNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewGrid(test.offer, test.cameraIds, ""); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// videoOffer is an offer with one recvonly video transceiver.
+func videoOffer(t *testing.T) string {
+	t.Helper()
+	pc, err := pion.NewPeerConnection(pion.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	if _, err := pc.AddTransceiverFromKind(pion.RTPCodecTypeVideo, pion.RTPTransceiverInit{Direction: pion.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(offer.SDP))
+}
+
+// TestGridViewerStop checks a worker of a missing stream keeps retrying
+// until it is cancelled, so the slot can be handed to the next camera.
+func TestGridViewerStop(t *testing.T) {
+	config.Config.RTSP.Connect.Timeout.Seconds = 30
+	muxer := webrtc.NewGridMuxer(webrtc.DefaultOptions())
+	defer muxer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	viewer := &gridViewerST{
+		cameraId: "missing",
+		streamId: "missing",
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	if streamed, retry := gridViewerStream(muxer, 0, viewer); streamed || !retry {
+		t.Fatalf("expected a missing stream to be retried, got streamed %v retry %v", streamed, retry)
+	}
+	go gridViewerWorker(muxer, 0, viewer)
+	select {
+	case <-viewer.done:
+		t.Fatal("grid viewer gave up on a missing stream")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-viewer.done:
+	case <-time.After(time.Second):
+		t.Fatal("grid viewer did not stop")
+	}
+}
+
+// TestGridAssignUnlocked replaces a slot whose worker is slow to stop, the
+// grid must stay usable while assign waits for it.
+func TestGridAssignUnlocked(t *testing.T) {
+	muxer := webrtc.NewGridMuxer(webrtc.DefaultOptions())
+	defer muxer.Close()
+	if _, err := muxer.WriteHeader(videoOffer(t)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := &gridViewerST{
+		cameraId: "slow",
+		streamId: "slow",
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	grid := &gridST{muxer: muxer, viewers: map[int]*gridViewerST{0: slow}}
+	assigned := make(chan struct{})
+	go func() {
+		grid.assign([]string{"next"})
+		close(assigned)
+	}()
+	<-ctx.Done()
+	locked := make(chan struct{})
+	go func() {
+		grid.mutex.Lock()
+		grid.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("assign held the grid while waiting for the worker")
+	}
+	select {
+	case <-assigned:
+		t.Fatal("assign did not wait for the worker")
+	default:
+	}
+	close(slow.done)
+	select {
+	case <-assigned:
+	case <-time.After(time.Second):
+		t.Fatal("assign did not finish")
+	}
+	grid.mutex.Lock()
+	defer grid.mutex.Unlock()
+	if viewer, ok := grid.viewers[0]; !ok || viewer.cameraId != "next" {
+		t.Fatalf("expected the slot to be handed to next, got %v", grid.viewers[0])
+	}
+}
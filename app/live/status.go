@@ -0,0 +1,27 @@
+package live
+
+import (
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/rtsp"
+)
+
+func CameraStatus(camera *models.CameraST) models.CameraStatusST {
+	profiles := []string{models.CameraProfileMain}
+	for _, profile := range camera.Profiles {
+		profiles = append(profiles, profile.Name)
+	}
+	streams := make([]models.StreamStatusST, 0, len(profiles))
+	for _, profile := range profiles {
+		streamId := rtsp.StreamId(camera.Id, profile)
+		stream := rtsp.GetStreamStatus(streamId)
+		stream.Profile = profile
+		stream.Tracks = CodecsToTracks(rtsp.GetCurrentCodecs(streamId))
+		streams = append(streams, stream)
+	}
+	return models.CameraStatusST{
+		CameraId:  camera.Id,
+		Disabled:  camera.Disabled,
+		Recording: rtsp.IsRecording(camera.Id),
+		Streams:   streams,
+	}
+}
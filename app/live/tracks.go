@@ -0,0 +1,71 @@
+package live
+
+import (
+	"github.com/aicacia/streams/app/format"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/transcode"
+	"github.com/aicacia/streams/app/webrtc"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+)
+
+func CodecsToTracks(codecs []av.CodecData) []models.TrackST {
+	tracks := make([]models.TrackST, 0, len(codecs))
+	for idx, codec := range codecs {
+		track := models.TrackST{
+			Idx:     int8(idx),
+			Codec:   codec.Type().String(),
+			Outputs: codecOutputs(codec),
+			Reason:  codecReason(codec),
+		}
+		if codec.Type().IsVideo() {
+			track.Type = "video"
+		} else {
+			track.Type = "audio"
+		}
+		switch c := codec.(type) {
+		case h264parser.CodecData:
+			track.Width = c.Width()
+			track.Height = c.Height()
+			track.FPS = c.FPS()
+		case h265parser.CodecData:
+			track.Width = c.Width()
+			track.Height = c.Height()
+			track.FPS = c.FPS()
+		case av.AudioCodecData:
+			track.SampleRate = c.SampleRate()
+			track.Channels = c.ChannelLayout().Count()
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// codecReason tells why a track has no webrtc output, an installed
+// transcoder would make it playable.
+func codecReason(codec av.CodecData) string {
+	if webrtc.IsCodecSupported(codec) {
+		return ""
+	}
+	switch transcode.CheckAudioTranscoder(codec) {
+	case transcode.ErrorTranscoderUnavailable, transcode.ErrorTranscoderDisabled:
+		return models.TrackReasonTranscoderUnavailable
+	default:
+		return models.TrackReasonCodecNotSupported
+	}
+}
+
+func codecOutputs(codec av.CodecData) []string {
+	outputs := make([]string, 0)
+	if webrtc.IsCodecSupported(codec) {
+		outputs = append(outputs, models.OutputWebRTC)
+	}
+	if webrtc.IsGridCodecSupported(codec) {
+		outputs = append(outputs, models.OutputWebRTCGrid)
+	}
+	if format.IsCodecSupported(codec) {
+		outputs = append(outputs, models.OutputRecording)
+	}
+	return outputs
+}
@@ -0,0 +1,47 @@
+package live
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/aacparser"
+)
+
+func TestCodecReason(t *testing.T) {
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name    string
+		codec   av.CodecData
+		enabled bool
+		ffmpeg  string
+		reason  string
+	}{
+		{name: "native", codec: codec.NewPCMAlawCodecData(), reason: ""},
+		{name: "transcoded", codec: aac, enabled: true, ffmpeg: ffmpeg, reason: ""},
+		{name: "no ffmpeg", codec: aac, enabled: true, ffmpeg: missing, reason: models.TrackReasonTranscoderUnavailable},
+		{name: "transcoding disabled", codec: aac, ffmpeg: ffmpeg, reason: models.TrackReasonTranscoderUnavailable},
+		{name: "no transcoder", codec: codec.NewSpeexCodecData(16000, av.CH_MONO), enabled: true, ffmpeg: ffmpeg, reason: models.TrackReasonCodecNotSupported},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Config.Transcode.Audio.Enabled = test.enabled
+			config.Config.Transcode.Audio.FFmpeg = test.ffmpeg
+			if reason := codecReason(test.codec); reason != test.reason {
+				t.Fatalf("expected %q, got %q", test.reason, reason)
+			}
+		})
+	}
+}
@@ -2,13 +2,117 @@ package models
 
 import "time"
 
+const (
+	CameraSourceRTSP = "rtsp"
+	CameraSourceRTMP = "rtmp"
+	CameraSourceWHIP = "whip"
+	CameraSourceFile = "file"
+)
+
 type CameraST struct {
-	Id        string    `json:"id"validate:"required"`
-	Name      string    `json:"name"validate:"required"`
-	Url       string    `json:"url"validate:"required"`
-	RtspUrl   string    `json:"rtsp_url"validate:"required"`
-	Disabled  bool      `json:"disabled"validate:"required"`
-	Recording bool      `json:"recording"validate:"required"`
-	CreatedTs time.Time `json:"created_ts"validate:"required"`
-	UpdatedTs time.Time `json:"updated_ts"validate:"required"`
+	Id            string               `json:"id"validate:"required"`
+	Name          string               `json:"name"validate:"required"`
+	Url           string               `json:"url"validate:"required"`
+	RtspUrl       string               `json:"rtsp_url"validate:"required"`
+	Source        string               `json:"source" validate:"required"`
+	StreamKey     string               `json:"stream_key,omitempty"`
+	File          *CameraFileST        `json:"file,omitempty"`
+	RtspOptions   *CameraRtspOptionsST `json:"rtsp_options,omitempty"`
+	Profiles      []CameraProfileST    `json:"profiles,omitempty"`
+	RecordProfile string               `json:"record_profile,omitempty"`
+	OnDemand      bool                 `json:"on_demand"`
+	Onvif         *CameraOnvifST       `json:"onvif,omitempty"`
+	Pipeline      []CameraProcessorST  `json:"pipeline,omitempty"`
+	Disabled      bool                 `json:"disabled"validate:"required"`
+	Recording     bool                 `json:"recording"validate:"required"`
+	CreatedTs     time.Time            `json:"created_ts"validate:"required"`
+	UpdatedTs     time.Time            `json:"updated_ts"validate:"required"`
+}
+
+// CameraFileST loops a local MP4/TS file at Path inside the media folder, or
+// the recordings of CameraId between Start and End.
+type CameraFileST struct {
+	Path     string     `json:"path,omitempty"`
+	CameraId string     `json:"camera_id,omitempty"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+// CameraProcessorST is a stage of the packet pipeline of a camera, Name is
+// a registered processor and Options are passed to it as is.
+type CameraProcessorST struct {
+	Name    string            `json:"name" validate:"required"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+const CameraProfileMain = "main"
+
+// CameraProfileST is an extra stream of a camera, like a low resolution
+// sub stream, the camera RtspUrl is always the main profile.
+type CameraProfileST struct {
+	Name        string               `json:"name" validate:"required"`
+	RtspUrl     string               `json:"rtsp_url" validate:"required"`
+	RtspOptions *CameraRtspOptionsST `json:"rtsp_options,omitempty"`
+}
+
+const (
+	RtspTransportTCP = "tcp"
+	RtspTransportUDP = "udp"
+)
+
+// CameraRtspOptionsST overrides the rtsp config for one camera, CACert is
+// a PEM bundle used to verify rtsps cameras.
+type CameraRtspOptionsST struct {
+	Transport             string `json:"transport,omitempty"`
+	DisableAudio          bool   `json:"disable_audio,omitempty"`
+	InsecureSkipVerify    bool   `json:"insecure_skip_verify,omitempty"`
+	CACert                string `json:"ca_cert,omitempty"`
+	ConnectTimeoutSeconds int    `json:"connect_timeout_seconds,omitempty"`
+	IOTimeoutSeconds      int    `json:"io_timeout_seconds,omitempty"`
+	Debug                 *bool  `json:"debug,omitempty"`
+}
+
+func (camera *CameraST) SourceType() string {
+	if camera.Source == "" {
+		return CameraSourceRTSP
+	}
+	return camera.Source
+}
+
+func (camera *CameraST) IsPublished() bool {
+	switch camera.SourceType() {
+	case CameraSourceRTMP, CameraSourceWHIP:
+		return true
+	default:
+		return false
+	}
+}
+
+// Redacted is the camera as returned by the API, without the write only
+// onvif password.
+func (camera *CameraST) Redacted() *CameraST {
+	redacted := *camera
+	if camera.Onvif != nil {
+		onvif := *camera.Onvif
+		onvif.Password = ""
+		redacted.Onvif = &onvif
+	}
+	return &redacted
+}
+
+func (camera *CameraST) GetProfile(name string) (*CameraProfileST, bool) {
+	for i := range camera.Profiles {
+		if camera.Profiles[i].Name == name {
+			return &camera.Profiles[i], true
+		}
+	}
+	return nil, false
+}
+
+func (camera *CameraST) HasProfile(name string) bool {
+	if name == "" || name == CameraProfileMain {
+		return true
+	}
+	_, ok := camera.GetProfile(name)
+	return ok
 }
@@ -0,0 +1,33 @@
+package models
+
+const (
+	OutputWebRTC     = "webrtc"
+	OutputWebRTCGrid = "webrtc_grid"
+	OutputRecording  = "recording"
+)
+
+// reasons a track can not be played live
+const (
+	TrackReasonCodecNotSupported     = "codec not supported"
+	TrackReasonTranscoderUnavailable = "transcoder unavailable"
+)
+
+type TrackST struct {
+	Idx        int8     `json:"idx" validate:"required"`
+	Type       string   `json:"type" validate:"required"`
+	Codec      string   `json:"codec" validate:"required"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	FPS        int      `json:"fps,omitempty"`
+	SampleRate int      `json:"sample_rate,omitempty"`
+	Channels   int      `json:"channels,omitempty"`
+	Outputs    []string `json:"outputs" validate:"required"`
+	// Reason is set when the track can not be played live over webrtc
+	Reason string `json:"reason,omitempty"`
+}
+
+type LiveST struct {
+	CameraId string    `json:"camera_id" validate:"required"`
+	Profile  string    `json:"profile" validate:"required"`
+	Tracks   []TrackST `json:"tracks" validate:"required"`
+}
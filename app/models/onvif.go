@@ -0,0 +1,82 @@
+package models
+
+type DiscoverBodyST struct {
+	TimeoutMS int    `json:"timeout_ms"`
+	Address   string `json:"address"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Create    bool   `json:"create"`
+}
+
+type OnvifProfileST struct {
+	Token       string `json:"token" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	Encoding    string `json:"encoding"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	FrameRate   int    `json:"frame_rate"`
+	RtspUrl     string `json:"rtsp_url"`
+	SnapshotUrl string `json:"snapshot_url,omitempty"`
+}
+
+type OnvifDeviceST struct {
+	Address      string           `json:"address" validate:"required"`
+	XAddr        string           `json:"xaddr" validate:"required"`
+	Name         string           `json:"name"`
+	Hardware     string           `json:"hardware"`
+	Manufacturer string           `json:"manufacturer,omitempty"`
+	Model        string           `json:"model,omitempty"`
+	SerialNumber string           `json:"serial_number,omitempty"`
+	Profiles     []OnvifProfileST `json:"profiles" validate:"required"`
+}
+
+// CameraOnvifST is how to reach the ONVIF services of a camera, the PTZ
+// fields are read from the device when the camera is saved and PTZ is nil
+// while the device could not be reached. Password is write only, it is left
+// out of cameras returned by the API.
+type CameraOnvifST struct {
+	XAddr        string             `json:"xaddr" validate:"required"`
+	Username     string             `json:"username,omitempty"`
+	Password     string             `json:"password,omitempty"`
+	ProfileToken string             `json:"profile_token,omitempty"`
+	PTZXAddr     string             `json:"ptz_xaddr,omitempty"`
+	PTZ          *PTZCapabilitiesST `json:"ptz,omitempty"`
+}
+
+type PTZCapabilitiesST struct {
+	ContinuousMove bool `json:"continuous_move"`
+	RelativeMove   bool `json:"relative_move"`
+	AbsoluteMove   bool `json:"absolute_move"`
+	Zoom           bool `json:"zoom"`
+	Presets        bool `json:"presets"`
+}
+
+const (
+	PTZActionContinuousMove = "continuous_move"
+	PTZActionRelativeMove   = "relative_move"
+	PTZActionAbsoluteMove   = "absolute_move"
+	PTZActionStop           = "stop"
+	PTZActionZoom           = "zoom"
+	PTZActionGetPresets     = "get_presets"
+	PTZActionGotoPreset     = "goto_preset"
+)
+
+type PTZBodyST struct {
+	Action      string   `json:"action" validate:"required"`
+	Pan         *float64 `json:"pan"`
+	Tilt        *float64 `json:"tilt"`
+	Zoom        *float64 `json:"zoom"`
+	Speed       *float64 `json:"speed"`
+	TimeoutMS   int      `json:"timeout_ms"`
+	PresetToken string   `json:"preset_token"`
+}
+
+type PTZPresetST struct {
+	Token string `json:"token" validate:"required"`
+	Name  string `json:"name"`
+}
+
+type PTZResponseST struct {
+	Action  string        `json:"action" validate:"required"`
+	Presets []PTZPresetST `json:"presets,omitempty"`
+}
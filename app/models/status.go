@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type StreamStatusST struct {
+	StreamId         string     `json:"stream_id" validate:"required"`
+	Profile          string     `json:"profile" validate:"required"`
+	State            string     `json:"state" validate:"required"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastErrorTs      *time.Time `json:"last_error_ts,omitempty"`
+	Reconnects       uint64     `json:"reconnects"`
+	BackoffSeconds   float64    `json:"backoff_seconds"`
+	ConnectedTs      *time.Time `json:"connected_ts,omitempty"`
+	UptimeSeconds    float64    `json:"uptime_seconds"`
+	Tracks           []TrackST  `json:"tracks"`
+	BitrateKbps      float64    `json:"bitrate_kbps"`
+	PacketsPerSecond float64    `json:"packets_per_second"`
+	FPS              float64    `json:"fps"`
+	Viewers          int        `json:"viewers"`
+	// DroppedPackets and DroppedGOPs add up the viewers of the stream that
+	// fell behind, the ones that left included
+	DroppedPackets uint64 `json:"dropped_packets"`
+	DroppedGOPs    uint64 `json:"dropped_gops"`
+}
+
+type CameraStatusST struct {
+	CameraId  string           `json:"camera_id" validate:"required"`
+	Disabled  bool             `json:"disabled"`
+	Recording bool             `json:"recording"`
+	Streams   []StreamStatusST `json:"streams" validate:"required"`
+}
@@ -7,3 +7,40 @@ type OfferBodyST struct {
 type AnswerST struct {
 	AnswerBase64 string `json:"answer_base64"validate:"required"`
 }
+
+type GridOfferBodyST struct {
+	OfferBase64 string   `json:"offer_base64" validate:"required"`
+	CameraIds   []string `json:"camera_ids" validate:"required"`
+	Profile     string   `json:"profile"`
+}
+
+type GridUpdateBodyST struct {
+	OfferBase64 *string  `json:"offer_base64"`
+	CameraIds   []string `json:"camera_ids" validate:"required"`
+	Profile     *string  `json:"profile"`
+}
+
+type GridTrackST struct {
+	Slot     int    `json:"slot" validate:"required"`
+	Mid      string `json:"mid" validate:"required"`
+	TrackId  string `json:"track_id" validate:"required"`
+	CameraId string `json:"camera_id"`
+}
+
+type GridAnswerST struct {
+	GridId       string        `json:"grid_id" validate:"required"`
+	AnswerBase64 string        `json:"answer_base64"`
+	Tracks       []GridTrackST `json:"tracks" validate:"required"`
+}
+
+type GridTracksMessageST struct {
+	Type   string        `json:"type" validate:"required"`
+	Tracks []GridTrackST `json:"tracks" validate:"required"`
+}
+
+type FrameMetadataST struct {
+	Type       string `json:"type" validate:"required"`
+	Idx        int8   `json:"idx"`
+	Time       int64  `json:"time" validate:"required"`
+	ServerTime int64  `json:"server_time" validate:"required"`
+}
@@ -0,0 +1,78 @@
+package onvif
+
+import (
+	"time"
+)
+
+const deviceNamespace = `xmlns:tds="http://www.onvif.org/ver10/device/wsdl"`
+
+type CapabilitiesST struct {
+	Media struct {
+		XAddr string `xml:"XAddr"`
+	} `xml:"Media"`
+	PTZ struct {
+		XAddr string `xml:"XAddr"`
+	} `xml:"PTZ"`
+}
+
+type DeviceInformationST struct {
+	Manufacturer    string `xml:"Manufacturer"`
+	Model           string `xml:"Model"`
+	FirmwareVersion string `xml:"FirmwareVersion"`
+	SerialNumber    string `xml:"SerialNumber"`
+}
+
+// SyncTime reads the device clock so the UsernameToken created time
+// matches it, cameras reject digests from a skewed clock.
+func (c *Client) SyncTime() error {
+	var response struct {
+		UTCDateTime struct {
+			Date struct {
+				Year  int `xml:"Year"`
+				Month int `xml:"Month"`
+				Day   int `xml:"Day"`
+			} `xml:"Date"`
+			Time struct {
+				Hour   int `xml:"Hour"`
+				Minute int `xml:"Minute"`
+				Second int `xml:"Second"`
+			} `xml:"Time"`
+		} `xml:"GetSystemDateAndTimeResponse>SystemDateAndTime>UTCDateTime"`
+	}
+	username := c.username
+	c.username = ""
+	err := c.Call(c.xaddr, `<tds:GetSystemDateAndTime `+deviceNamespace+`/>`, &response)
+	c.username = username
+	if err != nil {
+		return err
+	}
+	utc := response.UTCDateTime
+	if utc.Date.Year == 0 {
+		return nil
+	}
+	deviceTime := time.Date(utc.Date.Year, time.Month(utc.Date.Month), utc.Date.Day, utc.Time.Hour, utc.Time.Minute, utc.Time.Second, 0, time.UTC)
+	c.mutex.Lock()
+	c.timeOffset = time.Until(deviceTime)
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *Client) GetCapabilities() (*CapabilitiesST, error) {
+	var response struct {
+		Capabilities CapabilitiesST `xml:"GetCapabilitiesResponse>Capabilities"`
+	}
+	if err := c.Call(c.xaddr, `<tds:GetCapabilities `+deviceNamespace+`><tds:Category>All</tds:Category></tds:GetCapabilities>`, &response); err != nil {
+		return nil, err
+	}
+	return &response.Capabilities, nil
+}
+
+func (c *Client) GetDeviceInformation() (*DeviceInformationST, error) {
+	var response struct {
+		Information DeviceInformationST `xml:"GetDeviceInformationResponse"`
+	}
+	if err := c.Call(c.xaddr, `<tds:GetDeviceInformation `+deviceNamespace+`/>`, &response); err != nil {
+		return nil, err
+	}
+	return &response.Information, nil
+}
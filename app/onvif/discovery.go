@@ -0,0 +1,108 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	wsDiscoveryAddr       = "239.255.255.250:3702"
+	wsDiscoveryBufferSize = 65536
+)
+
+// ProbeMatchST is a device that answered a WS-Discovery probe.
+type ProbeMatchST struct {
+	Address  string
+	XAddrs   []string
+	Name     string
+	Hardware string
+}
+
+type probeMatchesST struct {
+	Matches []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+func probeMessage() string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<s:Header><a:MessageID>uuid:` + uuid.New().String() + `</a:MessageID>` +
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>` +
+		`<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action></s:Header>` +
+		`<s:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></s:Body></s:Envelope>`
+}
+
+func scopeValue(scopes []string, key string) string {
+	prefix := "onvif://www.onvif.org/" + key + "/"
+	for _, scope := range scopes {
+		if strings.HasPrefix(scope, prefix) {
+			value, err := url.PathUnescape(strings.TrimPrefix(scope, prefix))
+			if err != nil {
+				return strings.TrimPrefix(scope, prefix)
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// Probe multicasts a WS-Discovery probe to addr, or the standard address
+// when empty, and collects the answers until timeout.
+func Probe(addr string, timeout time.Duration) ([]ProbeMatchST, error) {
+	if addr == "" {
+		addr = wsDiscoveryAddr
+	}
+	remote, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP([]byte(probeMessage()), remote); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	seen := make(map[string]bool)
+	var matches []ProbeMatchST
+	buf := make([]byte, wsDiscoveryBufferSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return matches, nil
+			}
+			return matches, err
+		}
+		var parsed probeMatchesST
+		if err := xml.Unmarshal(buf[:n], &parsed); err != nil {
+			continue
+		}
+		for _, match := range parsed.Matches {
+			address := strings.TrimSpace(match.Address)
+			xaddrs := strings.Fields(match.XAddrs)
+			if len(xaddrs) == 0 || seen[address] {
+				continue
+			}
+			seen[address] = true
+			scopes := strings.Fields(match.Scopes)
+			matches = append(matches, ProbeMatchST{
+				Address:  address,
+				XAddrs:   xaddrs,
+				Name:     scopeValue(scopes, "name"),
+				Hardware: scopeValue(scopes, "hardware"),
+			})
+		}
+	}
+}
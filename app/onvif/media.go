@@ -0,0 +1,55 @@
+package onvif
+
+const mediaNamespace = `xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema"`
+
+type ProfileST struct {
+	Token                     string `xml:"token,attr"`
+	Name                      string `xml:"Name"`
+	VideoEncoderConfiguration struct {
+		Encoding   string `xml:"Encoding"`
+		Resolution struct {
+			Width  int `xml:"Width"`
+			Height int `xml:"Height"`
+		} `xml:"Resolution"`
+		RateControl struct {
+			FrameRateLimit int `xml:"FrameRateLimit"`
+		} `xml:"RateControl"`
+	} `xml:"VideoEncoderConfiguration"`
+	PTZConfiguration struct {
+		Token string `xml:"token,attr"`
+	} `xml:"PTZConfiguration"`
+}
+
+func (c *Client) GetProfiles(mediaXAddr string) ([]ProfileST, error) {
+	var response struct {
+		Profiles []ProfileST `xml:"GetProfilesResponse>Profiles"`
+	}
+	if err := c.Call(mediaXAddr, `<trt:GetProfiles `+mediaNamespace+`/>`, &response); err != nil {
+		return nil, err
+	}
+	return response.Profiles, nil
+}
+
+func (c *Client) GetStreamUri(mediaXAddr, profileToken string) (string, error) {
+	var response struct {
+		Uri string `xml:"GetStreamUriResponse>MediaUri>Uri"`
+	}
+	body := `<trt:GetStreamUri ` + mediaNamespace + `>` +
+		`<trt:StreamSetup><tt:Stream>RTP-Unicast</tt:Stream><tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport></trt:StreamSetup>` +
+		`<trt:ProfileToken>` + escape(profileToken) + `</trt:ProfileToken></trt:GetStreamUri>`
+	if err := c.Call(mediaXAddr, body, &response); err != nil {
+		return "", err
+	}
+	return response.Uri, nil
+}
+
+func (c *Client) GetSnapshotUri(mediaXAddr, profileToken string) (string, error) {
+	var response struct {
+		Uri string `xml:"GetSnapshotUriResponse>MediaUri>Uri"`
+	}
+	body := `<trt:GetSnapshotUri ` + mediaNamespace + `><trt:ProfileToken>` + escape(profileToken) + `</trt:ProfileToken></trt:GetSnapshotUri>`
+	if err := c.Call(mediaXAddr, body, &response); err != nil {
+		return "", err
+	}
+	return response.Uri, nil
+}
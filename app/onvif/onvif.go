@@ -0,0 +1,124 @@
+package onvif
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/util"
+)
+
+var (
+	ErrorSOAPFault = errors.New("onvif soap fault")
+)
+
+const (
+	soapContentType  = "application/soap+xml; charset=utf-8"
+	maxResponseBytes = 4 * 1024 * 1024
+)
+
+// Client calls the SOAP services of one ONVIF device, requests are signed
+// with a WS-Security UsernameToken digest when a username is set.
+type Client struct {
+	mutex      sync.RWMutex
+	xaddr      string
+	username   string
+	password   string
+	timeOffset time.Duration
+	http       http.Client
+}
+
+func NewClient(xaddr, username, password string, timeout time.Duration) *Client {
+	client := util.NewInsecureClient()
+	client.Timeout = timeout
+	return &Client{
+		xaddr:    xaddr,
+		username: username,
+		password: password,
+		http:     client,
+	}
+}
+
+func (c *Client) XAddr() string {
+	return c.xaddr
+}
+
+type faultST struct {
+	Reason string `xml:"Reason>Text"`
+	Code   string `xml:"Code>Subcode>Value"`
+}
+
+type envelopeST struct {
+	Body struct {
+		Fault   *faultST `xml:"Fault"`
+		Content []byte   `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func (c *Client) securityHeader() string {
+	if c.username == "" {
+		return ""
+	}
+	c.mutex.RLock()
+	created := time.Now().Add(c.timeOffset).UTC().Format(time.RFC3339)
+	c.mutex.RUnlock()
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(created))
+	hash.Write([]byte(c.password))
+	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	return `<s:Header><wsse:Security s:mustUnderstand="1" xmlns:wsse="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd" xmlns:wsu="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+		`<wsse:UsernameToken><wsse:Username>` + escape(c.username) + `</wsse:Username>` +
+		`<wsse:Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</wsse:Password>` +
+		`<wsse:Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` + base64.StdEncoding.EncodeToString(nonce) + `</wsse:Nonce>` +
+		`<wsu:Created>` + created + `</wsu:Created></wsse:UsernameToken></wsse:Security></s:Header>`
+}
+
+// Call posts body to the service at url and decodes the content of the
+// response body into response.
+func (c *Client) Call(url, body string, response any) error {
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		c.securityHeader() +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+	res, err := c.http.Post(url, soapContentType, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	bytes, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	var parsed envelopeST
+	if err := xml.Unmarshal(bytes, &parsed); err != nil {
+		return fmt.Errorf("onvif %s: %s %w", url, res.Status, err)
+	}
+	if parsed.Body.Fault != nil {
+		return fmt.Errorf("%w %s %s", ErrorSOAPFault, strings.TrimSpace(parsed.Body.Fault.Code), strings.TrimSpace(parsed.Body.Fault.Reason))
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("onvif %s: %s", url, res.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return xml.Unmarshal([]byte("<Body>"+string(parsed.Body.Content)+"</Body>"), response)
+}
@@ -0,0 +1,230 @@
+// Package onviftest provides a fake ONVIF device and WS-Discovery responder
+// for tests.
+package onviftest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envelopeStart = `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl"><s:Body>`
+	envelopeEnd   = `</s:Body></s:Envelope>`
+)
+
+// CallST is a SOAP request the device received, Action is the name of the
+// first element of the body.
+type CallST struct {
+	Path   string
+	Action string
+	Body   string
+}
+
+// DeviceST is an ONVIF device with a media and PTZ service. It has a main
+// profile with PTZ and a sub profile, and answers PTZ commands with an
+// empty response.
+type DeviceST struct {
+	Server *httptest.Server
+	mutex  sync.Mutex
+	calls  []CallST
+}
+
+func NewDevice() *DeviceST {
+	device := &DeviceST{}
+	device.Server = httptest.NewServer(http.HandlerFunc(device.serve))
+	return device
+}
+
+// XAddr is the device service address.
+func (device *DeviceST) XAddr() string {
+	return device.Server.URL + "/onvif/device_service"
+}
+
+func (device *DeviceST) MediaXAddr() string {
+	return device.Server.URL + "/onvif/media"
+}
+
+func (device *DeviceST) PTZXAddr() string {
+	return device.Server.URL + "/onvif/ptz"
+}
+
+// RtspUrl is the stream uri of the profile with token.
+func (device *DeviceST) RtspUrl(token string) string {
+	return "rtsp://" + device.Server.Listener.Addr().String() + "/" + token
+}
+
+func (device *DeviceST) Close() {
+	device.Server.Close()
+}
+
+// Calls returns the requests received so far.
+func (device *DeviceST) Calls() []CallST {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return append([]CallST(nil), device.calls...)
+}
+
+// Count is the number of requests received for action.
+func (device *DeviceST) Count(action string) int {
+	count := 0
+	for _, call := range device.Calls() {
+		if call.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func bodyAction(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	inBody := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if inBody {
+				return start.Name.Local
+			}
+			inBody = start.Name.Local == "Body"
+		}
+	}
+}
+
+func (device *DeviceST) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	action := bodyAction(body)
+	device.mutex.Lock()
+	device.calls = append(device.calls, CallST{Path: r.URL.Path, Action: action, Body: string(body)})
+	device.mutex.Unlock()
+
+	response, ok := device.response(action, string(body))
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		response = `<s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>ter:ActionNotSupported</s:Value></s:Subcode></s:Code><s:Reason><s:Text>` + action + ` not supported</s:Text></s:Reason></s:Fault>`
+	}
+	io.WriteString(w, envelopeStart+response+envelopeEnd)
+}
+
+func (device *DeviceST) response(action, body string) (string, bool) {
+	switch action {
+	case "GetSystemDateAndTime":
+		now := time.Now().UTC()
+		return fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime><tt:UTCDateTime>`+
+			`<tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+			`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date>`+
+			`</tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+			now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day()), true
+	case "GetCapabilities":
+		return `<tds:GetCapabilitiesResponse><tds:Capabilities>` +
+			`<tt:Media><tt:XAddr>` + device.MediaXAddr() + `</tt:XAddr></tt:Media>` +
+			`<tt:PTZ><tt:XAddr>` + device.PTZXAddr() + `</tt:XAddr></tt:PTZ>` +
+			`</tds:Capabilities></tds:GetCapabilitiesResponse>`, true
+	case "GetDeviceInformation":
+		return `<tds:GetDeviceInformationResponse><tds:Manufacturer>Fake</tds:Manufacturer><tds:Model>Camera</tds:Model>` +
+			`<tds:FirmwareVersion>1.0</tds:FirmwareVersion><tds:SerialNumber>0001</tds:SerialNumber></tds:GetDeviceInformationResponse>`, true
+	case "GetProfiles":
+		return `<trt:GetProfilesResponse>` +
+			`<trt:Profiles token="profile_1"><tt:Name>Main</tt:Name><tt:VideoEncoderConfiguration><tt:Encoding>H264</tt:Encoding>` +
+			`<tt:Resolution><tt:Width>1920</tt:Width><tt:Height>1080</tt:Height></tt:Resolution>` +
+			`<tt:RateControl><tt:FrameRateLimit>25</tt:FrameRateLimit></tt:RateControl></tt:VideoEncoderConfiguration>` +
+			`<tt:PTZConfiguration token="ptz_1"/></trt:Profiles>` +
+			`<trt:Profiles token="profile_2"><tt:Name>Sub</tt:Name><tt:VideoEncoderConfiguration><tt:Encoding>H264</tt:Encoding>` +
+			`<tt:Resolution><tt:Width>640</tt:Width><tt:Height>360</tt:Height></tt:Resolution>` +
+			`<tt:RateControl><tt:FrameRateLimit>15</tt:FrameRateLimit></tt:RateControl></tt:VideoEncoderConfiguration></trt:Profiles>` +
+			`</trt:GetProfilesResponse>`, true
+	case "GetStreamUri":
+		return `<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri>` + device.RtspUrl(profileToken(body)) + `</tt:Uri></trt:MediaUri></trt:GetStreamUriResponse>`, true
+	case "GetSnapshotUri":
+		return `<trt:GetSnapshotUriResponse><trt:MediaUri><tt:Uri>` + device.Server.URL + `/snapshot/` + profileToken(body) + `</tt:Uri></trt:MediaUri></trt:GetSnapshotUriResponse>`, true
+	case "GetNodes":
+		return `<tptz:GetNodesResponse><tptz:PTZNode token="node_1"><tt:SupportedPTZSpaces>` +
+			`<tt:ContinuousPanTiltVelocitySpace/><tt:ContinuousZoomVelocitySpace/><tt:RelativePanTiltTranslationSpace/>` +
+			`</tt:SupportedPTZSpaces><tt:MaximumNumberOfPresets>8</tt:MaximumNumberOfPresets></tptz:PTZNode></tptz:GetNodesResponse>`, true
+	case "GetPresets":
+		return `<tptz:GetPresetsResponse><tptz:Preset token="preset_1"><tt:Name>Door</tt:Name></tptz:Preset>` +
+			`<tptz:Preset token="preset_2"><tt:Name>Yard</tt:Name></tptz:Preset></tptz:GetPresetsResponse>`, true
+	case "ContinuousMove", "RelativeMove", "AbsoluteMove", "Stop", "GotoPreset":
+		return `<tptz:` + action + `Response/>`, true
+	default:
+		return "", false
+	}
+}
+
+func profileToken(body string) string {
+	start := strings.Index(body, "ProfileToken>")
+	if start == -1 {
+		return ""
+	}
+	rest := body[start+len("ProfileToken>"):]
+	if end := strings.Index(rest, "<"); end != -1 {
+		return rest[:end]
+	}
+	return ""
+}
+
+// ResponderST answers WS-Discovery probes sent to Addr on loopback with a
+// match for each device.
+type ResponderST struct {
+	conn    *net.UDPConn
+	devices []*DeviceST
+	done    chan struct{}
+}
+
+func NewResponder(devices ...*DeviceST) (*ResponderST, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	responder := &ResponderST{
+		conn:    conn,
+		devices: devices,
+		done:    make(chan struct{}),
+	}
+	go responder.serve()
+	return responder, nil
+}
+
+func (responder *ResponderST) Addr() string {
+	return responder.conn.LocalAddr().String()
+}
+
+func (responder *ResponderST) Close() {
+	responder.conn.Close()
+	<-responder.done
+}
+
+func (responder *ResponderST) serve() {
+	defer close(responder.done)
+	buf := make([]byte, 65536)
+	for {
+		n, remote, err := responder.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !strings.Contains(string(buf[:n]), "Probe") {
+			continue
+		}
+		var matches strings.Builder
+		for i, device := range responder.devices {
+			fmt.Fprintf(&matches, `<d:ProbeMatch><a:EndpointReference><a:Address>urn:uuid:00000000-0000-0000-0000-%012d</a:Address></a:EndpointReference>`+
+				`<d:Types>dn:NetworkVideoTransmitter</d:Types>`+
+				`<d:Scopes>onvif://www.onvif.org/name/Fake%%20Camera%%20%d onvif://www.onvif.org/hardware/FC1</d:Scopes>`+
+				`<d:XAddrs>%s</d:XAddrs><d:MetadataVersion>1</d:MetadataVersion></d:ProbeMatch>`, i, i, device.XAddr())
+		}
+		response := `<?xml version="1.0" encoding="UTF-8"?>` +
+			`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+			`<s:Body><d:ProbeMatches>` + matches.String() + `</d:ProbeMatches></s:Body></s:Envelope>`
+		responder.conn.WriteToUDP([]byte(response), remote)
+	}
+}
@@ -0,0 +1,143 @@
+package onvif
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const ptzNamespace = `xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema"`
+
+type PTZNodeST struct {
+	Token              string `xml:"token,attr"`
+	SupportedPTZSpaces struct {
+		AbsolutePanTiltPositionSpace    []struct{} `xml:"AbsolutePanTiltPositionSpace"`
+		AbsoluteZoomPositionSpace       []struct{} `xml:"AbsoluteZoomPositionSpace"`
+		RelativePanTiltTranslationSpace []struct{} `xml:"RelativePanTiltTranslationSpace"`
+		RelativeZoomTranslationSpace    []struct{} `xml:"RelativeZoomTranslationSpace"`
+		ContinuousPanTiltVelocitySpace  []struct{} `xml:"ContinuousPanTiltVelocitySpace"`
+		ContinuousZoomVelocitySpace     []struct{} `xml:"ContinuousZoomVelocitySpace"`
+	} `xml:"SupportedPTZSpaces"`
+	MaximumNumberOfPresets int `xml:"MaximumNumberOfPresets"`
+}
+
+type PTZPresetST struct {
+	Token string `xml:"token,attr"`
+	Name  string `xml:"Name"`
+}
+
+// VectorST is a pan/tilt/zoom value, nil axes are left out of the request.
+type VectorST struct {
+	Pan  *float64
+	Tilt *float64
+	Zoom *float64
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (v *VectorST) xml(element string) string {
+	if v == nil {
+		return ""
+	}
+	inner := ""
+	if v.Pan != nil || v.Tilt != nil {
+		var pan, tilt float64
+		if v.Pan != nil {
+			pan = *v.Pan
+		}
+		if v.Tilt != nil {
+			tilt = *v.Tilt
+		}
+		inner += `<tt:PanTilt x="` + formatFloat(pan) + `" y="` + formatFloat(tilt) + `"/>`
+	}
+	if v.Zoom != nil {
+		inner += `<tt:Zoom x="` + formatFloat(*v.Zoom) + `"/>`
+	}
+	if inner == "" {
+		return ""
+	}
+	return `<tptz:` + element + `>` + inner + `</tptz:` + element + `>`
+}
+
+func speedVector(speed *float64, v *VectorST) *VectorST {
+	if speed == nil || v == nil {
+		return nil
+	}
+	s := &VectorST{}
+	if v.Pan != nil || v.Tilt != nil {
+		s.Pan, s.Tilt = speed, speed
+	}
+	if v.Zoom != nil {
+		s.Zoom = speed
+	}
+	return s
+}
+
+func profileToken(token string) string {
+	return `<tptz:ProfileToken>` + escape(token) + `</tptz:ProfileToken>`
+}
+
+func duration(d time.Duration) string {
+	return fmt.Sprintf("PT%gS", d.Seconds())
+}
+
+func (c *Client) GetNodes(ptzXAddr string) ([]PTZNodeST, error) {
+	var response struct {
+		Nodes []PTZNodeST `xml:"GetNodesResponse>PTZNode"`
+	}
+	if err := c.Call(ptzXAddr, `<tptz:GetNodes `+ptzNamespace+`/>`, &response); err != nil {
+		return nil, err
+	}
+	return response.Nodes, nil
+}
+
+func (c *Client) ContinuousMove(ptzXAddr, token string, velocity *VectorST, timeout time.Duration) error {
+	body := `<tptz:ContinuousMove ` + ptzNamespace + `>` + profileToken(token) + velocity.xml("Velocity")
+	if timeout > 0 {
+		body += `<tptz:Timeout>` + duration(timeout) + `</tptz:Timeout>`
+	}
+	body += `</tptz:ContinuousMove>`
+	return c.Call(ptzXAddr, body, nil)
+}
+
+func (c *Client) RelativeMove(ptzXAddr, token string, translation *VectorST, speed *float64) error {
+	body := `<tptz:RelativeMove ` + ptzNamespace + `>` + profileToken(token) +
+		translation.xml("Translation") + speedVector(speed, translation).xml("Speed") +
+		`</tptz:RelativeMove>`
+	return c.Call(ptzXAddr, body, nil)
+}
+
+func (c *Client) AbsoluteMove(ptzXAddr, token string, position *VectorST, speed *float64) error {
+	body := `<tptz:AbsoluteMove ` + ptzNamespace + `>` + profileToken(token) +
+		position.xml("Position") + speedVector(speed, position).xml("Speed") +
+		`</tptz:AbsoluteMove>`
+	return c.Call(ptzXAddr, body, nil)
+}
+
+func (c *Client) Stop(ptzXAddr, token string) error {
+	body := `<tptz:Stop ` + ptzNamespace + `>` + profileToken(token) +
+		`<tptz:PanTilt>true</tptz:PanTilt><tptz:Zoom>true</tptz:Zoom></tptz:Stop>`
+	return c.Call(ptzXAddr, body, nil)
+}
+
+func (c *Client) GetPresets(ptzXAddr, token string) ([]PTZPresetST, error) {
+	var response struct {
+		Presets []PTZPresetST `xml:"GetPresetsResponse>Preset"`
+	}
+	if err := c.Call(ptzXAddr, `<tptz:GetPresets `+ptzNamespace+`>`+profileToken(token)+`</tptz:GetPresets>`, &response); err != nil {
+		return nil, err
+	}
+	return response.Presets, nil
+}
+
+func (c *Client) GotoPreset(ptzXAddr, token, presetToken string, speed *float64) error {
+	body := `<tptz:GotoPreset ` + ptzNamespace + `>` + profileToken(token) +
+		`<tptz:PresetToken>` + escape(presetToken) + `</tptz:PresetToken>`
+	if speed != nil {
+		body += `<tptz:Speed><tt:PanTilt x="` + formatFloat(*speed) + `" y="` + formatFloat(*speed) + `"/><tt:Zoom x="` + formatFloat(*speed) + `"/></tptz:Speed>`
+	}
+	body += `</tptz:GotoPreset>`
+	return c.Call(ptzXAddr, body, nil)
+}
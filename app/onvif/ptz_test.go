@@ -0,0 +1,129 @@
+package onvif
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/onvif/onviftest"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func lastCall(t *testing.T, device *onviftest.DeviceST, action string) onviftest.CallST {
+	t.Helper()
+	calls := device.Calls()
+	if len(calls) == 0 || calls[len(calls)-1].Action != action {
+		t.Fatalf("expected a %s call, got %+v", action, calls)
+	}
+	call := calls[len(calls)-1]
+	if call.Path != "/onvif/ptz" {
+		t.Fatalf("%s was sent to %s", action, call.Path)
+	}
+	if !strings.Contains(call.Body, "<wsse:Username>admin</wsse:Username>") {
+		t.Fatalf("%s was not signed", action)
+	}
+	return call
+}
+
+func assertContains(t *testing.T, body string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(body, part) {
+			t.Errorf("expected %q in %s", part, body)
+		}
+	}
+}
+
+func TestPTZ(t *testing.T) {
+	device := onviftest.NewDevice()
+	defer device.Close()
+	client := NewClient(device.XAddr(), "admin", "secret", 2*time.Second)
+	if err := client.SyncTime(); err != nil {
+		t.Fatal(err)
+	}
+	ptzXAddr := device.PTZXAddr()
+
+	tests := []struct {
+		action string
+		call   func() error
+		parts  []string
+	}{
+		{
+			action: "ContinuousMove",
+			call: func() error {
+				return client.ContinuousMove(ptzXAddr, "profile_1", &VectorST{Pan: float(0.5), Tilt: float(-0.25)}, 500*time.Millisecond)
+			},
+			parts: []string{
+				`<tptz:ProfileToken>profile_1</tptz:ProfileToken>`,
+				`<tptz:Velocity><tt:PanTilt x="0.5" y="-0.25"/></tptz:Velocity>`,
+				`<tptz:Timeout>PT0.5S</tptz:Timeout>`,
+			},
+		},
+		{
+			action: "ContinuousMove",
+			call: func() error {
+				return client.ContinuousMove(ptzXAddr, "profile_1", &VectorST{Zoom: float(1)}, 0)
+			},
+			parts: []string{`<tptz:Velocity><tt:Zoom x="1"/></tptz:Velocity></tptz:ContinuousMove>`},
+		},
+		{
+			action: "Stop",
+			call: func() error {
+				return client.Stop(ptzXAddr, "profile_1")
+			},
+			parts: []string{
+				`<tptz:ProfileToken>profile_1</tptz:ProfileToken>`,
+				`<tptz:PanTilt>true</tptz:PanTilt><tptz:Zoom>true</tptz:Zoom>`,
+			},
+		},
+		{
+			action: "GotoPreset",
+			call: func() error {
+				return client.GotoPreset(ptzXAddr, "profile_1", "preset_2", float(0.75))
+			},
+			parts: []string{
+				`<tptz:PresetToken>preset_2</tptz:PresetToken>`,
+				`<tptz:Speed><tt:PanTilt x="0.75" y="0.75"/><tt:Zoom x="0.75"/></tptz:Speed>`,
+			},
+		},
+		{
+			action: "RelativeMove",
+			call: func() error {
+				return client.RelativeMove(ptzXAddr, "profile_1", &VectorST{Pan: float(0.1)}, float(0.5))
+			},
+			parts: []string{
+				`<tptz:Translation><tt:PanTilt x="0.1" y="0"/></tptz:Translation>`,
+				`<tptz:Speed><tt:PanTilt x="0.5" y="0.5"/></tptz:Speed>`,
+			},
+		},
+	}
+	for _, test := range tests {
+		if err := test.call(); err != nil {
+			t.Fatalf("%s: %s", test.action, err)
+		}
+		assertContains(t, lastCall(t, device, test.action).Body, test.parts...)
+	}
+
+	presets, err := client.GetPresets(ptzXAddr, "profile_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, lastCall(t, device, "GetPresets").Body, `<tptz:ProfileToken>profile_1</tptz:ProfileToken>`)
+	if len(presets) != 2 || presets[0].Token != "preset_1" || presets[1].Name != "Yard" {
+		t.Fatalf("unexpected presets %+v", presets)
+	}
+}
+
+func TestPTZFault(t *testing.T) {
+	device := onviftest.NewDevice()
+	defer device.Close()
+	client := NewClient(device.XAddr(), "", "", 2*time.Second)
+	err := client.Call(device.PTZXAddr(), `<tptz:SetHomePosition `+ptzNamespace+`/>`, nil)
+	if !errors.Is(err, ErrorSOAPFault) {
+		t.Fatalf("expected a soap fault, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+)
+
+var (
+	ErrorProcessorNotFound = errors.New("pipeline processor not found")
+	ErrorProcessorNoName   = errors.New("pipeline processor needs a name")
+)
+
+// ProcessorST is a stage of the packets of a stream between its source and
+// its viewers. Process returns the packets to pass on, none drops packet and
+// more than one forks it, the codecs of the stream must stay the same.
+type ProcessorST interface {
+	// SetCodecs is called before the first packet and whenever the codecs
+	// of the stream change.
+	SetCodecs(codecs []av.CodecData)
+	Process(packet *av.Packet) []*av.Packet
+}
+
+// ProcessorFactory creates a processor for one source session of camera,
+// it returns an error when options are invalid.
+type ProcessorFactory func(camera *models.CameraST, options map[string]string) (ProcessorST, error)
+
+var processorsMutex sync.RWMutex
+var processors = make(map[string]ProcessorFactory)
+
+// RegisterProcessor makes a processor available to the pipeline of cameras
+// under name, call it from init.
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorsMutex.Lock()
+	defer processorsMutex.Unlock()
+	processors[name] = factory
+}
+
+func getProcessorFactory(name string) (ProcessorFactory, bool) {
+	processorsMutex.RLock()
+	defer processorsMutex.RUnlock()
+	factory, ok := processors[name]
+	return factory, ok
+}
+
+// PipelineST runs packets through the processors of a camera in order. A
+// pipeline belongs to one source session and is only used by its worker.
+type PipelineST struct {
+	processors []ProcessorST
+	packets    []*av.Packet
+	next       []*av.Packet
+}
+
+func New(camera *models.CameraST) (*PipelineST, error) {
+	pipeline := &PipelineST{}
+	for _, stage := range camera.Pipeline {
+		if stage.Name == "" {
+			return nil, ErrorProcessorNoName
+		}
+		factory, ok := getProcessorFactory(stage.Name)
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrorProcessorNotFound, stage.Name)
+		}
+		processor, err := factory(camera, stage.Options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", stage.Name, err)
+		}
+		pipeline.processors = append(pipeline.processors, processor)
+	}
+	return pipeline, nil
+}
+
+// Validate checks that every stage of the pipeline of camera can be
+// created.
+func Validate(camera *models.CameraST) error {
+	_, err := New(camera)
+	return err
+}
+
+func (pipeline *PipelineST) SetCodecs(codecs []av.CodecData) {
+	for _, processor := range pipeline.processors {
+		processor.SetCodecs(codecs)
+	}
+}
+
+// Process returns the packets to cast for packet, the slice is reused by
+// the next call.
+func (pipeline *PipelineST) Process(packet *av.Packet) []*av.Packet {
+	packets := append(pipeline.packets[:0], packet)
+	next := pipeline.next[:0]
+	for _, processor := range pipeline.processors {
+		for _, packet := range packets {
+			next = append(next, processor.Process(packet)...)
+		}
+		packets, next = next, packets[:0]
+		if len(packets) == 0 {
+			break
+		}
+	}
+	pipeline.packets, pipeline.next = packets, next
+	return packets
+}
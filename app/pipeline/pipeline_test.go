@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/util"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0x00, 0x29, 0xe2, 0x90, 0x14, 0x07, 0xb6, 0x02, 0xdc, 0x04, 0x04, 0x06, 0x90, 0x78, 0x91, 0x15}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+	testSEI = []byte{0x06, 0x05, 0x01, 0x00, 0x80}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00}
+	testP   = []byte{0x41, 0x9a, 0x02, 0x00}
+)
+
+func testCodecs(t *testing.T) []av.CodecData {
+	t.Helper()
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []av.CodecData{video, codec.NewPCMMulawCodecData()}
+}
+
+func avcc(nalus ...[]byte) []byte {
+	return util.JoinNALUs(nalus, h264parser.NALU_AVCC)
+}
+
+// copiesST passes every packet on count times, zero drops it, seen has the
+// packets that reached it.
+type copiesST struct {
+	codecsST
+	count int
+	seen  *[]*av.Packet
+}
+
+func (p *copiesST) Process(packet *av.Packet) []*av.Packet {
+	*p.seen = append(*p.seen, packet)
+	var packets []*av.Packet
+	for i := 0; i < p.count; i++ {
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestPipelineProcess(t *testing.T) {
+	var seen []*av.Packet
+	for name, count := range map[string]int{"test_drop": 0, "test_pass": 1, "test_fork": 2} {
+		count := count
+		RegisterProcessor(name, func(camera *models.CameraST, options map[string]string) (ProcessorST, error) {
+			return &copiesST{count: count, seen: &seen}, nil
+		})
+	}
+
+	tests := []struct {
+		name   string
+		stages []string
+		out    int
+		seen   int
+	}{
+		{"no stages", nil, 1, 0},
+		{"drop stops later stages", []string{"test_drop", "test_pass"}, 0, 1},
+		{"fork feeds every packet", []string{"test_fork", "test_pass"}, 2, 3},
+		{"forks multiply", []string{"test_fork", "test_fork", "test_pass"}, 4, 1 + 2 + 4},
+		{"drop after fork", []string{"test_fork", "test_drop", "test_pass"}, 0, 1 + 2},
+	}
+	for _, test := range tests {
+		camera := models.CameraST{}
+		for _, stage := range test.stages {
+			camera.Pipeline = append(camera.Pipeline, models.CameraProcessorST{Name: stage})
+		}
+		pipeline, err := New(&camera)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		pipeline.SetCodecs(testCodecs(t))
+		// the buffers are reused, the second packet must come out the same
+		for i := 0; i < 2; i++ {
+			seen = nil
+			packet := &av.Packet{Idx: 1, Data: []byte{byte(i)}}
+			packets := pipeline.Process(packet)
+			if len(packets) != test.out || len(seen) != test.seen {
+				t.Errorf("%s: expected %d out and %d seen, got %d and %d", test.name, test.out, test.seen, len(packets), len(seen))
+			}
+			for _, out := range packets {
+				if out != packet {
+					t.Errorf("%s: expected packet %d, got %v", test.name, i, out.Data)
+				}
+			}
+		}
+	}
+}
+
+func TestPipelineNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []models.CameraProcessorST
+		err    error
+	}{
+		{"empty", nil, nil},
+		{"builtin", []models.CameraProcessorST{{Name: ProcessorStripSEI}, {Name: ProcessorDropAudio}}, nil},
+		{"no name", []models.CameraProcessorST{{Name: ProcessorStripSEI}, {}}, ErrorProcessorNoName},
+		{"not found", []models.CameraProcessorST{{Name: "missing"}}, ErrorProcessorNotFound},
+	}
+	for _, test := range tests {
+		camera := models.CameraST{Pipeline: test.stages}
+		if _, err := New(&camera); test.err == nil && err != nil || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: New expected %v, got %v", test.name, test.err, err)
+		}
+		if err := Validate(&camera); test.err == nil && err != nil || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: Validate expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	codecs := testCodecs(t)
+	tests := []struct {
+		name      string
+		processor ProcessorST
+		packet    av.Packet
+		// nil when the packet is dropped
+		data []byte
+	}{
+		{"strip sei", &stripSEIST{}, av.Packet{Data: avcc(testSEI, testIDR), IsKeyFrame: true}, avcc(testIDR)},
+		{"strip sei only", &stripSEIST{}, av.Packet{Data: avcc(testSEI)}, nil},
+		{"strip sei keeps others", &stripSEIST{}, av.Packet{Data: avcc(testSPS, testPPS, testIDR), IsKeyFrame: true}, avcc(testSPS, testPPS, testIDR)},
+		{"strip sei skips audio", &stripSEIST{}, av.Packet{Idx: 1, Data: testSEI}, testSEI},
+		{"insert parameter sets", &insertParameterSetsST{}, av.Packet{Data: avcc(testIDR), IsKeyFrame: true}, avcc(testSPS, testPPS, testIDR)},
+		{"insert parameter sets has sps", &insertParameterSetsST{}, av.Packet{Data: avcc(testSPS, testPPS, testIDR), IsKeyFrame: true}, avcc(testSPS, testPPS, testIDR)},
+		{"insert parameter sets not keyframe", &insertParameterSetsST{}, av.Packet{Data: avcc(testP)}, avcc(testP)},
+		{"drop audio", &dropAudioST{}, av.Packet{Idx: 1, Data: []byte{0xff}}, nil},
+		{"drop audio keeps video", &dropAudioST{}, av.Packet{Data: avcc(testP)}, avcc(testP)},
+	}
+	for _, test := range tests {
+		test.processor.SetCodecs(codecs)
+		packet := test.packet
+		packets := test.processor.Process(&packet)
+		if test.data == nil {
+			if len(packets) != 0 {
+				t.Errorf("%s: expected the packet to be dropped, got %d", test.name, len(packets))
+			}
+			continue
+		}
+		if len(packets) != 1 {
+			t.Errorf("%s: expected one packet, got %d", test.name, len(packets))
+			continue
+		}
+		if !bytes.Equal(packets[0].Data, test.data) {
+			t.Errorf("%s: expected %x, got %x", test.name, test.data, packets[0].Data)
+		}
+		if packets[0].IsKeyFrame != packet.IsKeyFrame || packets[0].Idx != packet.Idx {
+			t.Errorf("%s: expected the packet fields to be kept", test.name)
+		}
+		if bytes.Equal(packet.Data, test.data) && packets[0] != &packet {
+			t.Errorf("%s: expected an unchanged packet to be passed on as is", test.name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/util"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+const (
+	ProcessorDropAudio           = "drop_audio"
+	ProcessorStripSEI            = "strip_sei"
+	ProcessorInsertParameterSets = "insert_parameter_sets"
+)
+
+func init() {
+	RegisterProcessor(ProcessorDropAudio, newDropAudio)
+	RegisterProcessor(ProcessorStripSEI, newStripSEI)
+	RegisterProcessor(ProcessorInsertParameterSets, newInsertParameterSets)
+}
+
+type codecsST struct {
+	codecs []av.CodecData
+}
+
+func (c *codecsST) SetCodecs(codecs []av.CodecData) {
+	c.codecs = codecs
+}
+
+func (c *codecsST) codec(packet *av.Packet) av.CodecData {
+	if int(packet.Idx) < len(c.codecs) {
+		return c.codecs[packet.Idx]
+	}
+	return nil
+}
+
+// dropAudioST drops the packets of audio streams.
+type dropAudioST struct {
+	codecsST
+}
+
+func newDropAudio(camera *models.CameraST, options map[string]string) (ProcessorST, error) {
+	return &dropAudioST{}, nil
+}
+
+func (p *dropAudioST) Process(packet *av.Packet) []*av.Packet {
+	if codec := p.codec(packet); codec != nil && codec.Type().IsAudio() {
+		return nil
+	}
+	return []*av.Packet{packet}
+}
+
+// stripSEIST removes SEI NALUs from H264 packets, packets with nothing
+// else are dropped.
+type stripSEIST struct {
+	codecsST
+}
+
+func newStripSEI(camera *models.CameraST, options map[string]string) (ProcessorST, error) {
+	return &stripSEIST{}, nil
+}
+
+func (p *stripSEIST) Process(packet *av.Packet) []*av.Packet {
+	if codec := p.codec(packet); codec == nil || codec.Type() != av.H264 {
+		return []*av.Packet{packet}
+	}
+	nalus, typ := h264parser.SplitNALUs(packet.Data)
+	kept := nalus[:0:0]
+	for _, nalu := range nalus {
+		if len(nalu) > 0 && nalu[0]&0x1f != h264parser.NALU_SEI {
+			kept = append(kept, nalu)
+		}
+	}
+	if len(kept) == len(nalus) {
+		return []*av.Packet{packet}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	stripped := *packet
+	stripped.Data = util.JoinNALUs(kept, typ)
+	return []*av.Packet{&stripped}
+}
+
+// insertParameterSetsST prefixes H264 keyframes that carry no SPS with the
+// SPS and PPS of the stream, for viewers that need them in band.
+type insertParameterSetsST struct {
+	codecsST
+}
+
+func newInsertParameterSets(camera *models.CameraST, options map[string]string) (ProcessorST, error) {
+	return &insertParameterSetsST{}, nil
+}
+
+func (p *insertParameterSetsST) Process(packet *av.Packet) []*av.Packet {
+	codec, ok := p.codec(packet).(h264parser.CodecData)
+	if !ok || !packet.IsKeyFrame {
+		return []*av.Packet{packet}
+	}
+	nalus, typ := h264parser.SplitNALUs(packet.Data)
+	for _, nalu := range nalus {
+		if len(nalu) > 0 && nalu[0]&0x1f == h264parser.NALU_SPS {
+			return []*av.Packet{packet}
+		}
+	}
+	inserted := *packet
+	inserted.Data = util.JoinNALUs(append([][]byte{codec.SPS(), codec.PPS()}, nalus...), typ)
+	return []*av.Packet{&inserted}
+}
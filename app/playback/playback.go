@@ -6,9 +6,11 @@ import (
 	"time"
 
 	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/format"
 	"github.com/aicacia/streams/app/rtsp"
 	"github.com/aicacia/streams/app/util"
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/google/uuid"
 )
 
@@ -199,6 +201,7 @@ func PlaybackDelete(playbackId string) {
 
 func playbackWorker(playbackId, cameraId string, socket chan *av.Packet, currentTime time.Time) {
 	defer playbackStop(playbackId)
+	var negotiated []av.CodecData
 	for {
 		if playbackIsClosed(playbackId) {
 			log.Printf("%s: playback closed\n", playbackId)
@@ -206,41 +209,68 @@ func playbackWorker(playbackId, cameraId string, socket chan *av.Packet, current
 		}
 		log.Printf("%s: playing %s", cameraId, currentTime)
 		folder := rtsp.GetRecordingFolderPath(cameraId, &currentTime)
-		player, err := NewPlayer(folder, &currentTime, GetPlaybackDirection(playbackId), GetPlaybackRate(playbackId))
-		if err != nil {
-			log.Printf("%s: failed to create demuxer %s", cameraId, err)
-			if GetPlaybackDirection(playbackId) == PlaybackForward {
-				currentTime = util.TruncateToMinute(currentTime.Add(time.Minute))
-			} else {
-				currentTime = util.TruncateToMinute(currentTime.Add(-time.Minute))
-			}
-			continue
+		segments, err := format.Segments(folder)
+		if err != nil || len(segments) == 0 {
+			log.Printf("%s: no recordings in %s", cameraId, folder)
 		}
-		setPlaybackCodecs(playbackId, player.Codecs())
-		player.Start()
-		var packetTime time.Time
-		stream := player.Stream()
-		for {
-			if packet, ok := <-stream; ok {
-				packetTime = rtsp.GetPacketTime(packet)
-				socket <- packet
+		if GetPlaybackDirection(playbackId) == PlaybackBackward {
+			for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+				segments[i], segments[j] = segments[j], segments[i]
 			}
+		}
+		for _, segment := range segments {
 			if playbackIsClosed(playbackId) {
-				player.Close()
 				break
 			}
-			if player.IsClosed() {
-				break
+			player, err := NewPlayer(folder, segment, &currentTime, GetPlaybackDirection(playbackId), GetPlaybackRate(playbackId))
+			if err != nil {
+				log.Printf("%s: failed to create demuxer %s", cameraId, err)
+				continue
+			}
+			codecs := player.Codecs()
+			if negotiated == nil {
+				negotiated = codecs
+			}
+			setPlaybackCodecs(playbackId, codecs)
+			player.Start()
+			stream := player.Stream()
+			for {
+				if packet, ok := <-stream; ok {
+					socket <- withNegotiatedCodecs(packet, negotiated, codecs)
+				}
+				if playbackIsClosed(playbackId) {
+					player.Close()
+					break
+				}
+				if player.IsClosed() {
+					break
+				}
 			}
 		}
 		log.Printf("%s: done with %s", cameraId, currentTime)
-		currentTime = packetTime
-		if currentTime.Equal(packetTime) {
-			if GetPlaybackDirection(playbackId) == PlaybackForward {
-				currentTime = util.TruncateToMinute(currentTime.Add(time.Minute))
-			} else {
-				currentTime = util.TruncateToMinute(currentTime.Add(-time.Minute))
-			}
+		if GetPlaybackDirection(playbackId) == PlaybackForward {
+			currentTime = util.TruncateToMinute(currentTime.Add(time.Minute))
+		} else {
+			currentTime = util.TruncateToMinute(currentTime.Add(-time.Minute))
 		}
 	}
 }
+
+// withNegotiatedCodecs prefixes the keyframes of a segment recorded with
+// other H264 parameter sets than the viewer negotiated with its own SPS and
+// PPS, so the viewer follows codec changes within the playback.
+func withNegotiatedCodecs(packet *av.Packet, negotiated, codecs []av.CodecData) *av.Packet {
+	idx := int(packet.Idx)
+	if !packet.IsKeyFrame || idx >= len(codecs) || idx >= len(negotiated) || util.CodecsEqual(negotiated[idx:idx+1], codecs[idx:idx+1]) {
+		return packet
+	}
+	codec, ok := codecs[idx].(h264parser.CodecData)
+	if !ok {
+		return packet
+	}
+	nalus, typ := h264parser.SplitNALUs(packet.Data)
+	data := util.JoinNALUs(append([][]byte{codec.SPS(), codec.PPS()}, nalus...), typ)
+	withCodecs := *packet
+	withCodecs.Data = data
+	return &withCodecs
+}
@@ -0,0 +1,90 @@
+package playback
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/format"
+	"github.com/aicacia/streams/app/rtsp"
+	"github.com/aicacia/streams/app/util"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0x00, 0x29, 0xe2, 0x90, 0x14, 0x07, 0xb6, 0x02, 0xdc, 0x04, 0x04, 0x06, 0x90, 0x78, 0x91, 0x15}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00}
+)
+
+func testH264Codec(t *testing.T, level byte) h264parser.CodecData {
+	t.Helper()
+	sps := append([]byte(nil), testSPS...)
+	sps[3] = level
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+// TestPlaybackSegments plays a minute recorded in two segments with other
+// parameter sets, the keyframes of the second carry its SPS and PPS.
+func TestPlaybackSegments(t *testing.T) {
+	config.Config.RTSP.Connect.Timeout.Seconds = 5
+	config.Config.Recordings.Folder = t.TempDir()
+	const cameraId = "playback-segments"
+	const packets = 3
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	first, second := testH264Codec(t, 0x29), testH264Codec(t, 0x1f)
+
+	frame := util.JoinNALUs([][]byte{testIDR}, h264parser.NALU_AVCC)
+	for segment, codec := range []av.CodecData{first, second} {
+		muxer, err := format.NewMuxer(rtsp.GetRecordingFolderPath(cameraId, &start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := muxer.WriteHeader([]av.CodecData{codec}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < packets; i++ {
+			if err := muxer.WritePacket(&av.Packet{
+				IsKeyFrame: true,
+				Time:       time.Duration(start.UnixNano()) + time.Duration(segment*packets+i)*10*time.Millisecond,
+				Duration:   10 * time.Millisecond,
+				Data:       frame,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		muxer.Close()
+	}
+
+	playbackId, err := NewPlayback(cameraId, &start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer PlaybackDelete(playbackId.String())
+	socket := GetPlaybackSocket(playbackId.String())
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"first segment", frame},
+		{"second segment", util.JoinNALUs([][]byte{second.SPS(), second.PPS(), testIDR}, h264parser.NALU_AVCC)},
+	}
+	for _, test := range tests {
+		for i := 0; i < packets; i++ {
+			select {
+			case packet := <-socket:
+				if !bytes.Equal(packet.Data, test.data) {
+					t.Errorf("%s: expected %x, got %x", test.name, test.data, packet.Data)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: packet %d was not played", test.name, i)
+			}
+		}
+	}
+}
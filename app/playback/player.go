@@ -2,8 +2,6 @@ package playback
 
 import (
 	"log"
-	"os"
-	"strconv"
 	"sync"
 	"time"
 
@@ -26,26 +24,11 @@ type Player struct {
 	stream      chan *av.Packet
 }
 
-func NewPlayer(folder string, currentTime *time.Time, direction int8, rate float32) (*Player, error) {
-	entries, err := os.ReadDir(folder)
+func NewPlayer(folder string, segment format.Segment, currentTime *time.Time, direction int8, rate float32) (*Player, error) {
+	demuxers, err := format.OpenSegment(folder, segment)
 	if err != nil {
 		return nil, err
 	}
-	var demuxers []*format.Demuxer
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			idxStr, err := strconv.ParseInt(entry.Name(), 10, 8)
-			if err != nil {
-				return nil, err
-			}
-			idx := int8(idxStr)
-			demuxer, err := format.NewDemuxer(folder, idx)
-			if err != nil {
-				return nil, err
-			}
-			demuxers = append(demuxers, demuxer)
-		}
-	}
 	return &Player{
 		folder:      folder,
 		currentTime: currentTime,
@@ -0,0 +1,356 @@
+package rtsp
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aicacia/streams/app/transcode"
+	"github.com/aicacia/streams/app/util"
+	"github.com/deepch/vdk/av"
+)
+
+// broadcasterST fans the packets of one stream out to its viewers. The
+// viewer list is copy on write so cast never locks more than the stream's
+// own mutex, which it shares only with viewers joining and leaving.
+type broadcasterST struct {
+	mutex     sync.Mutex
+	viewers   atomic.Pointer[[]*ViewerST]
+	gop       []*av.Packet
+	codecs    []av.CodecData
+	audioOnly bool
+	video     []bool
+	closed    bool
+	rate      rateST
+	// dropped and droppedGOPs of the viewers that left
+	dropped     uint64
+	droppedGOPs uint64
+	// audio transcodes the audio once for every viewer with transcodeAudio,
+	// it runs while there are such viewers
+	audio        *transcode.AudioStageST
+	audioViewers int
+}
+
+// rateST measures the traffic of a stream over one second windows.
+type rateST struct {
+	windowStart      time.Time
+	packets          int
+	bytes            int
+	frames           int
+	packetsPerSecond float64
+	bitsPerSecond    float64
+	framesPerSecond  float64
+}
+
+func (rate *rateST) add(packet *av.Packet, video bool, now time.Time) {
+	if rate.windowStart.IsZero() {
+		rate.windowStart = now
+	}
+	rate.packets++
+	rate.bytes += len(packet.Data)
+	if video {
+		rate.frames++
+	}
+	if elapsed := now.Sub(rate.windowStart).Seconds(); elapsed >= 1 {
+		rate.packetsPerSecond = float64(rate.packets) / elapsed
+		rate.bitsPerSecond = float64(rate.bytes*8) / elapsed
+		rate.framesPerSecond = float64(rate.frames) / elapsed
+		rate.windowStart = now
+		rate.packets, rate.bytes, rate.frames = 0, 0, 0
+	}
+}
+
+func newBroadcaster() *broadcasterST {
+	broadcaster := &broadcasterST{}
+	broadcaster.viewers.Store(&[]*ViewerST{})
+	return broadcaster
+}
+
+func (broadcaster *broadcasterST) count() int {
+	return len(*broadcaster.viewers.Load())
+}
+
+// drops returns the packets and GOPs dropped by every viewer of the stream,
+// the ones that left included.
+func (broadcaster *broadcasterST) drops() (uint64, uint64) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	dropped, droppedGOPs := broadcaster.dropped, broadcaster.droppedGOPs
+	for _, viewer := range *broadcaster.viewers.Load() {
+		dropped += viewer.Dropped()
+		droppedGOPs += viewer.DroppedGOPs()
+	}
+	return dropped, droppedGOPs
+}
+
+// removedLocked keeps the drops of a viewer that left and stops the audio
+// transcoder with its last viewer.
+func (broadcaster *broadcasterST) removedLocked(viewer *ViewerST) {
+	broadcaster.dropped += viewer.Dropped()
+	broadcaster.droppedGOPs += viewer.DroppedGOPs()
+	if viewer.transcodeAudio {
+		broadcaster.audioViewers--
+		if broadcaster.audioViewers == 0 {
+			broadcaster.stopAudioLocked()
+		}
+	}
+}
+
+func (broadcaster *broadcasterST) startAudioLocked() {
+	if broadcaster.audio != nil || broadcaster.audioViewers == 0 || broadcaster.codecs == nil {
+		return
+	}
+	audio, err := transcode.NewAudioStage(broadcaster.codecs)
+	if err != nil {
+		if err != transcode.ErrorTranscoderNotFound {
+			log.Println("audio transcoder", err)
+		}
+		return
+	}
+	broadcaster.audio = audio
+}
+
+func (broadcaster *broadcasterST) stopAudioLocked() {
+	if broadcaster.audio != nil {
+		broadcaster.audio.Close()
+		broadcaster.audio = nil
+	}
+}
+
+// viewerCodecsLocked is the codecs of the packets viewer is sent.
+func (broadcaster *broadcasterST) viewerCodecsLocked(viewer *ViewerST) []av.CodecData {
+	if !viewer.transcodeAudio || broadcaster.audio == nil || broadcaster.codecs == nil {
+		return broadcaster.codecs
+	}
+	idx := broadcaster.audio.Idx()
+	codecs := append([]av.CodecData(nil), broadcaster.codecs...)
+	codecs[idx] = broadcaster.audio.Codecs()[idx]
+	return codecs
+}
+
+// audioChanged is true when codecs no longer have the audio the transcoder
+// was started for.
+func audioChanged(audio *transcode.AudioStageST, codecs []av.CodecData) bool {
+	idx := int(audio.Idx())
+	return idx >= len(codecs) || !util.CodecsEqual(audio.Input()[idx:idx+1], codecs[idx:idx+1])
+}
+
+// viewerCodecs returns the codecs of the packets viewer is sent.
+func (broadcaster *broadcasterST) viewerCodecs(viewer *ViewerST) []av.CodecData {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	return viewer.codecs
+}
+
+// reset drops the cached GOP when the codecs of the stream change. Viewers
+// that asked for it are closed when the new codecs differ from the ones
+// they started with, so they can start over with the new ones.
+func (broadcaster *broadcasterST) reset(codecs []av.CodecData) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	broadcaster.gop = nil
+	broadcaster.codecs = codecs
+	broadcaster.audioOnly = codecs != nil && util.IsAudioOnly(codecs)
+	broadcaster.video = make([]bool, len(codecs))
+	for idx, codec := range codecs {
+		broadcaster.video[idx] = codec.Type().IsVideo()
+	}
+	broadcaster.rate = rateST{}
+	if codecs == nil {
+		return
+	}
+	// viewers of transcoded audio start over when the audio changes
+	restartAudio := broadcaster.audio != nil && audioChanged(broadcaster.audio, codecs)
+	if restartAudio {
+		broadcaster.stopAudioLocked()
+	}
+	broadcaster.startAudioLocked()
+	prev := *broadcaster.viewers.Load()
+	next := make([]*ViewerST, 0, len(prev))
+	for _, viewer := range prev {
+		if viewer.transcodeAudio && restartAudio {
+			close(viewer.Socket)
+			broadcaster.removedLocked(viewer)
+			continue
+		}
+		if viewer.codecs == nil {
+			viewer.codecs = broadcaster.viewerCodecsLocked(viewer)
+		} else if viewer.closeOnCodecs && !util.CodecsEqual(viewer.codecs, codecs) {
+			viewer.codecsChanged = true
+			close(viewer.Socket)
+			broadcaster.removedLocked(viewer)
+			continue
+		}
+		next = append(next, viewer)
+	}
+	if len(next) != len(prev) {
+		broadcaster.viewers.Store(&next)
+	}
+}
+
+// audioPackets is the transcoded audio of the stream, nil while no viewer
+// needs it.
+func (broadcaster *broadcasterST) audioPackets() (*transcode.AudioStageST, <-chan *av.Packet) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	if broadcaster.audio == nil {
+		return nil, nil
+	}
+	return broadcaster.audio, broadcaster.audio.Packets()
+}
+
+// castAudio sends the transcoded audio of audio to the viewers that need
+// it, the viewers are closed when the transcoder stopped on its own. It is
+// only called from the worker of the stream.
+func (broadcaster *broadcasterST) castAudio(audio *transcode.AudioStageST, packet *av.Packet, ok bool) {
+	broadcaster.mutex.Lock()
+	if broadcaster.audio != audio {
+		broadcaster.mutex.Unlock()
+		return
+	}
+	if !ok {
+		defer broadcaster.mutex.Unlock()
+		prev := *broadcaster.viewers.Load()
+		next := make([]*ViewerST, 0, len(prev))
+		for _, viewer := range prev {
+			if viewer.transcodeAudio {
+				close(viewer.Socket)
+				broadcaster.removedLocked(viewer)
+				continue
+			}
+			next = append(next, viewer)
+		}
+		broadcaster.viewers.Store(&next)
+		return
+	}
+	audioOnly := broadcaster.audioOnly
+	viewers := *broadcaster.viewers.Load()
+	broadcaster.mutex.Unlock()
+
+	for _, viewer := range viewers {
+		if viewer.transcodeAudio {
+			viewer.cast(packet, audioOnly)
+		}
+	}
+}
+
+// rates returns packets, bits and video frames per second, a stream that
+// has not sent a packet for two windows has no rate.
+func (broadcaster *broadcasterST) rates() (float64, float64, float64) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	rate := broadcaster.rate
+	if rate.windowStart.IsZero() || time.Since(rate.windowStart) > 2*time.Second {
+		return 0, 0, 0
+	}
+	return rate.packetsPerSecond, rate.bitsPerSecond, rate.framesPerSecond
+}
+
+// subscribe replays the cached GOP to viewer before it is added, so it
+// gets the GOP and then every following packet exactly once. The socket
+// of a viewer joining a closed broadcaster is closed right away.
+func (broadcaster *broadcasterST) subscribe(viewer *ViewerST) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	if broadcaster.closed {
+		close(viewer.Socket)
+		return
+	}
+	if viewer.transcodeAudio {
+		broadcaster.audioViewers++
+		broadcaster.startAudioLocked()
+	}
+	viewer.codecs = broadcaster.viewerCodecsLocked(viewer)
+	audioIdx := broadcaster.audioIdxLocked()
+	for _, packet := range broadcaster.gop {
+		if !(viewer.transcodeAudio && packet.Idx == audioIdx) {
+			viewer.cast(packet, broadcaster.audioOnly)
+		}
+	}
+	prev := *broadcaster.viewers.Load()
+	next := make([]*ViewerST, len(prev), len(prev)+1)
+	copy(next, prev)
+	next = append(next, viewer)
+	broadcaster.viewers.Store(&next)
+}
+
+func (broadcaster *broadcasterST) unsubscribe(uuid string) *ViewerST {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	prev := *broadcaster.viewers.Load()
+	for i, viewer := range prev {
+		if viewer.Uuid.String() == uuid {
+			next := make([]*ViewerST, 0, len(prev)-1)
+			next = append(next, prev[:i]...)
+			next = append(next, prev[i+1:]...)
+			broadcaster.viewers.Store(&next)
+			broadcaster.removedLocked(viewer)
+			return viewer
+		}
+	}
+	return nil
+}
+
+// disconnect closes the sockets of every viewer so they stop reading,
+// the worker of the stream must have stopped.
+func (broadcaster *broadcasterST) disconnect() {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	broadcaster.disconnectLocked()
+}
+
+// close disconnects the viewers of a stream that went away for good.
+func (broadcaster *broadcasterST) close() {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+	broadcaster.closed = true
+	broadcaster.disconnectLocked()
+}
+
+func (broadcaster *broadcasterST) disconnectLocked() {
+	for _, viewer := range *broadcaster.viewers.Load() {
+		close(viewer.Socket)
+		broadcaster.removedLocked(viewer)
+	}
+	broadcaster.viewers.Store(&[]*ViewerST{})
+	broadcaster.gop = nil
+}
+
+// cast is only called from the worker of the stream.
+func (broadcaster *broadcasterST) cast(packet *av.Packet) {
+	broadcaster.mutex.Lock()
+	video := int(packet.Idx) < len(broadcaster.video) && broadcaster.video[packet.Idx]
+	broadcaster.rate.add(packet, video, time.Now())
+	if packet.IsKeyFrame {
+		broadcaster.gop = append(broadcaster.gop[:0:0], packet)
+	} else if broadcaster.gop != nil {
+		if len(broadcaster.gop) < gopMaxPackets {
+			broadcaster.gop = append(broadcaster.gop, packet)
+		} else {
+			broadcaster.gop = nil
+		}
+	}
+	audioOnly := broadcaster.audioOnly
+	audio := broadcaster.audio
+	viewers := *broadcaster.viewers.Load()
+	broadcaster.mutex.Unlock()
+
+	// the transcoded audio reaches its viewers through castAudio
+	audioIdx := int8(-1)
+	if audio != nil && audio.WritePacket(packet) {
+		audioIdx = packet.Idx
+	}
+	for _, viewer := range viewers {
+		if !(viewer.transcodeAudio && packet.Idx == audioIdx) {
+			viewer.cast(packet, audioOnly)
+		}
+	}
+}
+
+func (broadcaster *broadcasterST) audioIdxLocked() int8 {
+	if broadcaster.audio == nil {
+		return -1
+	}
+	return broadcaster.audio.Idx()
+}
@@ -0,0 +1,197 @@
+package rtsp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/google/uuid"
+)
+
+const (
+	benchmarkCameras = 100
+	benchmarkViewers = 50
+)
+
+func newBenchmarkViewer(wg *sync.WaitGroup) *ViewerST {
+	viewer := &ViewerST{
+		Uuid:   uuid.New(),
+		Socket: make(chan *av.Packet, viewerChanSize),
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range viewer.Socket {
+		}
+	}()
+	return viewer
+}
+
+// BenchmarkBroadcasterCast casts one packet to every camera per op, each
+// camera with its viewers draining their sockets.
+func BenchmarkBroadcasterCast(b *testing.B) {
+	b.Run("static", func(b *testing.B) {
+		benchmarkBroadcasterCast(b, false)
+	})
+	b.Run("churn", func(b *testing.B) {
+		benchmarkBroadcasterCast(b, true)
+	})
+}
+
+func benchmarkBroadcasterCast(b *testing.B, churn bool) {
+	var wg sync.WaitGroup
+	broadcasters := make([]*broadcasterST, benchmarkCameras)
+	for i := range broadcasters {
+		broadcasters[i] = newBroadcaster()
+		for j := 0; j < benchmarkViewers; j++ {
+			broadcasters[i].subscribe(newBenchmarkViewer(&wg))
+		}
+	}
+
+	// churn joins and leaves a viewer on every camera as fast as it can so
+	// cast races the copy on write of the viewer list
+	stop := make(chan struct{})
+	var churnWg sync.WaitGroup
+	if churn {
+		churnWg.Add(1)
+		go func() {
+			defer churnWg.Done()
+			for {
+				for _, broadcaster := range broadcasters {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					// a cast may still hold the viewer after it left so its
+					// socket is never closed, it is small and drops when full
+					viewer := &ViewerST{
+						Uuid:   uuid.New(),
+						Socket: make(chan *av.Packet, 1),
+					}
+					broadcaster.subscribe(viewer)
+					broadcaster.unsubscribe(viewer.Uuid.String())
+				}
+			}
+		}()
+	}
+
+	// packets are shared with the cached GOP so they are never modified
+	keyframe := &av.Packet{IsKeyFrame: true, Data: make([]byte, 1400)}
+	delta := &av.Packet{Data: make([]byte, 1400)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		packet := delta
+		if i%30 == 0 {
+			packet = keyframe
+		}
+		for _, broadcaster := range broadcasters {
+			broadcaster.cast(packet)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	churnWg.Wait()
+	for _, broadcaster := range broadcasters {
+		broadcaster.close()
+	}
+	wg.Wait()
+}
+
+func TestBroadcasterDrops(t *testing.T) {
+	broadcaster := newBroadcaster()
+	viewer := &ViewerST{
+		Uuid:         uuid.New(),
+		Socket:       make(chan *av.Packet, 1),
+		waitKeyframe: true,
+	}
+	broadcaster.subscribe(viewer)
+	keyframe := &av.Packet{IsKeyFrame: true}
+	frame := &av.Packet{}
+	// the socket fills with the keyframe, the rest is dropped until a
+	// keyframe finds room again
+	for _, packet := range []*av.Packet{keyframe, frame, frame, keyframe} {
+		broadcaster.cast(packet)
+	}
+	<-viewer.Socket
+	broadcaster.cast(keyframe)
+	if dropped, droppedGOPs := broadcaster.drops(); dropped != 3 || droppedGOPs != 1 {
+		t.Fatalf("expected 3 packets in 1 GOP dropped, got %d in %d", dropped, droppedGOPs)
+	}
+	broadcaster.unsubscribe(viewer.Uuid.String())
+	broadcaster.subscribe(&ViewerST{Uuid: uuid.New(), Socket: make(chan *av.Packet, 1)})
+	if dropped, droppedGOPs := broadcaster.drops(); dropped != 3 || droppedGOPs != 1 {
+		t.Fatalf("expected the drops of the viewer that left, got %d in %d", dropped, droppedGOPs)
+	}
+}
+
+// TestBroadcasterAudioTranscoder runs one transcoder for every viewer that
+// needs it, ffmpeg is a script that counts its starts and echoes its input.
+func TestBroadcasterAudioTranscoder(t *testing.T) {
+	dir := t.TempDir()
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	starts := filepath.Join(dir, "starts")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho >> "+starts+"\nexec cat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config.Config.Transcode.Audio.Enabled = true
+	config.Config.Transcode.Audio.FFmpeg = ffmpeg
+	config.Config.Transcode.Audio.Codec = "pcma"
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broadcaster := newBroadcaster()
+	broadcaster.reset([]av.CodecData{aac})
+	plain := &ViewerST{Uuid: uuid.New(), Socket: make(chan *av.Packet, viewerChanSize)}
+	broadcaster.subscribe(plain)
+	var transcoded []*ViewerST
+	for i := 0; i < 2; i++ {
+		viewer := &ViewerST{Uuid: uuid.New(), Socket: make(chan *av.Packet, viewerChanSize), transcodeAudio: true}
+		broadcaster.subscribe(viewer)
+		if codecs := broadcaster.viewerCodecs(viewer); codecs[0].Type() != av.PCM_ALAW {
+			t.Fatalf("expected PCMA, got %v", codecs)
+		}
+		transcoded = append(transcoded, viewer)
+	}
+	for i := 0; i < 4; i++ {
+		broadcaster.cast(&av.Packet{Data: make([]byte, 100)})
+	}
+	if len(plain.Socket) != 4 {
+		t.Fatalf("expected the AAC packets, got %d", len(plain.Socket))
+	}
+	// the worker casts the transcoded audio
+	audio, packets := broadcaster.audioPackets()
+	select {
+	case packet, ok := <-packets:
+		broadcaster.castAudio(audio, packet, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no transcoded audio")
+	}
+	for _, viewer := range transcoded {
+		if len(viewer.Socket) != 1 {
+			t.Fatalf("expected one transcoded packet, got %d", len(viewer.Socket))
+		}
+		if packet := <-viewer.Socket; len(packet.Data) != 160 {
+			t.Fatalf("expected a PCMA frame, got %d bytes", len(packet.Data))
+		}
+	}
+	if data, err := os.ReadFile(starts); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("expected ffmpeg to start once, got %q %v", data, err)
+	}
+	for _, viewer := range transcoded {
+		broadcaster.unsubscribe(viewer.Uuid.String())
+	}
+	if audio, _ := broadcaster.audioPackets(); audio != nil {
+		t.Fatal("the transcoder outlived its viewers")
+	}
+}
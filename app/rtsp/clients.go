@@ -1,9 +1,12 @@
 package rtsp
 
 import (
+	"context"
 	"errors"
 	"log"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aicacia/pubsub"
@@ -12,7 +15,6 @@ import (
 	"github.com/aicacia/streams/app/services"
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/h264parser"
-	"github.com/deepch/vdk/format/rtspv2"
 	"github.com/google/uuid"
 )
 
@@ -24,143 +26,252 @@ var (
 var clientsMutex sync.RWMutex
 var clients = make(map[string]*clientST)
 
+// stoppingClients has the done channel of deleted clients until their
+// supervisor exits, a new client for the stream waits for it.
+var stoppingClients = make(map[string]chan struct{})
+
+// clientsAdded is closed and replaced whenever a client is added.
+var clientsAdded = make(chan struct{})
+
 type clientST struct {
-	cameraId string
-	url      string
-	running  bool
-	rtsp     *rtspv2.RTSPClient
-	closed   bool
-	closeCh  chan bool
-	codecs   []av.CodecData
-	viewers  map[string]bool
+	cameraId    string
+	camera      *models.CameraST
+	state       ClientState
+	connectedTs time.Time
+	lastError   error
+	lastErrorTs time.Time
+	reconnects  uint64
+	backoff     time.Duration
+	codecs      []av.CodecData
+	codecsCh    chan struct{}
+	viewers     *broadcasterST
+	updateCh    chan *models.CameraST
+	demandCh    chan struct{}
+	viewersCh   chan struct{}
+	publishCh   chan SourceST
+	cancel      context.CancelFunc
+	done        chan struct{}
 }
 
 func IsCameraStreaming(cameraId string) bool {
+	return GetClientState(cameraId) == ClientStateStreaming
+}
+
+func GetClientState(cameraId string) ClientState {
 	clientsMutex.RLock()
 	defer clientsMutex.RUnlock()
 	if client, ok := clients[cameraId]; ok && client != nil {
-		return client.rtsp != nil
+		return client.state
 	} else {
-		return false
+		return ClientStateStopped
+	}
+}
+
+const streamIdSeparator = "@"
+
+// StreamId is the id of the ingest client for a camera profile, the main
+// profile uses the camera id.
+func StreamId(cameraId, profile string) string {
+	if profile == "" || profile == models.CameraProfileMain {
+		return cameraId
+	}
+	return cameraId + streamIdSeparator + profile
+}
+
+func cameraStreams(camera *models.CameraST) map[string]*models.CameraST {
+	main := *camera
+	main.Profiles = nil
+	streams := map[string]*models.CameraST{camera.Id: &main}
+	for _, profile := range camera.Profiles {
+		stream := main
+		stream.Id = StreamId(camera.Id, profile.Name)
+		stream.RtspUrl = profile.RtspUrl
+		stream.RtspOptions = profile.RtspOptions
+		streams[stream.Id] = &stream
 	}
+	return streams
 }
 
 func runIfNotRunning(camera *models.CameraST) {
-	clientsMutex.RLock()
-	client, ok := clients[camera.Id]
-	clientsMutex.RUnlock()
-	if !ok {
-		client = &clientST{
-			cameraId: camera.Id,
-			url:      camera.RtspUrl,
-			running:  false,
-			closed:   false,
-			closeCh:  make(chan bool, 1),
-			viewers:  make(map[string]bool),
-		}
-		clientsMutex.Lock()
-		clients[camera.Id] = client
-		clientsMutex.Unlock()
+	for _, stream := range cameraStreams(camera) {
+		runStream(stream)
+	}
+}
+
+// runStream starts a supervisor for the stream or hands the camera to the
+// running one, it never waits on the stream.
+func runStream(camera *models.CameraST) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if client, ok := clients[camera.Id]; ok && client != nil {
+		client.update(camera)
+		return
 	}
-	if !client.running {
-		client.running = true
-		client.closed = false
-		for len(client.closeCh) > 0 {
-			<-client.closeCh
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &clientST{
+		cameraId:  camera.Id,
+		camera:    camera,
+		codecsCh:  make(chan struct{}),
+		viewers:   newBroadcaster(),
+		updateCh:  make(chan *models.CameraST, 1),
+		demandCh:  make(chan struct{}, 1),
+		viewersCh: make(chan struct{}, 1),
+		publishCh: make(chan SourceST),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	clients[camera.Id] = client
+	close(clientsAdded)
+	clientsAdded = make(chan struct{})
+	go client.supervise(ctx, camera, stoppingClients[camera.Id])
+}
+
+// update replaces any camera the supervisor has not picked up yet, the
+// caller must hold clientsMutex.
+func (client *clientST) update(camera *models.CameraST) {
+	client.camera = camera
+	for {
+		select {
+		case client.updateCh <- camera:
+			return
+		default:
+			select {
+			case <-client.updateCh:
+			default:
+			}
 		}
-		log.Printf("%s: Starting %s\n", camera.Id, camera.RtspUrl)
-		go rtspWorkerLoop(camera.Id, camera.RtspUrl)
 	}
 }
 
-func clientSendQuit(cameraId string) {
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// clientDemand connects an on demand client that is idle.
+func clientDemand(cameraId string) {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	if client, ok := clients[cameraId]; ok && client != nil && client.camera.OnDemand {
+		signal(client.demandCh)
+	}
+}
+
+func clientDelete(cameraId string) {
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
 	if client, ok := clients[cameraId]; ok && client != nil {
-		client.closed = true
-		client.closeCh <- true
+		delete(clients, cameraId)
+		stoppingClients[cameraId] = client.done
+		client.cancel()
 	}
 }
 
-func clientDelete(cameraId string) {
-	clientSendQuitAndWait(cameraId)
-	clientsMutex.Lock()
-	delete(clients, cameraId)
-	clientsMutex.Unlock()
+func clientDeleteStreams(streams map[string]*models.CameraST) {
+	for streamId := range streams {
+		clientDelete(streamId)
+	}
 }
 
-func clientStop(cameraId string) {
+// stopped runs when the supervisor exits.
+func (client *clientST) stopped() {
+	client.viewers.close()
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		client.running = false
+	client.setStateLocked(ClientStateStopped)
+	client.setCodecsLocked(nil)
+	if stoppingClients[client.cameraId] == client.done {
+		delete(stoppingClients, client.cameraId)
 	}
 }
 
-func clientSetRTSPClient(cameraId string, rtsp_client *rtspv2.RTSPClient) {
+func (client *clientST) setState(state ClientState) {
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		client.rtsp = rtsp_client
-	}
+	client.setStateLocked(state)
 }
 
-func clientSendQuitAndWait(cameraId string) {
-	clientSendQuit(cameraId)
-	for {
-		if !clientIsRunning(cameraId) {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+func (client *clientST) setStateLocked(state ClientState) {
+	if state == ClientStateStreaming && client.state != ClientStateStreaming {
+		client.connectedTs = time.Now().UTC()
+	} else if state != ClientStateStreaming {
+		client.connectedTs = time.Time{}
+	}
+	if state != ClientStateBackoff {
+		client.backoff = 0
 	}
+	client.state = state
 }
 
-func clientIsRunning(cameraId string) bool {
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		return client.running
-	} else {
-		return false
-	}
+func (client *clientST) setBackoff(backoff time.Duration) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	client.setStateLocked(ClientStateBackoff)
+	client.backoff = backoff
 }
 
-func clientIsClosed(cameraId string) bool {
-	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		return client.closed
-	} else {
-		return false
+func (client *clientST) setError(err error) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	client.lastError = err
+	client.lastErrorTs = time.Now().UTC()
+}
+
+func (client *clientST) reconnected() {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	client.reconnects++
+}
+
+func (client *clientST) viewerCount() int {
+	return client.viewers.count()
+}
+
+func streamChanged(stream *models.CameraST, prev_stream *models.CameraST) bool {
+	if stream.RtspUrl != prev_stream.RtspUrl {
+		log.Printf("%s: RTSP Url changed %s\n", prev_stream.RtspUrl, stream.RtspUrl)
+		return true
+	} else if !reflect.DeepEqual(stream.RtspOptions, prev_stream.RtspOptions) {
+		log.Printf("%s: RTSP options changed\n", stream.Id)
+		return true
+	} else if !reflect.DeepEqual(stream.File, prev_stream.File) {
+		log.Printf("%s: File source changed\n", stream.Id)
+		return true
+	} else if !reflect.DeepEqual(stream.Pipeline, prev_stream.Pipeline) {
+		log.Printf("%s: Pipeline changed\n", stream.Id)
+		return true
 	}
+	return false
 }
 
 func clientSwap(camera *models.CameraST, prev_camera *models.CameraST) {
-	if camera.RtspUrl != prev_camera.RtspUrl {
-		log.Printf("%s: RTSP Url changed %s\n", prev_camera.RtspUrl, camera.RtspUrl)
-		clientSendQuitAndWait(camera.Id)
+	prevStreams := cameraStreams(prev_camera)
+	if camera.SourceType() != prev_camera.SourceType() || camera.StreamKey != prev_camera.StreamKey {
+		log.Printf("%s: Source changed %s\n", camera.Id, camera.SourceType())
+	} else {
+		for streamId := range cameraStreams(camera) {
+			delete(prevStreams, streamId)
+		}
 	}
+	clientDeleteStreams(prevStreams)
 	runIfNotRunning(camera)
 }
 
-func clientCodecsAdd(cameraId string, codecs []av.CodecData) {
+func (client *clientST) setCodecs(codecs []av.CodecData) {
 	clientsMutex.Lock()
 	defer clientsMutex.Unlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		client.codecs = codecs
-	}
+	client.setCodecsLocked(codecs)
 }
 
-func WaitForClient(cameraId string) {
-	for {
-		clientsMutex.RLock()
-		client, ok := clients[cameraId]
-		clientsMutex.RUnlock()
-		if ok && client != nil && client.running {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
+// setCodecsLocked wakes everyone waiting on the codecs, the caller must
+// hold clientsMutex.
+func (client *clientST) setCodecsLocked(codecs []av.CodecData) {
+	client.codecs = codecs
+	client.viewers.reset(codecs)
+	close(client.codecsCh)
+	client.codecsCh = make(chan struct{})
 }
 
 func GetCurrentCodecs(cameraId string) []av.CodecData {
@@ -172,209 +283,251 @@ func GetCurrentCodecs(cameraId string) []av.CodecData {
 	return nil
 }
 
-func GetCodecs(cameraId string) []av.CodecData {
-	for i := 0; i < config.Config.RTSP.Connect.Timeout.Seconds*10; i++ {
+func codecsReady(cameraId string, codecs []av.CodecData) bool {
+	if len(codecs) == 0 {
+		return false
+	}
+	for _, codec := range codecs {
+		if codec.Type() == av.H264 {
+			codecVideo, ok := codec.(h264parser.CodecData)
+			if !ok || codecVideo.SPS() == nil || codecVideo.PPS() == nil || len(codecVideo.SPS()) <= 0 || len(codecVideo.PPS()) <= 0 {
+				log.Printf("%s: Bad Video Codec SPS or PPS Wait\n", cameraId)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WaitForCodecs waits until the stream has codecs a viewer can play,
+// connecting on demand streams. It returns nil once ctx is done or when
+// there is no client for the stream.
+func WaitForCodecs(ctx context.Context, cameraId string) []av.CodecData {
+	return waitForCodecs(ctx, cameraId, false)
+}
+
+func waitForCodecs(ctx context.Context, cameraId string, waitForClient bool) []av.CodecData {
+	for ctx.Err() == nil {
+		clientDemand(cameraId)
 		clientsMutex.RLock()
 		client, ok := clients[cameraId]
+		var codecs []av.CodecData
+		var codecsCh, clientsCh chan struct{}
+		if ok && client != nil {
+			codecs = client.codecs
+			codecsCh = client.codecsCh
+		} else {
+			clientsCh = clientsAdded
+		}
 		clientsMutex.RUnlock()
-		if !ok || client == nil {
-			log.Printf("%s: No client\n", client.url)
+		if codecsCh == nil && !waitForClient {
+			log.Printf("%s: No client\n", cameraId)
 			return nil
 		}
-		if client.codecs != nil {
-			valid_codecs := len(client.codecs) > 0
-			for _, codec := range client.codecs {
-				if codec.Type() == av.H264 {
-					codecVideo, ok := codec.(h264parser.CodecData)
-					if !ok || codecVideo.SPS() == nil || codecVideo.PPS() == nil || len(codecVideo.SPS()) <= 0 || len(codecVideo.PPS()) <= 0 {
-						log.Printf("%s: Bad Video Codec SPS or PPS Wait\n", client.url)
-						valid_codecs = false
-						break
-					}
-				}
-			}
-			if valid_codecs {
-				log.Printf("%s: Ok Video Ready to play\n", client.url)
-				return client.codecs
-			}
+		if codecsReady(cameraId, codecs) {
+			log.Printf("%s: Ok Video Ready to play\n", cameraId)
+			return codecs
+		}
+		select {
+		case <-codecsCh:
+		case <-clientsCh:
+		case <-ctx.Done():
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
 	return nil
 }
 
-var viewersMutex sync.RWMutex
-var viewers = make(map[string]*ViewerST)
+func GetCodecs(cameraId string) []av.CodecData {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Config.RTSP.Connect.Timeout.Seconds)*time.Second)
+	defer cancel()
+	return WaitForCodecs(ctx, cameraId)
+}
 
 const viewerChanSize = 1024
 
-func setPacketTime(packet *av.Packet) *av.Packet {
-	packet.Time = time.Duration(time.Now().UTC().UnixNano())
-	return packet
-}
+// gopMaxPackets bounds the cached GOP so it always fits in a new viewer's
+// socket, longer GOPs are not cached.
+const gopMaxPackets = viewerChanSize / 2
 
 func GetPacketTime(packet *av.Packet) time.Time {
 	return time.UnixMicro(packet.Time.Microseconds()).UTC()
 }
 
+// ViewerST receives the packets of a stream on Socket. A viewer that falls
+// behind drops whole GOPs, once Socket is full every packet is dropped until
+// the next keyframe finds room again so the viewer never decodes a broken GOP.
 type ViewerST struct {
-	Uuid   uuid.UUID
-	Socket chan *av.Packet
+	Uuid         uuid.UUID
+	Socket       chan *av.Packet
+	waitKeyframe bool
+	dropped      atomic.Uint64
+	droppedGOPs  atomic.Uint64
+	// codecs of the packets on Socket, set before the first of them is sent
+	codecs []av.CodecData
+	// closeOnCodecs viewers have Socket closed with codecsChanged set when
+	// the codecs of the stream change
+	closeOnCodecs bool
+	codecsChanged bool
+	// transcodeAudio viewers are sent the audio of the stream's transcoder
+	transcodeAudio bool
 }
 
-func AddViewer(cameraId string) *ViewerST {
-	clientsMutex.RLock()
-	client, ok := clients[cameraId]
-	clientsMutex.RUnlock()
-	if ok && client != nil {
-		viewer := ViewerST{
-			Uuid:   uuid.New(),
-			Socket: make(chan *av.Packet, viewerChanSize),
-		}
-		uuid := viewer.Uuid.String()
-
-		clientsMutex.Lock()
-		client.viewers[uuid] = true
-		clientsMutex.Unlock()
+// Dropped is the number of packets the viewer skipped.
+func (v *ViewerST) Dropped() uint64 {
+	return v.dropped.Load()
+}
 
-		viewersMutex.Lock()
-		viewers[uuid] = &viewer
-		viewersMutex.Unlock()
+// CodecsChanged is true once Socket was closed because the codecs of the
+// stream changed.
+func (v *ViewerST) CodecsChanged() bool {
+	return v.codecsChanged
+}
 
-		return &viewer
-	}
-	return nil
+// DroppedGOPs is the number of times the viewer fell behind and skipped
+// ahead to a keyframe.
+func (v *ViewerST) DroppedGOPs() uint64 {
+	return v.droppedGOPs.Load()
 }
 
-func DeleteViewer(cameraId string, uuid *uuid.UUID) {
-	clientsMutex.Lock()
-	defer clientsMutex.Unlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		uuidString := uuid.String()
-		delete(client.viewers, uuidString)
-		viewersMutex.Lock()
-		delete(viewers, uuidString)
-		viewersMutex.Unlock()
-		log.Printf("%s: Closed camera viewer %s", cameraId, uuidString)
-	}
+// AddViewer connects on demand clients and holds the first viewer until
+// the codecs are ready. The viewer starts with the cached GOP so it has a
+// keyframe right away, without one it waits for the next keyframe.
+func AddViewer(cameraId string) *ViewerST {
+	return addViewer(cameraId, false, false)
 }
 
-func (v *ViewerST) cast(packet *av.Packet) {
-	if len(v.Socket) < cap(v.Socket) {
-		v.Socket <- packet
-	}
+// AddCodecsViewer is AddViewer for a viewer that has to start over when the
+// codecs of the stream change, its Socket is closed and CodecsChanged is
+// true.
+func AddCodecsViewer(cameraId string) *ViewerST {
+	return addViewer(cameraId, true, false)
 }
 
-func cast(cameraId string, packet *av.Packet) {
+// AddTranscodedViewer is AddViewer for a viewer that can not play the audio
+// of the stream, it is transcoded once for all of them. It returns the
+// codecs of the packets the viewer is sent, the viewer is closed when the
+// audio changes.
+func AddTranscodedViewer(cameraId string) (*ViewerST, []av.CodecData) {
+	viewer := addViewer(cameraId, false, true)
+	if viewer == nil {
+		return nil, nil
+	}
 	clientsMutex.RLock()
-	defer clientsMutex.RUnlock()
-	if client, ok := clients[cameraId]; ok && client != nil {
-		for id := range client.viewers {
-			viewersMutex.RLock()
-			viewer, ok := viewers[id]
-			viewersMutex.RUnlock()
-			if ok && viewer != nil {
-				viewer.cast(packet)
-			}
-		}
+	client, ok := clients[cameraId]
+	clientsMutex.RUnlock()
+	if !ok || client == nil {
+		return viewer, nil
 	}
+	return viewer, client.viewers.viewerCodecs(viewer)
 }
 
-const max_wait_s = time.Duration(30) * time.Second
-
-func rtspWorkerLoop(cameraId, url string) {
-	defer clientStop(cameraId)
-	wait_s := time.Duration(1) * time.Second
-	for {
-		closed, err := rtspWorker(cameraId, url)
-		if err != nil {
-			log.Printf("%s: Error %s\n", url, err)
-		}
-		if closed || clientIsClosed(cameraId) {
-			log.Printf("%s: Closed\n", url)
-			break
+func addViewer(cameraId string, closeOnCodecs, transcodeAudio bool) *ViewerST {
+	clientDemand(cameraId)
+	clientsMutex.RLock()
+	client, ok := clients[cameraId]
+	clientsMutex.RUnlock()
+	if ok && client != nil {
+		viewer := &ViewerST{
+			Uuid:           uuid.New(),
+			Socket:         make(chan *av.Packet, viewerChanSize),
+			waitKeyframe:   true,
+			closeOnCodecs:  closeOnCodecs,
+			transcodeAudio: transcodeAudio,
 		}
-		log.Printf("%s: Waiting %s\n", cameraId, wait_s)
-		time.Sleep(wait_s)
-		if wait_s < max_wait_s {
-			wait_s = wait_s * 2
+		client.viewers.subscribe(viewer)
+		clientsMutex.RLock()
+		onDemand := client.camera.OnDemand
+		clientsMutex.RUnlock()
+		signal(client.viewersCh)
+
+		if onDemand && GetCurrentCodecs(cameraId) == nil {
+			GetCodecs(cameraId)
 		}
+		return viewer
 	}
+	return nil
 }
 
-func rtspWorker(cameraId, url string) (bool, error) {
-	rtsp_client, err := rtspv2.Dial(rtspv2.RTSPClientOptions{
-		URL:              url,
-		DisableAudio:     false,
-		DialTimeout:      time.Duration(config.Config.RTSP.Connect.Timeout.Seconds) * time.Second,
-		ReadWriteTimeout: time.Duration(config.Config.RTSP.IO.Timeout.Seconds) * time.Second,
-		Debug:            config.Config.RTSP.Debug,
-	})
-	if err != nil {
-		return false, err
-	}
-	defer rtsp_client.Close()
-
-	clientSetRTSPClient(cameraId, rtsp_client)
-	defer clientSetRTSPClient(cameraId, nil)
-
-	if rtsp_client.CodecData != nil {
-		log.Printf("%s: Codecs: %d\n", url, len(rtsp_client.CodecData))
-		clientCodecsAdd(cameraId, rtsp_client.CodecData)
-	}
+func DeleteViewer(cameraId string, uuid *uuid.UUID) {
+	uuidString := uuid.String()
 	clientsMutex.RLock()
 	client, ok := clients[cameraId]
 	clientsMutex.RUnlock()
 	if !ok || client == nil {
-		return true, ErrorRTSPClientNoClient
+		return
+	}
+	viewer := client.viewers.unsubscribe(uuidString)
+	if viewer == nil {
+		return
+	}
+	signal(client.viewersCh)
+	if viewer.Dropped() > 0 {
+		log.Printf("%s: Closed camera viewer %s, dropped %d packets in %d GOPs\n", cameraId, uuidString, viewer.Dropped(), viewer.DroppedGOPs())
+	} else {
+		log.Printf("%s: Closed camera viewer %s\n", cameraId, uuidString)
+	}
+}
+
+// cast is serialized by the viewer's broadcaster, audio only streams have
+// no keyframes so any packet resumes a viewer.
+func (v *ViewerST) cast(packet *av.Packet, audioOnly bool) {
+	if v.waitKeyframe {
+		if !(packet.IsKeyFrame || audioOnly) || len(v.Socket) >= cap(v.Socket) {
+			v.dropped.Add(1)
+			return
+		}
+		v.waitKeyframe = false
 	}
-	quitCh := &client.closeCh
+	select {
+	case v.Socket <- packet:
+	default:
+		v.waitKeyframe = true
+		v.dropped.Add(1)
+		v.droppedGOPs.Add(1)
+	}
+}
+
+func runClients(subscriber *pubsub.Subscriber[services.CameraEvent], done chan struct{}) {
+	defer close(done)
+	defer subscriber.Close()
+
 	for {
 		select {
-		case quit := <-*quitCh:
-			log.Printf("%s: Camera kill signal=%v\n", url, quit)
-			return true, nil
-		case signals := <-rtsp_client.Signals:
-			log.Printf("%s: Got a signal from RTSPClient.Signals\n", url)
-			switch signals {
-			case rtspv2.SignalCodecUpdate:
-				log.Printf("%s: rtspv2.SignalCodecUpdate, Codecs: %d\n", url, len(rtsp_client.CodecData))
-				clientCodecsAdd(cameraId, rtsp_client.CodecData)
-			case rtspv2.SignalStreamRTPStop:
-				log.Printf("%s: rtspv2.SignalClientRTPStop\n", url)
-				return false, ErrorRTSPClientExitRtspDisconnect
+		case <-eventsStop:
+			return
+		case e, ok := <-subscriber.C:
+			if !ok {
+				return
 			}
-		case packetAV := <-rtsp_client.OutgoingPacketQueue:
-			cast(cameraId, setPacketTime(packetAV))
+			handleClientsEvent(e)
 		}
 	}
 }
 
-func runClients(subscriber *pubsub.Subscriber[services.CameraEvent]) {
-	defer subscriber.Close()
-
-	for e := range subscriber.C {
-		switch (*e).Type() {
-		case services.CameraAdded:
-			event := (*e).(*services.AddCameraEvent)
-			runIfNotRunning(event.Camera)
-		case services.CameraUpdated:
-			event := (*e).(*services.UpdateCameraEvent)
-			if event.Camera.Disabled {
-				clientDelete(event.Camera.Id)
-			} else if event.PrevCamera != nil {
-				clientSwap(event.Camera, event.PrevCamera)
-			} else {
-				runIfNotRunning(event.Camera)
+func handleClientsEvent(e *services.CameraEvent) {
+	switch (*e).Type() {
+	case services.CameraAdded:
+		event := (*e).(*services.AddCameraEvent)
+		runIfNotRunning(event.Camera)
+	case services.CameraUpdated:
+		event := (*e).(*services.UpdateCameraEvent)
+		if event.Camera.Disabled {
+			clientDeleteStreams(cameraStreams(event.Camera))
+			if event.PrevCamera != nil {
+				clientDeleteStreams(cameraStreams(event.PrevCamera))
 			}
-		case services.CameraDeleted:
-			event := (*e).(*services.DeleteCameraEvent)
-			clientDelete(event.Camera.Id)
+		} else if event.PrevCamera != nil {
+			clientSwap(event.Camera, event.PrevCamera)
+		} else {
+			runIfNotRunning(event.Camera)
 		}
+	case services.CameraDeleted:
+		event := (*e).(*services.DeleteCameraEvent)
+		clientDeleteStreams(cameraStreams(event.Camera))
 	}
 }
 
 func InitClients() {
 	subscriber := services.CameraEventPubSub.Subscribe()
-	go runClients(subscriber)
+	go runClients(subscriber, addEventSubscriber())
 }
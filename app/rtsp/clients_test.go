@@ -0,0 +1,138 @@
+package rtsp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+)
+
+const testSource = "test"
+
+// testSourceST sends a packet every millisecond until it is closed.
+type testSourceST struct {
+	mutex  sync.Mutex
+	closed chan struct{}
+	count  int
+}
+
+func dialTestSource(camera *models.CameraST) (SourceST, error) {
+	return &testSourceST{closed: make(chan struct{})}, nil
+}
+
+func (s *testSourceST) Streams() ([]av.CodecData, error) {
+	return []av.CodecData{codec.NewPCMMulawCodecData()}, nil
+}
+
+func (s *testSourceST) ReadPacket() (av.Packet, error) {
+	select {
+	case <-s.closed:
+		return av.Packet{}, io.EOF
+	case <-time.After(time.Millisecond):
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	return av.Packet{
+		Time: time.Duration(s.count) * time.Millisecond,
+		Data: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+	}, nil
+}
+
+func (s *testSourceST) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func init() {
+	RegisterSource(testSource, dialTestSource)
+}
+
+func waitForSocketClosed(t *testing.T, viewer *ViewerST) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-viewer.Socket:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("socket of viewer %s was not closed", viewer.Uuid)
+		}
+	}
+}
+
+func addTestViewers(t *testing.T, streamId string, count int) []*ViewerST {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if WaitForCodecs(ctx, streamId) == nil {
+		t.Fatalf("%s: stream has no codecs", streamId)
+	}
+	viewers := make([]*ViewerST, count)
+	for i := range viewers {
+		if viewers[i] = AddViewer(streamId); viewers[i] == nil {
+			t.Fatalf("%s: failed to add viewer", streamId)
+		}
+	}
+	return viewers
+}
+
+// TestClientChurn restarts and deletes streams with viewers attached, every
+// viewer must be disconnected and no goroutine may be left behind.
+func TestClientChurn(t *testing.T) {
+	config.Config.RTSP.Connect.Timeout.Seconds = 5
+	baseline := runtime.NumGoroutine()
+
+	const streams = 5
+	var viewers []*ViewerST
+	for round := 0; round < 3; round++ {
+		cameras := make([]*models.CameraST, streams)
+		for i := range cameras {
+			cameras[i] = &models.CameraST{
+				Id:      fmt.Sprintf("churn-%d", i),
+				Source:  testSource,
+				RtspUrl: fmt.Sprintf("test://%d/%d", i, round),
+			}
+			runStream(cameras[i])
+		}
+		// a new url restarts the stream which disconnects its viewers
+		for _, viewer := range viewers {
+			waitForSocketClosed(t, viewer)
+		}
+		viewers = nil
+		for _, camera := range cameras {
+			viewers = append(viewers, addTestViewers(t, camera.Id, 3)...)
+		}
+	}
+	for i := 0; i < streams; i++ {
+		clientDelete(fmt.Sprintf("churn-%d", i))
+	}
+	for _, viewer := range viewers {
+		waitForSocketClosed(t, viewer)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left, started with %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"log"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+// The sources do not expose RTCP sender reports, so a source session is
+// mapped to wall clock by the arrival of its first packet, the tracks share
+// that base so they stay in sync. After that packets keep their source
+// timing and the base follows the arrival times only slowly, so network
+// jitter does not reach the timestamps but clock drift between camera and
+// server is corrected, once for all tracks.
+const (
+	// clockDriftDivisor is how many packets it takes to absorb an offset
+	// between source timing and arrival.
+	clockDriftDivisor = 512
+	// clockDriftMaxStep bounds the correction of one packet so timestamps
+	// stay monotonic.
+	clockDriftMaxStep = time.Millisecond
+	// clockMaxOffset is how far source timing may be from arrival before
+	// the track is remapped, a jump this big is a timestamp reset or wrap.
+	// Smaller steps back are left alone, they are B-frames.
+	clockMaxOffset = 5 * time.Second
+)
+
+// packetClockST maps the packet times of one source session to wall clock.
+type packetClockST struct {
+	cameraId string
+	started  bool
+	// base is the wall clock of source time zero
+	base   time.Duration
+	tracks map[int8]*trackClockST
+}
+
+type trackClockST struct {
+	// shift is added to base for a track whose timestamps jumped
+	shift time.Duration
+	last  time.Duration
+	wall  time.Duration
+}
+
+func newPacketClock(cameraId string) *packetClockST {
+	return &packetClockST{
+		cameraId: cameraId,
+		tracks:   make(map[int8]*trackClockST),
+	}
+}
+
+// wallClock sets the time of packet to the wall clock of its source time.
+func (clock *packetClockST) wallClock(packet *av.Packet) *av.Packet {
+	return clock.wallClockAt(packet, time.Duration(time.Now().UTC().UnixNano()))
+}
+
+// wallClockAt maps packet as if it arrived at now.
+func (clock *packetClockST) wallClockAt(packet *av.Packet, now time.Duration) *av.Packet {
+	if !clock.started {
+		clock.started = true
+		clock.base = now - packet.Time
+	}
+	track, ok := clock.tracks[packet.Idx]
+	if !ok {
+		track = &trackClockST{}
+		clock.tracks[packet.Idx] = track
+	}
+	offset := now - (clock.base + track.shift + packet.Time)
+	if offset > clockMaxOffset || offset < -clockMaxOffset {
+		if ok {
+			log.Printf("%s: Track %d timestamps jumped by %s, remapping to wall clock\n", clock.cameraId, packet.Idx, packet.Time-track.last)
+		}
+		track.shift += offset
+	} else {
+		step := offset / clockDriftDivisor
+		if step > clockDriftMaxStep {
+			step = clockDriftMaxStep
+		} else if step < -clockDriftMaxStep {
+			step = -clockDriftMaxStep
+		}
+		clock.base += step
+	}
+	wall := clock.base + track.shift + packet.Time
+	// a correction made on another track never moves this one back
+	if ok && packet.Time >= track.last && wall < track.wall {
+		wall = track.wall
+	}
+	track.last = packet.Time
+	track.wall = wall
+	packet.Time = wall
+	return packet
+}
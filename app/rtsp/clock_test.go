@@ -0,0 +1,75 @@
+package rtsp
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+func TestPacketClock(t *testing.T) {
+	const (
+		start    = 1000 * time.Second
+		interval = 20 * time.Millisecond
+		duration = 20 * time.Second
+		jitter   = 15 * time.Millisecond
+		// tolerance is how far a packet may be from its arrival without
+		// jitter, the first arrival still carries its jitter
+		tolerance = 35 * time.Millisecond
+	)
+	tests := []struct {
+		name string
+		// drift is how much faster the server clock runs than the camera's
+		drift float64
+		// after jumpAt the source times continue from jumpTo
+		jumpAt time.Duration
+		jumpTo time.Duration
+	}{
+		{name: "jitter"},
+		{name: "drift", drift: 0.002},
+		{name: "jump", jumpAt: 5 * time.Second, jumpTo: time.Hour},
+		{name: "reset", drift: 0.001, jumpAt: 10 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			random := rand.New(rand.NewSource(1))
+			clock := newPacketClock("test")
+			var now time.Duration
+			last := make(map[int8]time.Duration)
+			first := make(map[int8]time.Duration)
+			for elapsed := time.Duration(0); elapsed < duration; elapsed += interval {
+				sourceTime := elapsed
+				if test.jumpAt > 0 && elapsed >= test.jumpAt {
+					sourceTime = elapsed - test.jumpAt + test.jumpTo
+				}
+				// audio every interval and video every other, video first
+				tracks := []int8{1}
+				if elapsed%(2*interval) == 0 {
+					tracks = []int8{0, 1}
+				}
+				for _, idx := range tracks {
+					arrival := start + elapsed + time.Duration(float64(elapsed)*test.drift)
+					if jittered := arrival + time.Duration(random.Int63n(int64(2*jitter))) - jitter; jittered > now {
+						now = jittered
+					}
+					packet := clock.wallClockAt(&av.Packet{Idx: idx, Time: sourceTime}, now)
+					if previous, ok := last[idx]; ok && packet.Time <= previous {
+						t.Fatalf("track %d went back at %s: %s after %s", idx, elapsed, packet.Time, previous)
+					}
+					if offset := packet.Time - arrival; offset > tolerance || offset < -tolerance {
+						t.Fatalf("track %d at %s is %s off its arrival", idx, elapsed, offset)
+					}
+					if _, ok := first[idx]; !ok {
+						first[idx] = packet.Time
+					}
+					last[idx] = packet.Time
+				}
+			}
+			// the tracks share the base of the first packet
+			if offset := first[1] - first[0]; offset > clockDriftMaxStep || offset < -clockDriftMaxStep {
+				t.Fatalf("tracks start %s apart", offset)
+			}
+		})
+	}
+}
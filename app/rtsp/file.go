@@ -0,0 +1,289 @@
+package rtsp
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/format"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/util"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/av/avutil"
+)
+
+var (
+	ErrorFileSourceMissing      = errors.New("camera has no file source")
+	ErrorFileSourceNoRecordings = errors.New("no recordings in range")
+)
+
+const recordingReadForward int8 = 1
+
+func init() {
+	RegisterSource(models.CameraSourceFile, dialFileSource)
+}
+
+// fileSourceST loops another source forever, pacing its packets to
+// real time.
+type fileSourceST struct {
+	open    func() (SourceST, error)
+	reader  SourceST
+	codecs  []av.CodecData
+	start   time.Time
+	offset  time.Duration
+	base    time.Duration
+	last    time.Duration
+	started bool
+	done    chan struct{}
+}
+
+func dialFileSource(camera *models.CameraST) (SourceST, error) {
+	if camera.File == nil {
+		return nil, ErrorFileSourceMissing
+	}
+	file := *camera.File
+	var open func() (SourceST, error)
+	if file.Path != "" {
+		path, err := util.ConfinePath(config.Config.Media.Folder, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		open = func() (SourceST, error) {
+			return avutil.Open(path)
+		}
+	} else if file.Start != nil && file.End != nil {
+		open = func() (SourceST, error) {
+			return newRecordingReader(file.CameraId, *file.Start, *file.End)
+		}
+	} else {
+		return nil, ErrorFileSourceMissing
+	}
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	codecs, err := reader.Streams()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &fileSourceST{
+		open:   open,
+		reader: reader,
+		codecs: codecs,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (s *fileSourceST) Streams() ([]av.CodecData, error) {
+	return s.codecs, nil
+}
+
+func (s *fileSourceST) loop() error {
+	s.reader.Close()
+	s.offset += s.last
+	s.last = 0
+	s.started = false
+	reader, err := s.open()
+	if err != nil {
+		s.reader = nil
+		return err
+	}
+	s.reader = reader
+	codecs, err := reader.Streams()
+	if err != nil {
+		return err
+	}
+	if !util.CodecsEqual(s.codecs, codecs) {
+		s.codecs = codecs
+		return ErrorSourceCodecUpdate
+	}
+	return nil
+}
+
+func (s *fileSourceST) ReadPacket() (av.Packet, error) {
+	for {
+		if s.reader == nil {
+			return av.Packet{}, io.EOF
+		}
+		packet, err := s.reader.ReadPacket()
+		if err == io.EOF {
+			if !s.started {
+				return av.Packet{}, io.EOF
+			}
+			if err := s.loop(); err != nil {
+				return av.Packet{}, err
+			}
+			continue
+		} else if err == ErrorSourceCodecUpdate {
+			if s.codecs, err = s.reader.Streams(); err != nil {
+				return av.Packet{}, err
+			}
+			return av.Packet{}, ErrorSourceCodecUpdate
+		} else if err != nil {
+			return av.Packet{}, err
+		}
+		if !s.started {
+			s.base = packet.Time
+			s.started = true
+		}
+		relative := packet.Time - s.base
+		if end := relative + packet.Duration; end > s.last {
+			s.last = end
+		}
+		packet.Time = s.offset + relative
+		if wait := time.Until(s.start.Add(packet.Time)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.done:
+				return av.Packet{}, io.EOF
+			}
+		}
+		return packet, nil
+	}
+}
+
+func (s *fileSourceST) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	if s.reader != nil {
+		return s.reader.Close()
+	}
+	return nil
+}
+
+// recordingReaderST reads the recordings of a camera segment by segment
+// merging the per codec files in time order.
+type recordingReaderST struct {
+	cameraId string
+	current  time.Time
+	start    time.Duration
+	end      time.Duration
+	endTime  time.Time
+	folder   string
+	segments []format.Segment
+	demuxers []*format.Demuxer
+	pending  []*av.Packet
+	codecs   []av.CodecData
+}
+
+func newRecordingReader(cameraId string, start, end time.Time) (*recordingReaderST, error) {
+	r := &recordingReaderST{
+		cameraId: cameraId,
+		current:  util.TruncateToMinute(start),
+		start:    time.Duration(start.UnixNano()),
+		end:      time.Duration(end.UnixNano()),
+		endTime:  end,
+	}
+	if err := r.openSegment(); err != nil {
+		if err == io.EOF {
+			return nil, ErrorFileSourceNoRecordings
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recordingReaderST) closeDemuxers() {
+	for _, demuxer := range r.demuxers {
+		if demuxer != nil {
+			demuxer.Close()
+		}
+	}
+	r.demuxers = nil
+	r.pending = nil
+}
+
+// openSegment opens the next segment of the recordings, a minute holds more
+// than one when the recording restarted or the codecs changed.
+func (r *recordingReaderST) openSegment() error {
+	r.closeDemuxers()
+	for {
+		if len(r.segments) == 0 {
+			if !r.current.Before(r.endTime) {
+				return io.EOF
+			}
+			r.folder = GetRecordingFolderPath(r.cameraId, &r.current)
+			r.current = util.TruncateToMinute(r.current.Add(time.Minute))
+			r.segments, _ = format.Segments(r.folder)
+			continue
+		}
+		segment := r.segments[0]
+		r.segments = r.segments[1:]
+		demuxers, err := format.OpenSegment(r.folder, segment)
+		if err != nil || len(demuxers) == 0 {
+			continue
+		}
+		r.demuxers = demuxers
+		r.pending = make([]*av.Packet, len(demuxers))
+		r.codecs = make([]av.CodecData, len(demuxers))
+		for i, demuxer := range demuxers {
+			r.codecs[i] = demuxer.Codec()
+		}
+		return nil
+	}
+}
+
+func (r *recordingReaderST) Streams() ([]av.CodecData, error) {
+	return r.codecs, nil
+}
+
+func (r *recordingReaderST) nextPacket() *av.Packet {
+	next := -1
+	for i, demuxer := range r.demuxers {
+		if demuxer == nil {
+			continue
+		}
+		for r.pending[i] == nil {
+			packet, err := demuxer.ReadPacket(recordingReadForward)
+			if err != nil {
+				demuxer.Close()
+				r.demuxers[i] = nil
+				break
+			}
+			if packet.Time >= r.start {
+				packet.Data = append([]byte(nil), packet.Data...)
+				r.pending[i] = packet
+			}
+		}
+		if r.pending[i] != nil && (next == -1 || r.pending[i].Time < r.pending[next].Time) {
+			next = i
+		}
+	}
+	if next == -1 {
+		return nil
+	}
+	packet := r.pending[next]
+	r.pending[next] = nil
+	return packet
+}
+
+func (r *recordingReaderST) ReadPacket() (av.Packet, error) {
+	for {
+		packet := r.nextPacket()
+		if packet != nil {
+			if packet.Time > r.end {
+				return av.Packet{}, io.EOF
+			}
+			return *packet, nil
+		}
+		codecs := r.codecs
+		if err := r.openSegment(); err != nil {
+			return av.Packet{}, err
+		}
+		if !util.CodecsEqual(codecs, r.codecs) {
+			return av.Packet{}, ErrorSourceCodecUpdate
+		}
+	}
+}
+
+func (r *recordingReaderST) Close() error {
+	r.closeDemuxers()
+	return nil
+}
@@ -0,0 +1,65 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/aicacia/streams/app/models"
+)
+
+var (
+	ErrorPublisherBusy        = errors.New("camera already has a publisher")
+	ErrorPublisherWrongSource = errors.New("camera does not accept this publisher")
+)
+
+func getClientPublishCh(cameraId string) (chan SourceST, bool) {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	if client, ok := clients[cameraId]; ok && client != nil {
+		return client.publishCh, true
+	}
+	return nil, false
+}
+
+// publish hands publisher to the stream worker, only a worker waiting for
+// a publisher takes it.
+func publish(cameraId string, publisher SourceST) error {
+	publishCh, ok := getClientPublishCh(cameraId)
+	if !ok {
+		return ErrorRTSPClientNoClient
+	}
+	select {
+	case publishCh <- publisher:
+		return nil
+	default:
+		return ErrorPublisherBusy
+	}
+}
+
+func publisherWorkerLoop(ctx context.Context, client *clientST, camera *models.CameraST) {
+	for sessions := 0; ; sessions++ {
+		client.setState(ClientStateWaiting)
+		select {
+		case <-ctx.Done():
+			log.Printf("%s: Closed\n", client.cameraId)
+			return
+		case publisher := <-client.publishCh:
+			if sessions > 0 {
+				client.reconnected()
+			}
+			err := sourceWorker(ctx, client, camera, publisher)
+			publisher.Close()
+			if err != nil {
+				log.Printf("%s: Error %s\n", client.cameraId, err)
+				if err != ErrorSourceExitDisconnect {
+					client.setError(err)
+				}
+			}
+			if ctx.Err() != nil {
+				log.Printf("%s: Closed\n", client.cameraId)
+				return
+			}
+		}
+	}
+}
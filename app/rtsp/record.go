@@ -1,6 +1,7 @@
 package rtsp
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"path"
@@ -10,124 +11,177 @@ import (
 	"github.com/aicacia/pubsub"
 	"github.com/aicacia/streams/app/config"
 	"github.com/aicacia/streams/app/format"
+	"github.com/aicacia/streams/app/models"
 	"github.com/aicacia/streams/app/services"
 	"github.com/aicacia/streams/app/util"
 	"github.com/deepch/vdk/av"
 )
 
 type recorderST struct {
-	cameraId  string
-	recording bool
-	viewer    *ViewerST
+	cameraId string
+	streamId string
+	stopped  bool
+	writing  bool
+	cancel   context.CancelFunc
+	done     chan struct{}
 }
 
 var recordingsMutex sync.RWMutex
 var recordings = make(map[string]*recorderST)
 
-func addRecorder(cameraId string) {
-	recordingsMutex.RLock()
-	recorder, ok := recordings[cameraId]
-	recordingsMutex.RUnlock()
-	if !ok || recorder == nil {
-		WaitForClient(cameraId)
-
-		recorder = &recorderST{
-			cameraId:  cameraId,
-			recording: false,
-			viewer:    AddViewer(cameraId),
+// addRecorder starts recording the stream in the background, a recorder
+// replacing another one for the camera waits for it to finish first.
+func addRecorder(cameraId, streamId string) {
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+	var prevDone <-chan struct{}
+	if prev, ok := recordings[cameraId]; ok && prev != nil {
+		if !prev.stopped && prev.streamId == streamId {
+			return
 		}
-
-		recordingsMutex.Lock()
-		recordings[cameraId] = recorder
-		recordingsMutex.Unlock()
+		prev.stopped = true
+		prev.cancel()
+		prevDone = prev.done
 	}
-	if recorder != nil && !recorder.recording {
-		recorder.recording = true
-		go startRecording(cameraId, recorder.viewer.Socket)
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &recorderST{
+		cameraId: cameraId,
+		streamId: streamId,
+		cancel:   cancel,
+		done:     make(chan struct{}),
 	}
+	recordings[cameraId] = recorder
+	go recorder.run(ctx, prevDone)
 }
 
-func restartRecording(cameraId string) {
-	stopRecording(cameraId)
-	addRecorder(cameraId)
-}
-
+// IsRecording is true while the recorder of the camera is writing its
+// stream.
 func IsRecording(cameraId string) bool {
 	recordingsMutex.RLock()
 	defer recordingsMutex.RUnlock()
 	if recorder, ok := recordings[cameraId]; ok && recorder != nil {
-		return recorder.recording
+		return !recorder.stopped && recorder.writing
 	} else {
 		return false
 	}
 }
 
-func stopRecordingAndWait(cameraId string) {
-	stopRecording(cameraId)
-	for {
-		if !IsRecording(cameraId) {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
+func (recorder *recorderST) setWriting(writing bool) {
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+	recorder.writing = writing
 }
 
-func stopRecording(cameraId string) {
+func removeRecorder(cameraId string) {
 	recordingsMutex.Lock()
 	defer recordingsMutex.Unlock()
 	if recorder, ok := recordings[cameraId]; ok && recorder != nil {
-		recorder.recording = false
+		recorder.stopped = true
+		recorder.cancel()
 	}
 }
 
-func removeRecorder(cameraId string) {
-	stopRecordingAndWait(cameraId)
+func (recorder *recorderST) exited() {
 	recordingsMutex.Lock()
-	delete(recordings, cameraId)
-	recordingsMutex.Unlock()
+	defer recordingsMutex.Unlock()
+	if recordings[recorder.cameraId] == recorder {
+		delete(recordings, recorder.cameraId)
+	}
+	close(recorder.done)
+}
+
+// run waits for the stream and records it until ctx is done, recording
+// resumes when the stream comes back or the muxer fails and starts a new
+// segment right away when the codecs change.
+func (recorder *recorderST) run(ctx context.Context, prevDone <-chan struct{}) {
+	defer recorder.exited()
+	if prevDone != nil {
+		select {
+		case <-prevDone:
+		case <-ctx.Done():
+			return
+		}
+	}
+	for {
+		if waitForCodecs(ctx, recorder.streamId, true) == nil {
+			return
+		}
+		viewer := addViewer(recorder.streamId, true, false)
+		if viewer != nil {
+			log.Printf("%s: Recording %s\n", recorder.cameraId, recorder.streamId)
+			recorder.setWriting(true)
+			if err := startRecording(ctx, recorder.cameraId, recorder.streamId, viewer); err != nil {
+				log.Printf("%s: Recording failed %s\n", recorder.cameraId, err)
+			}
+			recorder.setWriting(false)
+			DeleteViewer(recorder.streamId, &viewer.Uuid)
+			if viewer.codecsChanged {
+				log.Printf("%s: Codecs changed, starting a new segment\n", recorder.cameraId)
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func recordStreamId(camera *models.CameraST) string {
+	return StreamId(camera.Id, camera.RecordProfile)
 }
 
-func GetRecordingFolderPath(cameraId string, t *time.Time) string {
+// GetRecordingFolderPath takes a stream id, recordings of the main profile
+// are stored under the camera id.
+func GetRecordingFolderPath(streamId string, t *time.Time) string {
 	return path.Join(
 		config.Config.Recordings.Folder,
-		cameraId,
+		streamId,
 		fmt.Sprintf("%d/%d/%d/%d/%d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()),
 	)
 }
 
-func startRecording(cameraId string, packets chan *av.Packet) {
+// startRecording writes the packets of viewer until its socket closes, each
+// minute gets a new folder with a header for the codecs of viewer.
+func startRecording(ctx context.Context, cameraId, streamId string, viewer *ViewerST) error {
 	var muxer *format.Muxer
+	defer func() {
+		if muxer != nil {
+			muxer.Close()
+		}
+	}()
 	var nextMinute time.Time
-	for packet := range packets {
+	for {
+		var packet *av.Packet
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-viewer.Socket:
+			if !ok {
+				return nil
+			}
+			packet = p
+		}
 		currentTime := GetPacketTime(packet)
-	Muxer:
+		if muxer != nil && currentTime.After(nextMinute) {
+			muxer.Close()
+			muxer = nil
+		}
 		if muxer == nil {
 			var err error
 			muxer, err = format.NewMuxer(
-				GetRecordingFolderPath(cameraId, &currentTime),
+				GetRecordingFolderPath(streamId, &currentTime),
 			)
 			if err != nil {
-				log.Printf("%s: Failed to create raw muxer %s", cameraId, err)
-				restartRecording(cameraId)
-				return
+				return fmt.Errorf("failed to create raw muxer %w", err)
 			}
-			err = muxer.WriteHeader(GetCurrentCodecs(cameraId))
+			err = muxer.WriteHeader(viewer.codecs)
 			if err != nil {
-				muxer.Close()
-				log.Printf("%s: Failed to write codecs %s", cameraId, err)
-				restartRecording(cameraId)
-				return
+				return fmt.Errorf("failed to write codecs %w", err)
 			}
 			nextMinute = util.TruncateToMinute(currentTime.Add(time.Minute))
 		}
-		if currentTime.After(nextMinute) {
-			muxer.Close()
-			muxer = nil
-			goto Muxer
-		} else if !IsRecording(cameraId) {
-			muxer.Close()
-			break
-		}
 		err := muxer.WritePacket(packet)
 		if err != nil {
 			log.Printf("%s: Failed to write packet %s", cameraId, err)
@@ -135,31 +189,44 @@ func startRecording(cameraId string, packets chan *av.Packet) {
 	}
 }
 
-func runRecord(subscriber *pubsub.Subscriber[services.CameraEvent]) {
+func runRecord(subscriber *pubsub.Subscriber[services.CameraEvent], done chan struct{}) {
+	defer close(done)
 	defer subscriber.Close()
 
-	for e := range subscriber.C {
-		switch (*e).Type() {
-		case services.CameraAdded:
-			event := (*e).(*services.AddCameraEvent)
-			if event.Camera.Recording {
-				addRecorder(event.Camera.Id)
-			}
-		case services.CameraUpdated:
-			event := (*e).(*services.UpdateCameraEvent)
-			if event.Camera.Recording && event.PrevCamera != nil && !event.PrevCamera.Recording {
-				addRecorder(event.Camera.Id)
-			} else {
-				removeRecorder(event.Camera.Id)
+	for {
+		select {
+		case <-eventsStop:
+			return
+		case e, ok := <-subscriber.C:
+			if !ok {
+				return
 			}
-		case services.CameraDeleted:
-			event := (*e).(*services.DeleteCameraEvent)
+			handleRecordEvent(e)
+		}
+	}
+}
+
+func handleRecordEvent(e *services.CameraEvent) {
+	switch (*e).Type() {
+	case services.CameraAdded:
+		event := (*e).(*services.AddCameraEvent)
+		if event.Camera.Recording {
+			addRecorder(event.Camera.Id, recordStreamId(event.Camera))
+		}
+	case services.CameraUpdated:
+		event := (*e).(*services.UpdateCameraEvent)
+		if !event.Camera.Recording || event.Camera.Disabled {
 			removeRecorder(event.Camera.Id)
+		} else {
+			addRecorder(event.Camera.Id, recordStreamId(event.Camera))
 		}
+	case services.CameraDeleted:
+		event := (*e).(*services.DeleteCameraEvent)
+		removeRecorder(event.Camera.Id)
 	}
 }
 
 func InitRecord() {
 	subscriber := services.CameraEventPubSub.Subscribe()
-	go runRecord(subscriber)
+	go runRecord(subscriber, addEventSubscriber())
 }
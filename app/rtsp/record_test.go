@@ -0,0 +1,172 @@
+package rtsp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/format"
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+const testCodecsSource = "test_codecs"
+
+var (
+	testSPS = []byte{0x67, 0x42, 0x00, 0x29, 0xe2, 0x90, 0x14, 0x07, 0xb6, 0x02, 0xdc, 0x04, 0x04, 0x06, 0x90, 0x78, 0x91, 0x15}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+	testIDR = []byte{0x00, 0x00, 0x00, 0x04, 0x65, 0x88, 0x84, 0x00}
+)
+
+// testH264Codec returns the test stream with another level, which is enough
+// for a codec change.
+func testH264Codec(t *testing.T, level byte) av.CodecData {
+	t.Helper()
+	sps := append([]byte(nil), testSPS...)
+	sps[3] = level
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(sps, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+// testCodecsSourceST sends a keyframe every few milliseconds and switches
+// to the next codecs every changeAfter packets.
+type testCodecsSourceST struct {
+	mutex       sync.Mutex
+	closed      chan struct{}
+	codecs      [][]av.CodecData
+	changeAfter int
+	count       int
+}
+
+var testCodecsSourceCodecs [][]av.CodecData
+
+func dialTestCodecsSource(camera *models.CameraST) (SourceST, error) {
+	return &testCodecsSourceST{closed: make(chan struct{}), codecs: testCodecsSourceCodecs, changeAfter: 20}, nil
+}
+
+func (s *testCodecsSourceST) current() int {
+	current := s.count / s.changeAfter
+	if current >= len(s.codecs) {
+		current = len(s.codecs) - 1
+	}
+	return current
+}
+
+func (s *testCodecsSourceST) Streams() ([]av.CodecData, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.codecs[s.current()], nil
+}
+
+func (s *testCodecsSourceST) ReadPacket() (av.Packet, error) {
+	select {
+	case <-s.closed:
+		return av.Packet{}, io.EOF
+	case <-time.After(5 * time.Millisecond):
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prev := s.current()
+	s.count++
+	if s.current() != prev {
+		return av.Packet{}, ErrorSourceCodecUpdate
+	}
+	return av.Packet{
+		IsKeyFrame: true,
+		Time:       time.Duration(s.count) * 5 * time.Millisecond,
+		Duration:   5 * time.Millisecond,
+		Data:       testIDR,
+	}, nil
+}
+
+func (s *testCodecsSourceST) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func init() {
+	RegisterSource(testCodecsSource, dialTestCodecsSource)
+}
+
+// TestRecordCodecChange records a stream whose codecs change, the recording
+// goes on in a new segment of the same folder and both can be read back.
+func TestRecordCodecChange(t *testing.T) {
+	config.Config.RTSP.Connect.Timeout.Seconds = 5
+	config.Config.Recordings.Folder = t.TempDir()
+	// both segments have to land in the folder of one minute
+	if now := time.Now(); now.Second() >= 55 {
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+	}
+	first, second := testH264Codec(t, 0x29), testH264Codec(t, 0x1f)
+	testCodecsSourceCodecs = [][]av.CodecData{{first}, {second}}
+
+	camera := &models.CameraST{Id: "record-codecs", Source: testCodecsSource, RtspUrl: "test_codecs://record"}
+	runStream(camera)
+	defer clientDelete(camera.Id)
+	addRecorder(camera.Id, camera.Id)
+	defer removeRecorder(camera.Id)
+
+	var folder string
+	deadline := time.Now().Add(5 * time.Second)
+	for folder == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("no segment was started for the new codecs")
+		}
+		time.Sleep(20 * time.Millisecond)
+		matches, _ := filepath.Glob(filepath.Join(config.Config.Recordings.Folder, camera.Id, "*/*/*/*/*/0.1"))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Size() > 0 {
+				folder = filepath.Dir(match)
+			}
+		}
+	}
+	removeRecorder(camera.Id)
+
+	segments, err := format.Segments(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[0].Index != 0 || segments[1].Index != 1 {
+		t.Fatalf("expected segments 0 and 1, got %v", segments)
+	}
+	for i, codec := range []av.CodecData{first, second} {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			demuxers, err := format.OpenSegment(folder, segments[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, err := demuxers[0].ReadPacket(1)
+			got := demuxers[0].Codec()
+			demuxers[0].Close()
+			if err == nil {
+				if !bytes.Equal(got.(h264parser.CodecData).SPS(), codec.(h264parser.CodecData).SPS()) {
+					t.Errorf("segment %d: expected the codecs of the stream at the time", i)
+				}
+				if !packet.IsKeyFrame || !bytes.Equal(packet.Data, testIDR) {
+					t.Errorf("segment %d: expected the recorded keyframe, got %x", i, packet.Data)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("segment %d: no packet recorded %v", i, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
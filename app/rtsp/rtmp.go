@@ -0,0 +1,117 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/services"
+	"github.com/deepch/vdk/format/rtmp"
+)
+
+var (
+	ErrorRTMPNotPublishing = errors.New("rtmp connection is not publishing")
+)
+
+func handleRTMPPublish(conn *rtmp.Conn) {
+	streamKey := path.Base(conn.URL.Path)
+	camera, err := services.GetCameraByStreamKey(streamKey)
+	if err != nil || camera.Disabled {
+		log.Printf("%s: Rejected RTMP publisher %s\n", conn.NetConn().RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	if camera.SourceType() != models.CameraSourceRTMP {
+		log.Printf("%s: Rejected RTMP publisher %s\n", camera.Id, ErrorPublisherWrongSource)
+		conn.Close()
+		return
+	}
+	if err := publish(camera.Id, conn); err != nil {
+		log.Printf("%s: Rejected RTMP publisher %s\n", camera.Id, err)
+		conn.Close()
+		return
+	}
+	log.Printf("%s: RTMP publisher connected %s\n", camera.Id, conn.NetConn().RemoteAddr())
+}
+
+var (
+	rtmpMutex    sync.Mutex
+	rtmpListener net.Listener
+)
+
+// acceptRTMPPublisher reads the connect and publish commands within the
+// handshake timeout, players are rejected.
+func acceptRTMPPublisher(netConn net.Conn, timeout time.Duration) (*rtmp.Conn, error) {
+	conn := rtmp.NewServerConn(netConn)
+	if err := netConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Prepare(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !conn.IsPublishing() {
+		conn.Close()
+		return nil, ErrorRTMPNotPublishing
+	}
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func handleRTMPConn(netConn net.Conn) {
+	conn, err := acceptRTMPPublisher(netConn, time.Duration(config.Config.RTMP.Timeout.Seconds)*time.Second)
+	if err != nil {
+		log.Printf("%s: Rejected RTMP connection %s\n", netConn.RemoteAddr(), err)
+		return
+	}
+	handleRTMPPublish(conn)
+}
+
+func InitRTMP() {
+	if !config.Config.RTMP.Enabled {
+		return
+	}
+	addr := fmt.Sprintf("%s:%d", config.Config.Host, config.Config.RTMP.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("RTMP server stopped %s\n", err)
+		return
+	}
+	rtmpMutex.Lock()
+	rtmpListener = listener
+	rtmpMutex.Unlock()
+	go func() {
+		log.Printf("RTMP listening on %s\n", listener.Addr())
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				log.Printf("RTMP server stopped %s\n", err)
+				return
+			}
+			go handleRTMPConn(netConn)
+		}
+	}()
+}
+
+// CloseRTMP stops accepting RTMP publishers, the connected ones stop with
+// their streams.
+func CloseRTMP() error {
+	rtmpMutex.Lock()
+	defer rtmpMutex.Unlock()
+	if rtmpListener == nil {
+		return nil
+	}
+	err := rtmpListener.Close()
+	rtmpListener = nil
+	return err
+}
@@ -0,0 +1,111 @@
+package rtsp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/format/rtmp"
+)
+
+func TestAcceptRTMPPublisher(t *testing.T) {
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		connect func(conn *rtmp.Conn) error
+		err     error
+		timeout bool
+	}{
+		{
+			name: "publisher",
+			connect: func(conn *rtmp.Conn) error {
+				return conn.WriteHeader([]av.CodecData{aac})
+			},
+		},
+		{
+			name: "player",
+			connect: func(conn *rtmp.Conn) error {
+				_, err := conn.Streams()
+				return err
+			},
+			err: ErrorRTMPNotPublishing,
+		},
+		{
+			name: "silent",
+			connect: func(conn *rtmp.Conn) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+			timeout: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			go func() {
+				netConn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					return
+				}
+				defer netConn.Close()
+				netConn.SetDeadline(time.Now().Add(2 * time.Second))
+				conn := rtmp.NewConn(netConn)
+				conn.URL, _ = rtmp.ParseURL("rtmp://" + listener.Addr().String() + "/live/stream-key")
+				test.connect(conn)
+			}()
+			netConn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := acceptRTMPPublisher(netConn, 200*time.Millisecond)
+			if test.timeout {
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					t.Fatalf("expected a timeout, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if conn.URL.Path != "/live/stream-key" {
+				t.Fatalf("unexpected publish path %s", conn.URL.Path)
+			}
+		})
+	}
+}
+
+func TestCloseRTMP(t *testing.T) {
+	config.Config.Host = "127.0.0.1"
+	config.Config.RTMP.Enabled = true
+	config.Config.RTMP.Port = 0
+	defer func() {
+		config.Config.RTMP.Enabled = false
+	}()
+	InitRTMP()
+	rtmpMutex.Lock()
+	addr := rtmpListener.Addr().String()
+	rtmpMutex.Unlock()
+	if err := CloseRTMP(); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("rtmp still accepts connections")
+	}
+}
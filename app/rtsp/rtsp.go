@@ -2,17 +2,121 @@ package rtsp
 
 import (
 	"encoding/gob"
+	"io"
+	"log"
+	"time"
 
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/aacparser"
 	"github.com/deepch/vdk/codec/h264parser"
 	"github.com/deepch/vdk/codec/h265parser"
 	"github.com/deepch/vdk/format"
+	"github.com/deepch/vdk/format/rtspv2"
 )
 
 func init() {
 	format.RegisterAll()
 
 	gob.Register(h264parser.CodecData{})
+	gob.Register(aacparser.CodecData{})
 	gob.Register(h265parser.CodecData{})
 	gob.Register(codec.PCMUCodecData{})
+
+	RegisterSource(models.CameraSourceRTSP, dialURLSource)
+	RegisterSourceScheme("rtsp", dialRTSPSource)
+	RegisterSourceScheme("rtsps", dialRTSPSource)
+}
+
+func cameraRtspOptions(camera *models.CameraST) models.CameraRtspOptionsST {
+	if camera.RtspOptions != nil {
+		return *camera.RtspOptions
+	}
+	return models.CameraRtspOptionsST{}
+}
+
+func cameraConnectTimeout(camera *models.CameraST) time.Duration {
+	if seconds := cameraRtspOptions(camera).ConnectTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Duration(config.Config.RTSP.Connect.Timeout.Seconds) * time.Second
+}
+
+func cameraIOTimeout(camera *models.CameraST) time.Duration {
+	if seconds := cameraRtspOptions(camera).IOTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Duration(config.Config.RTSP.IO.Timeout.Seconds) * time.Second
+}
+
+type rtspSourceST struct {
+	url    string
+	client *rtspv2.RTSPClient
+	done   chan struct{}
+}
+
+func dialRTSPSource(camera *models.CameraST) (SourceST, error) {
+	options := cameraRtspOptions(camera)
+	debug := config.Config.RTSP.Debug
+	if options.Debug != nil {
+		debug = *options.Debug
+	}
+	tlsConfig, err := rtspTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	client, err := rtspv2.Dial(rtspv2.RTSPClientOptions{
+		URL:                camera.RtspUrl,
+		DisableAudio:       options.DisableAudio,
+		DialTimeout:        cameraConnectTimeout(camera),
+		ReadWriteTimeout:   cameraIOTimeout(camera),
+		Debug:              debug,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		TLSConfig:          tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rtspSourceST{
+		url:    camera.RtspUrl,
+		client: client,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (s *rtspSourceST) Streams() ([]av.CodecData, error) {
+	return s.client.CodecData, nil
+}
+
+func (s *rtspSourceST) ReadPacket() (av.Packet, error) {
+	for {
+		select {
+		case <-s.done:
+			return av.Packet{}, io.EOF
+		case signals := <-s.client.Signals:
+			log.Printf("%s: Got a signal from RTSPClient.Signals\n", s.url)
+			switch signals {
+			case rtspv2.SignalCodecUpdate:
+				log.Printf("%s: rtspv2.SignalCodecUpdate, Codecs: %d\n", s.url, len(s.client.CodecData))
+				return av.Packet{}, ErrorSourceCodecUpdate
+			case rtspv2.SignalStreamRTPStop:
+				log.Printf("%s: rtspv2.SignalClientRTPStop\n", s.url)
+				return av.Packet{}, ErrorRTSPClientExitRtspDisconnect
+			}
+		case packet := <-s.client.OutgoingPacketQueue:
+			return *packet, nil
+		}
+	}
+}
+
+func (s *rtspSourceST) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+		s.client.Close()
+	}
+	return nil
 }
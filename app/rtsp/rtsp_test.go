@@ -0,0 +1,81 @@
+package rtsp
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/rtsp/rtsptest"
+)
+
+func TestDialRTSPSource(t *testing.T) {
+	server, err := rtsptest.NewTLSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Username, server.Password = "admin", "secret"
+	other, err := rtsptest.NewTLSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		options models.CameraRtspOptionsST
+		check   func(error) bool
+	}{
+		{
+			name:    "ca",
+			url:     server.URL("stream"),
+			options: models.CameraRtspOptionsST{CACert: server.CACert()},
+			check:   func(err error) bool { return err == nil },
+		},
+		{
+			name:    "wrong ca",
+			url:     server.URL("stream"),
+			options: models.CameraRtspOptionsST{CACert: other.CACert()},
+			check: func(err error) bool {
+				var unknownAuthority x509.UnknownAuthorityError
+				return errors.As(err, &unknownAuthority)
+			},
+		},
+		{
+			name:    "invalid ca",
+			url:     server.URL("stream"),
+			options: models.CameraRtspOptionsST{CACert: "not a certificate"},
+			check:   func(err error) bool { return errors.Is(err, ErrorRTSPSCACert) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.ConnectTimeoutSeconds = 2
+			test.options.IOTimeoutSeconds = 2
+			source, err := dialRTSPSource(&models.CameraST{RtspUrl: test.url, RtspOptions: &test.options})
+			if !test.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			defer source.Close()
+			codecs, err := source.Streams()
+			if err != nil || len(codecs) != 2 {
+				t.Fatalf("unexpected streams %v %v", codecs, err)
+			}
+			if _, err := source.ReadPacket(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	// the digest and the request line keep the camera url, not a local proxy
+	for _, request := range server.Requests() {
+		if !strings.HasPrefix(request.URI, "rtsps://localhost:") {
+			t.Fatalf("%s was sent to %s", request.Method, request.URI)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+// Package rtsptest provides a fake RTSP camera for tests, it streams H264
+// and PCMA over TCP interleaved and can require digest auth and tls.
+package rtsptest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/util"
+)
+
+const (
+	Realm = "fake camera"
+	nonce = "6b8b4567327b23c6"
+
+	videoClockRate = 90000
+	audioClockRate = 8000
+	// VideoRTPTime and AudioRTPTime are the rtptime of the RTP-Info of the
+	// PLAY response, the first packets are sent at these timestamps
+	VideoRTPTime = 900000
+	AudioRTPTime = 80000
+	// FrameInterval is the time between the frames of the stream and the
+	// audio packets, every KeyFrameInterval frame is an IDR
+	FrameInterval    = 20 * time.Millisecond
+	KeyFrameInterval = 10
+	AudioPacketSize  = 160
+)
+
+var (
+	// SPS and PPS are 640x480, SPS720 is 1280x720
+	SPS    = mustDecode("Z0IAKeKQFAe2AtwEBAaQeJEV")
+	SPS720 = mustDecode("Z00AH5WoFAFuQA==")
+	PPS    = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func mustDecode(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// IDR is the IDR slice of the stream, it is sent as FU-A fragments.
+func IDR() []byte {
+	idr := make([]byte, 3000)
+	idr[0] = 0x65
+	for i := 1; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	return idr
+}
+
+// Slice is a non IDR slice, it fits in one packet.
+func Slice() []byte {
+	return append([]byte{0x41}, make([]byte, 50)...)
+}
+
+// RequestST is a request the server received.
+type RequestST struct {
+	Method        string
+	URI           string
+	Authorization string
+	Transport     string
+}
+
+// ServerST is an RTSP camera, streams are at any path. Username and
+// Password require digest auth when set.
+type ServerST struct {
+	Username string
+	Password string
+	listener net.Listener
+	host     string
+	scheme   string
+	caCert   string
+
+	mutex    sync.Mutex
+	requests []RequestST
+	sps      []byte
+	sessions map[*sessionST]bool
+	wg       sync.WaitGroup
+}
+
+func newServer(listener net.Listener, scheme, host string) *ServerST {
+	server := &ServerST{
+		listener: listener,
+		scheme:   scheme,
+		host:     host,
+		sps:      SPS,
+		sessions: make(map[*sessionST]bool),
+	}
+	server.wg.Add(1)
+	go server.accept()
+	return server
+}
+
+func NewServer() (*ServerST, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newServer(listener, "rtsp", listener.Addr().String()), nil
+}
+
+// NewTLSServer serves rtsps with a self signed certificate for localhost,
+// CACert is its PEM.
+func NewTLSServer() (*ServerST, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	server := newServer(listener, "rtsps", net.JoinHostPort("localhost", port))
+	server.caCert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return server, nil
+}
+
+// URL is the url of the stream at path with the credentials of the server.
+func (server *ServerST) URL(path string) string {
+	credentials := ""
+	if server.Username != "" {
+		credentials = server.Username + ":" + server.Password + "@"
+	}
+	return server.scheme + "://" + credentials + server.host + "/" + path
+}
+
+func (server *ServerST) CACert() string {
+	return server.caCert
+}
+
+// Requests returns the requests received so far.
+func (server *ServerST) Requests() []RequestST {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]RequestST(nil), server.requests...)
+}
+
+// SetSPS changes the SPS, it is sent in band before the next IDR.
+func (server *ServerST) SetSPS(sps []byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.sps = sps
+}
+
+func (server *ServerST) getSPS() []byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.sps
+}
+
+// Sessions is the number of connected sessions.
+func (server *ServerST) Sessions() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.sessions)
+}
+
+func (server *ServerST) Close() {
+	server.listener.Close()
+	server.mutex.Lock()
+	for session := range server.sessions {
+		session.close()
+	}
+	server.mutex.Unlock()
+	server.wg.Wait()
+}
+
+func (server *ServerST) accept() {
+	defer server.wg.Done()
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		session := &sessionST{
+			server: server,
+			conn:   conn,
+			done:   make(chan struct{}),
+		}
+		server.mutex.Lock()
+		server.sessions[session] = true
+		server.mutex.Unlock()
+		server.wg.Add(1)
+		go session.serve()
+	}
+}
+
+type trackST struct {
+	channel  int
+	sequence uint16
+}
+
+type sessionST struct {
+	server     *ServerST
+	conn       net.Conn
+	writeMutex sync.Mutex
+	tracks     [2]*trackST
+	playing    bool
+	done       chan struct{}
+	closeOnce  sync.Once
+	sender     sync.WaitGroup
+}
+
+func (session *sessionST) close() {
+	session.closeOnce.Do(func() {
+		close(session.done)
+		session.conn.Close()
+	})
+}
+
+func (session *sessionST) serve() {
+	defer session.server.wg.Done()
+	defer func() {
+		session.close()
+		session.sender.Wait()
+		session.server.mutex.Lock()
+		delete(session.server.sessions, session)
+		session.server.mutex.Unlock()
+	}()
+	reader := textproto.NewReader(bufio.NewReader(session.conn))
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		parts := strings.Split(line, " ")
+		if len(parts) != 3 {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		request := RequestST{
+			Method:        parts[0],
+			URI:           parts[1],
+			Authorization: header.Get("Authorization"),
+			Transport:     header.Get("Transport"),
+		}
+		session.server.mutex.Lock()
+		session.server.requests = append(session.server.requests, request)
+		session.server.mutex.Unlock()
+		if !session.server.authorized(request) {
+			session.respond(header, "401 Unauthorized", fmt.Sprintf("WWW-Authenticate: Basic realm=\"%s\"\r\nWWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"\r\n", Realm, Realm, nonce), "")
+			continue
+		}
+		if !session.handle(request, header) {
+			return
+		}
+	}
+}
+
+// authorized checks the digest response against the uri of the request
+// line, a digest made for another uri fails.
+func (server *ServerST) authorized(request RequestST) bool {
+	if server.Username == "" {
+		return true
+	}
+	scheme, params, _ := strings.Cut(request.Authorization, " ")
+	if scheme != "Digest" {
+		return false
+	}
+	values := util.ParseAuthParams(params)
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash(server.Username + ":" + Realm + ":" + server.Password)
+	ha2 := hash(request.Method + ":" + request.URI)
+	return values["username"] == server.Username && values["uri"] == request.URI &&
+		values["response"] == hash(ha1+":"+nonce+":"+ha2)
+}
+
+func (session *sessionST) respond(header textproto.MIMEHeader, status, headers, body string) error {
+	response := fmt.Sprintf("RTSP/1.0 %s\r\nCSeq: %s\r\n%s", status, header.Get("CSeq"), headers)
+	if body != "" {
+		response += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+	}
+	response += "\r\n" + body
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	_, err := io.WriteString(session.conn, response)
+	return err
+}
+
+func (session *sessionST) handle(request RequestST, header textproto.MIMEHeader) bool {
+	base := strings.TrimSuffix(request.URI, "/") + "/"
+	switch request.Method {
+	case "OPTIONS":
+		return session.respond(header, "200 OK", "Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN\r\n", "") == nil
+	case "DESCRIBE":
+		sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=fake\r\nt=0 0\r\n" +
+			"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+			"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(SPS) + "," + base64.StdEncoding.EncodeToString(PPS) + "\r\n" +
+			"a=control:trackID=0\r\n" +
+			"m=audio 0 RTP/AVP 8\r\na=rtpmap:8 PCMA/8000\r\na=control:trackID=1\r\n"
+		return session.respond(header, "200 OK", "Content-Type: application/sdp\r\nContent-Base: "+base+"\r\n", sdp) == nil
+	case "SETUP":
+		index := 0
+		if strings.HasSuffix(request.URI, "trackID=1") {
+			index = 1
+		}
+		track := &trackST{}
+		params := make(map[string]string)
+		for _, part := range strings.Split(request.Transport, ";") {
+			key, value, _ := strings.Cut(part, "=")
+			params[key] = value
+		}
+		interleaved, ok := params["interleaved"]
+		if !ok {
+			return session.respond(header, "461 Unsupported Transport", "", "") == nil
+		}
+		track.channel, _ = strconv.Atoi(strings.Split(interleaved, "-")[0])
+		transport := "RTP/AVP/TCP;unicast;interleaved=" + interleaved
+		session.tracks[index] = track
+		return session.respond(header, "200 OK", "Transport: "+transport+"\r\nSession: 12345678;timeout=60\r\n", "") == nil
+	case "PLAY":
+		if session.tracks[0] == nil || session.playing {
+			return session.respond(header, "455 Method Not Valid in This State", "", "") == nil
+		}
+		session.playing = true
+		rtpInfo := fmt.Sprintf("RTP-Info: url=%strackID=0;seq=0;rtptime=%d,url=%strackID=1;seq=0;rtptime=%d\r\n", base, VideoRTPTime, base, AudioRTPTime)
+		if err := session.respond(header, "200 OK", "Session: 12345678\r\n"+rtpInfo, ""); err != nil {
+			return false
+		}
+		session.sender.Add(1)
+		go session.send()
+		return true
+	case "TEARDOWN":
+		session.respond(header, "200 OK", "", "")
+		return false
+	default:
+		return session.respond(header, "501 Not Implemented", "", "") == nil
+	}
+}
+
+func (session *sessionST) writeRTP(track *trackST, payloadType uint8, marker bool, timestamp uint32, payload []byte) error {
+	if track == nil {
+		return nil
+	}
+	packet := make([]byte, 12+len(payload))
+	packet[0] = 0x80
+	packet[1] = payloadType
+	if marker {
+		packet[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(packet[2:], track.sequence)
+	binary.BigEndian.PutUint32(packet[4:], timestamp)
+	copy(packet[12:], payload)
+	track.sequence++
+	frame := append([]byte{'$', byte(track.channel), 0, 0}, packet...)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(packet)))
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	_, err := session.conn.Write(frame)
+	return err
+}
+
+// sendFrame sends frame n, IDRs go out as a STAP-A of the parameter sets
+// and two FU-A fragments.
+func (session *sessionST) sendFrame(n int) error {
+	video, audio := session.tracks[0], session.tracks[1]
+	timestamp := uint32(VideoRTPTime + n*int(FrameInterval)*videoClockRate/int(time.Second))
+	if n%KeyFrameInterval == 0 {
+		sps := session.server.getSPS()
+		stap := []byte{24}
+		for _, nalu := range [][]byte{sps, PPS} {
+			stap = binary.BigEndian.AppendUint16(stap, uint16(len(nalu)))
+			stap = append(stap, nalu...)
+		}
+		if err := session.writeRTP(video, 96, false, timestamp, stap); err != nil {
+			return err
+		}
+		idr := IDR()
+		half := len(idr) / 2
+		start := append([]byte{0x7c, 0x80 | 5}, idr[1:half]...)
+		end := append([]byte{0x7c, 0x40 | 5}, idr[half:]...)
+		if err := session.writeRTP(video, 96, false, timestamp, start); err != nil {
+			return err
+		}
+		if err := session.writeRTP(video, 96, true, timestamp, end); err != nil {
+			return err
+		}
+	} else if err := session.writeRTP(video, 96, true, timestamp, Slice()); err != nil {
+		return err
+	}
+	audioTimestamp := uint32(AudioRTPTime + n*AudioPacketSize)
+	return session.writeRTP(audio, 8, false, audioTimestamp, make([]byte, AudioPacketSize))
+}
+
+func (session *sessionST) send() {
+	defer session.sender.Done()
+	ticker := time.NewTicker(FrameInterval)
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		if err := session.sendFrame(n); err != nil {
+			return
+		}
+		select {
+		case <-session.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
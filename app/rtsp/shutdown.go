@@ -0,0 +1,76 @@
+package rtsp
+
+import (
+	"context"
+	"sync"
+)
+
+// eventsStop ends the camera event subscribers of the clients and the
+// recorders, each closes its channel in eventSubscribers when it returned.
+var (
+	eventsStop            = make(chan struct{})
+	eventsStopOnce        sync.Once
+	eventSubscribersMutex sync.Mutex
+	eventSubscribers      []chan struct{}
+)
+
+func addEventSubscriber() chan struct{} {
+	eventSubscribersMutex.Lock()
+	defer eventSubscribersMutex.Unlock()
+	done := make(chan struct{})
+	eventSubscribers = append(eventSubscribers, done)
+	return done
+}
+
+// StopEvents stops following camera events so no stream or recorder is
+// started once shutdown began, it waits for the event in progress.
+func StopEvents(ctx context.Context) error {
+	eventsStopOnce.Do(func() {
+		close(eventsStop)
+	})
+	eventSubscribersMutex.Lock()
+	dones := append([]chan struct{}(nil), eventSubscribers...)
+	eventSubscribersMutex.Unlock()
+	return waitAll(ctx, dones)
+}
+
+// Shutdown stops every recorder so its segment is closed, then stops
+// every stream, which closes the sockets of the remaining viewers. It
+// returns ctx's error when the deadline passes first.
+func Shutdown(ctx context.Context) error {
+	recordingsMutex.Lock()
+	recorders := make([]chan struct{}, 0, len(recordings))
+	for _, recorder := range recordings {
+		recorder.stopped = true
+		recorder.cancel()
+		recorders = append(recorders, recorder.done)
+	}
+	recordingsMutex.Unlock()
+	if err := waitAll(ctx, recorders); err != nil {
+		return err
+	}
+
+	clientsMutex.Lock()
+	for cameraId, client := range clients {
+		delete(clients, cameraId)
+		stoppingClients[cameraId] = client.done
+		client.cancel()
+	}
+	streams := make([]chan struct{}, 0, len(stoppingClients))
+	for _, done := range stoppingClients {
+		streams = append(streams, done)
+	}
+	clientsMutex.Unlock()
+	return waitAll(ctx, streams)
+}
+
+func waitAll(ctx context.Context, dones []chan struct{}) error {
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package rtsp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopEvents(t *testing.T) {
+	InitClients()
+	InitRecord()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := StopEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// stopping again finds the subscribers gone
+	if err := StopEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
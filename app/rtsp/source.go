@@ -0,0 +1,208 @@
+package rtsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/pipeline"
+	"github.com/deepch/vdk/av"
+)
+
+var (
+	ErrorSourceCodecUpdate    = errors.New("source codecs changed")
+	ErrorSourceExitDisconnect = errors.New("client exit source disconnect")
+	ErrorSourceNotFound       = errors.New("no source for camera")
+	ErrorSourceUnknownScheme  = errors.New("no source for url scheme")
+)
+
+// SourceST is anything packets can be ingested from, a source returns
+// ErrorSourceCodecUpdate from ReadPacket when Streams has changed.
+type SourceST interface {
+	Streams() ([]av.CodecData, error)
+	ReadPacket() (av.Packet, error)
+	Close() error
+}
+
+type SourceDialer func(camera *models.CameraST) (SourceST, error)
+
+var sourcesMutex sync.RWMutex
+var sources = make(map[string]SourceDialer)
+
+func RegisterSource(source string, dial SourceDialer) {
+	sourcesMutex.Lock()
+	defer sourcesMutex.Unlock()
+	sources[source] = dial
+}
+
+var schemesMutex sync.RWMutex
+var schemes = make(map[string]SourceDialer)
+
+// RegisterSourceScheme adds a dialer for pull sources whose url has the
+// given scheme.
+func RegisterSourceScheme(scheme string, dial SourceDialer) {
+	schemesMutex.Lock()
+	defer schemesMutex.Unlock()
+	schemes[scheme] = dial
+}
+
+func dialURLSource(camera *models.CameraST) (SourceST, error) {
+	u, err := url.Parse(camera.RtspUrl)
+	if err != nil {
+		return nil, err
+	}
+	schemesMutex.RLock()
+	dial, ok := schemes[strings.ToLower(u.Scheme)]
+	schemesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrorSourceUnknownScheme, u.Scheme)
+	}
+	return dial(camera)
+}
+
+func getSourceDialer(source string) (SourceDialer, bool) {
+	sourcesMutex.RLock()
+	defer sourcesMutex.RUnlock()
+	dial, ok := sources[source]
+	return dial, ok
+}
+
+const max_wait_s = time.Duration(30) * time.Second
+
+func sourceWorkerLoop(ctx context.Context, client *clientST, camera *models.CameraST) {
+	dial, ok := getSourceDialer(camera.SourceType())
+	if !ok {
+		log.Printf("%s: Error %s %s\n", camera.Id, ErrorSourceNotFound, camera.SourceType())
+		client.setError(ErrorSourceNotFound)
+		return
+	}
+	wait_s := time.Duration(1) * time.Second
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			client.reconnected()
+		}
+		client.setState(ClientStateConnecting)
+		err := dialSourceWorker(ctx, client, camera, dial)
+		if ctx.Err() != nil {
+			log.Printf("%s: Closed\n", camera.Id)
+			return
+		}
+		if err != nil {
+			log.Printf("%s: Error %s\n", camera.Id, err)
+			if err != ErrorSourceExitDisconnect {
+				client.setError(err)
+			}
+		}
+		client.setBackoff(wait_s)
+		log.Printf("%s: Waiting %s\n", camera.Id, wait_s)
+		select {
+		case <-ctx.Done():
+			log.Printf("%s: Closed\n", camera.Id)
+			return
+		case <-time.After(wait_s):
+		}
+		if wait_s < max_wait_s {
+			wait_s = wait_s * 2
+		}
+	}
+}
+
+func dialSourceWorker(ctx context.Context, client *clientST, camera *models.CameraST, dial SourceDialer) error {
+	source, err := dial(camera)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return sourceWorker(ctx, client, camera, source)
+}
+
+// sourceReadST is a packet, or the codecs of a codec update which are
+// passed along with the packets so viewers see the update in order.
+type sourceReadST struct {
+	packet *av.Packet
+	codecs []av.CodecData
+}
+
+func sourceReadPackets(client *clientST, source SourceST, packets chan<- sourceReadST, done <-chan struct{}) {
+	defer close(packets)
+	for {
+		var read sourceReadST
+		packet, err := source.ReadPacket()
+		if err == ErrorSourceCodecUpdate {
+			codecs, err := source.Streams()
+			if err != nil {
+				return
+			}
+			read.codecs = codecs
+		} else if err != nil {
+			if err != io.EOF {
+				log.Printf("%s: Error %s\n", client.cameraId, err)
+				client.setError(err)
+			} else {
+				client.setError(ErrorSourceExitDisconnect)
+			}
+			return
+		} else {
+			read.packet = &packet
+		}
+		select {
+		case packets <- read:
+		case <-done:
+			return
+		}
+	}
+}
+
+// sourceWorker casts the packets of source through the pipeline of camera
+// until ctx is done or the source disconnects.
+func sourceWorker(ctx context.Context, client *clientST, camera *models.CameraST, source SourceST) error {
+	processors, err := pipeline.New(camera)
+	if err != nil {
+		return err
+	}
+	codecs, err := source.Streams()
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: Codecs: %d\n", client.cameraId, len(codecs))
+	processors.SetCodecs(codecs)
+	client.setCodecs(codecs)
+	client.setState(ClientStateStreaming)
+
+	clock := newPacketClock(client.cameraId)
+	done := make(chan struct{})
+	defer close(done)
+	packets := make(chan sourceReadST, viewerChanSize)
+	go sourceReadPackets(client, source, packets, done)
+
+	for {
+		audio, audioPackets := client.viewers.audioPackets()
+		select {
+		case <-ctx.Done():
+			log.Printf("%s: Camera kill signal\n", client.cameraId)
+			return nil
+		case packet, ok := <-audioPackets:
+			client.viewers.castAudio(audio, packet, ok)
+		case read, ok := <-packets:
+			if !ok {
+				return ErrorSourceExitDisconnect
+			}
+			if read.codecs != nil {
+				log.Printf("%s: Codec Update, Codecs: %d\n", client.cameraId, len(read.codecs))
+				processors.SetCodecs(read.codecs)
+				client.setCodecs(read.codecs)
+				continue
+			}
+			for _, packet := range processors.Process(clock.wallClock(read.packet)) {
+				client.viewers.cast(packet)
+			}
+		}
+	}
+}
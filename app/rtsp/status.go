@@ -0,0 +1,43 @@
+package rtsp
+
+import (
+	"time"
+
+	"github.com/aicacia/streams/app/models"
+)
+
+// GetStreamStatus reports the connection and traffic of a stream, the
+// caller fills in the profile and tracks.
+func GetStreamStatus(streamId string) models.StreamStatusST {
+	status := models.StreamStatusST{
+		StreamId: streamId,
+		State:    ClientStateStopped.String(),
+	}
+	clientsMutex.RLock()
+	client, ok := clients[streamId]
+	if ok && client != nil {
+		status.State = client.state.String()
+		if client.lastError != nil {
+			lastErrorTs := client.lastErrorTs
+			status.LastError = client.lastError.Error()
+			status.LastErrorTs = &lastErrorTs
+		}
+		status.Reconnects = client.reconnects
+		status.BackoffSeconds = client.backoff.Seconds()
+		if !client.connectedTs.IsZero() {
+			connectedTs := client.connectedTs
+			status.ConnectedTs = &connectedTs
+			status.UptimeSeconds = time.Since(connectedTs).Seconds()
+		}
+	}
+	clientsMutex.RUnlock()
+	if ok && client != nil {
+		packetsPerSecond, bitsPerSecond, framesPerSecond := client.viewers.rates()
+		status.PacketsPerSecond = packetsPerSecond
+		status.BitrateKbps = bitsPerSecond / 1000
+		status.FPS = framesPerSecond
+		status.Viewers = client.viewers.count()
+		status.DroppedPackets, status.DroppedGOPs = client.viewers.drops()
+	}
+	return status
+}
@@ -0,0 +1,208 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/flv"
+	"github.com/deepch/vdk/format/ts"
+)
+
+var (
+	ErrorStreamUnknownFormat = errors.New("unknown stream format, expected FLV or MPEG-TS")
+	ErrorStreamBadStatus     = errors.New("stream returned a non 200 status")
+)
+
+const udpMaxDatagramSize = 65536
+
+func init() {
+	RegisterSourceScheme("http", dialHTTPSource)
+	RegisterSourceScheme("https", dialHTTPSource)
+	RegisterSourceScheme("udp", dialUDPSource)
+}
+
+// streamSourceST is an FLV or MPEG-TS demuxer reading from a
+// connection.
+type streamSourceST struct {
+	demuxer av.Demuxer
+	conn    io.Closer
+}
+
+func (s *streamSourceST) Streams() ([]av.CodecData, error) {
+	return s.demuxer.Streams()
+}
+
+func (s *streamSourceST) ReadPacket() (av.Packet, error) {
+	return s.demuxer.ReadPacket()
+}
+
+func (s *streamSourceST) Close() error {
+	return s.conn.Close()
+}
+
+// idleReaderST closes the reader when no read finishes within timeout.
+type idleReaderST struct {
+	reader  io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleReader(reader io.ReadCloser, timeout time.Duration) *idleReaderST {
+	return &idleReaderST{
+		reader:  reader,
+		timeout: timeout,
+		timer:   time.AfterFunc(timeout, func() { reader.Close() }),
+	}
+}
+
+func (r *idleReaderST) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+func (r *idleReaderST) Close() error {
+	r.timer.Stop()
+	return r.reader.Close()
+}
+
+func streamFormat(u *url.URL, contentType string, reader *bufio.Reader) (string, error) {
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".flv":
+		return "flv", nil
+	case ".ts", ".m2ts", ".mpegts":
+		return "ts", nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch strings.ToLower(mediaType) {
+		case "video/x-flv", "video/flv":
+			return "flv", nil
+		case "video/mp2t", "video/mpeg-ts":
+			return "ts", nil
+		}
+	}
+	header, err := reader.Peek(3)
+	if err != nil {
+		return "", err
+	}
+	if string(header) == "FLV" {
+		return "flv", nil
+	} else if header[0] == 0x47 {
+		return "ts", nil
+	}
+	return "", ErrorStreamUnknownFormat
+}
+
+func newStreamDemuxer(format string, reader io.Reader) av.Demuxer {
+	if format == "flv" {
+		return flv.NewDemuxer(reader)
+	}
+	return ts.NewDemuxer(reader)
+}
+
+func dialHTTPSource(camera *models.CameraST) (SourceST, error) {
+	u, err := url.Parse(camera.RtspUrl)
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout := cameraConnectTimeout(camera)
+	client := http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+			TLSHandshakeTimeout:   dialTimeout,
+			ResponseHeaderTimeout: dialTimeout,
+		},
+	}
+	res, err := client.Get(camera.RtspUrl)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, ErrorStreamBadStatus
+	}
+	body := newIdleReader(res.Body, cameraIOTimeout(camera))
+	reader := bufio.NewReader(body)
+	format, err := streamFormat(u, res.Header.Get("Content-Type"), reader)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &streamSourceST{
+		demuxer: newStreamDemuxer(format, reader),
+		conn:    body,
+	}, nil
+}
+
+// udpReaderST reads whole datagrams so MPEG-TS packets are never
+// truncated by a short read buffer.
+type udpReaderST struct {
+	conn    *net.UDPConn
+	timeout time.Duration
+	buf     []byte
+	data    []byte
+}
+
+func (r *udpReaderST) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+		n, err := r.conn.Read(r.buf)
+		if err != nil {
+			return 0, err
+		}
+		r.data = r.buf[:n]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *udpReaderST) Close() error {
+	return r.conn.Close()
+}
+
+func dialUDPSource(camera *models.CameraST) (SourceST, error) {
+	u, err := url.Parse(camera.RtspUrl)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(u.Host, "@"))
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if addr.IP != nil && addr.IP.IsMulticast() {
+		var iface *net.Interface
+		if name := u.Query().Get("iface"); name != "" {
+			if iface, err = net.InterfaceByName(name); err != nil {
+				return nil, err
+			}
+		}
+		conn, err = net.ListenMulticastUDP("udp", iface, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader := &udpReaderST{
+		conn:    conn,
+		timeout: cameraIOTimeout(camera),
+		buf:     make([]byte, udpMaxDatagramSize),
+	}
+	return &streamSourceST{
+		demuxer: ts.NewDemuxer(reader),
+		conn:    reader,
+	}, nil
+}
@@ -0,0 +1,150 @@
+package rtsp
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+)
+
+type ClientState int
+
+const (
+	ClientStateStopped ClientState = iota
+	ClientStateIdle
+	ClientStateWaiting
+	ClientStateConnecting
+	ClientStateStreaming
+	ClientStateBackoff
+)
+
+func (state ClientState) String() string {
+	switch state {
+	case ClientStateIdle:
+		return "idle"
+	case ClientStateWaiting:
+		return "waiting"
+	case ClientStateConnecting:
+		return "connecting"
+	case ClientStateStreaming:
+		return "streaming"
+	case ClientStateBackoff:
+		return "backoff"
+	default:
+		return "stopped"
+	}
+}
+
+// supervise owns the stream of a client until ctx is done, on demand
+// streams are idle until a viewer asks for them. A client replacing a
+// stopping one waits for prevDone so a stream never runs twice.
+func (client *clientST) supervise(ctx context.Context, camera *models.CameraST, prevDone <-chan struct{}) {
+	defer close(client.done)
+	defer client.stopped()
+	if prevDone != nil {
+		select {
+		case <-prevDone:
+		case <-ctx.Done():
+			return
+		}
+	}
+	for {
+		if camera.OnDemand {
+			client.setState(ClientStateIdle)
+			select {
+			case <-ctx.Done():
+				return
+			case camera = <-client.updateCh:
+				continue
+			case <-client.demandCh:
+				log.Printf("%s: Viewer requested on demand client\n", camera.Id)
+			}
+		}
+		var ok bool
+		if camera, ok = client.run(ctx, camera); !ok {
+			return
+		}
+	}
+}
+
+// run streams the camera until ctx is done, the stream changes or an on
+// demand stream has had no viewers for the idle timeout. It returns the
+// camera to continue with and false once ctx is done.
+func (client *clientST) run(ctx context.Context, camera *models.CameraST) (*models.CameraST, bool) {
+	runCtx, cancel := context.WithCancel(ctx)
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		if camera.IsPublished() {
+			log.Printf("%s: Waiting for %s publisher\n", camera.Id, camera.SourceType())
+			publisherWorkerLoop(runCtx, client, camera)
+		} else {
+			log.Printf("%s: Starting %s source\n", camera.Id, camera.SourceType())
+			sourceWorkerLoop(runCtx, client, camera)
+		}
+	}()
+	stop := func() {
+		cancel()
+		if workerDone != nil {
+			<-workerDone
+		}
+		client.setCodecs(nil)
+	}
+	// viewers are disconnected when the stream goes away, an idle on
+	// demand stream keeps any viewer that joined while it was stopping
+	disconnect := func() {
+		stop()
+		client.viewers.disconnect()
+	}
+
+	idleTimeout := time.Duration(config.Config.RTSP.OnDemand.Idle.Seconds) * time.Second
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	resetIdle := func() {
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		if camera.OnDemand && client.viewerCount() == 0 {
+			idle.Reset(idleTimeout)
+		}
+	}
+	resetIdle()
+
+	for {
+		select {
+		case <-ctx.Done():
+			disconnect()
+			return nil, false
+		case next := <-client.updateCh:
+			if workerDone == nil || next.OnDemand != camera.OnDemand || streamChanged(next, camera) {
+				disconnect()
+				return next, true
+			}
+			camera = next
+			resetIdle()
+		case <-client.demandCh:
+			resetIdle()
+		case <-client.viewersCh:
+			resetIdle()
+		case <-idle.C:
+			if client.viewerCount() == 0 {
+				log.Printf("%s: No viewers, disconnecting on demand client\n", camera.Id)
+				stop()
+				return camera, true
+			}
+		case <-workerDone:
+			// the worker only gives up on its own when the camera can not be
+			// streamed, wait for the camera to change
+			cancel()
+			client.setCodecs(nil)
+			client.viewers.disconnect()
+			client.setState(ClientStateStopped)
+			workerDone = nil
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package rtsp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/aicacia/streams/app/models"
+)
+
+var (
+	ErrorRTSPSCACert = errors.New("invalid rtsps ca certificates")
+)
+
+// rtspTLSConfig verifies rtsps cameras against the CA of the options, nil
+// leaves rtspv2 to its default verification.
+func rtspTLSConfig(options models.CameraRtspOptionsST) (*tls.Config, error) {
+	if options.CACert == "" {
+		return nil, nil
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(options.CACert)) {
+		return nil, ErrorRTSPSCACert
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
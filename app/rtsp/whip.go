@@ -0,0 +1,79 @@
+package rtsp
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/aicacia/streams/app/webrtc"
+	"github.com/deepch/vdk/av"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrorWHIPSessionNotFound = errors.New("whip session not found")
+)
+
+type WHIPPublisherST interface {
+	SourceST
+	Done() <-chan struct{}
+}
+
+// whipSourceST reads a publisher as a source, the tracks it starts late come
+// as codec updates.
+type whipSourceST struct {
+	WHIPPublisherST
+}
+
+func (source whipSourceST) ReadPacket() (av.Packet, error) {
+	packet, err := source.WHIPPublisherST.ReadPacket()
+	if err == webrtc.ErrorWHIPCodecUpdate {
+		return packet, ErrorSourceCodecUpdate
+	}
+	return packet, err
+}
+
+type whipSessionST struct {
+	cameraId  string
+	publisher WHIPPublisherST
+}
+
+var whipSessionsMutex sync.RWMutex
+var whipSessions = make(map[string]*whipSessionST)
+
+func PublishWHIP(cameraId string, publisher WHIPPublisherST) (string, error) {
+	if err := publish(cameraId, whipSourceST{publisher}); err != nil {
+		return "", err
+	}
+	sessionId := uuid.New().String()
+	whipSessionsMutex.Lock()
+	whipSessions[sessionId] = &whipSessionST{
+		cameraId:  cameraId,
+		publisher: publisher,
+	}
+	whipSessionsMutex.Unlock()
+	log.Printf("%s: WHIP publisher connected %s\n", cameraId, sessionId)
+	go whipWaitForClose(sessionId, publisher)
+	return sessionId, nil
+}
+
+func whipWaitForClose(sessionId string, publisher WHIPPublisherST) {
+	<-publisher.Done()
+	whipSessionsMutex.Lock()
+	session, ok := whipSessions[sessionId]
+	delete(whipSessions, sessionId)
+	whipSessionsMutex.Unlock()
+	if ok {
+		log.Printf("%s: WHIP publisher closed %s\n", session.cameraId, sessionId)
+	}
+}
+
+func DeleteWHIP(cameraId, sessionId string) error {
+	whipSessionsMutex.RLock()
+	session, ok := whipSessions[sessionId]
+	whipSessionsMutex.RUnlock()
+	if !ok || session.cameraId != cameraId {
+		return ErrorWHIPSessionNotFound
+	}
+	return session.publisher.Close()
+}
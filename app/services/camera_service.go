@@ -1,6 +1,7 @@
 package services
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,17 +10,43 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aicacia/streams/app/config"
 	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/pipeline"
+	"github.com/aicacia/streams/app/util"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrorCameraInvalidSource     = errors.New("invalid camera source")
+	ErrorCameraStreamKeyNotFound = errors.New("no camera for stream key")
+	ErrorCameraStreamKeyInUse    = errors.New("stream key is used by another camera")
+	ErrorCameraInvalidFile       = errors.New("file source needs a path or a camera recording range")
+	ErrorCameraFilePath          = errors.New("file path must be a file inside the media folder")
+	ErrorCameraRtspTransport     = errors.New("invalid rtsp transport, expected tcp")
+	ErrorCameraRtspUDP           = errors.New("rtsp over udp is not supported, cameras are read with tcp interleaved")
+	ErrorCameraRtspCACert        = errors.New("invalid rtsp ca_cert, expected PEM certificates")
+	ErrorCameraRtspTLS           = errors.New("rtsp ca_cert and insecure_skip_verify can not both be set")
+	ErrorCameraRtspTimeout       = errors.New("rtsp timeouts must not be negative")
+	ErrorCameraInvalidProfile    = errors.New("profiles need a unique name other than main and a rtsp_url")
+	ErrorCameraProfileSource     = errors.New("profiles are only supported for rtsp sources")
+	ErrorCameraRecordProfile     = errors.New("record_profile is not a profile of the camera")
+	ErrorCameraOnDemandSource    = errors.New("on_demand is not supported for published sources")
+)
+
 var camerasCreateMutex sync.Mutex
 var camerasUpdateMutex sync.Mutex
 
+// streamKeysMutex is held from checking a stream key until the camera is
+// saved so two cameras can't take the same key, streamKeys maps the keys of
+// published cameras to their ids.
+var streamKeysMutex sync.RWMutex
+var streamKeys = make(map[string]string)
+
 func readCamera(path string) (*models.CameraST, error) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
@@ -82,14 +109,24 @@ func ListCameras() ([]*models.CameraST, error) {
 }
 
 type CameraCreateST struct {
-	Name      string `json:"name"`
-	Url       string `json:"url"`
-	RtspUrl   string `json:"rtsp_url"`
-	Disabled  bool   `json:"disabled"`
-	Recording bool   `json:"recording"`
+	Name          string                      `json:"name"`
+	Url           string                      `json:"url"`
+	RtspUrl       string                      `json:"rtsp_url"`
+	Source        string                      `json:"source"`
+	StreamKey     string                      `json:"stream_key"`
+	File          *models.CameraFileST        `json:"file"`
+	RtspOptions   *models.CameraRtspOptionsST `json:"rtsp_options"`
+	Profiles      []models.CameraProfileST    `json:"profiles"`
+	RecordProfile string                      `json:"record_profile"`
+	OnDemand      bool                        `json:"on_demand"`
+	Onvif         *models.CameraOnvifST       `json:"onvif"`
+	Pipeline      []models.CameraProcessorST  `json:"pipeline"`
+	Disabled      bool                        `json:"disabled"`
+	Recording     bool                        `json:"recording"`
 }
 
 func CreateCamera(create_camera *CameraCreateST) (*models.CameraST, error) {
+	onvifConfig := resolveCameraOnvif(create_camera.Onvif)
 	camerasCreateMutex.Lock()
 	defer camerasCreateMutex.Unlock()
 	id, err := createCameraUUID(100)
@@ -97,29 +134,46 @@ func CreateCamera(create_camera *CameraCreateST) (*models.CameraST, error) {
 		return nil, err
 	}
 	camera := models.CameraST{
-		Id:        id,
-		Name:      create_camera.Name,
-		Url:       create_camera.Url,
-		RtspUrl:   create_camera.RtspUrl,
-		Disabled:  create_camera.Disabled,
-		Recording: create_camera.Recording,
-		CreatedTs: time.Now().UTC(),
-		UpdatedTs: time.Now().UTC(),
-	}
-	writeErr := writeCamera(cameraPath(id), &camera)
-	if writeErr != nil {
-		return nil, writeErr
+		Id:            id,
+		Name:          create_camera.Name,
+		Url:           create_camera.Url,
+		RtspUrl:       create_camera.RtspUrl,
+		Source:        create_camera.Source,
+		StreamKey:     create_camera.StreamKey,
+		File:          create_camera.File,
+		RtspOptions:   create_camera.RtspOptions,
+		Profiles:      create_camera.Profiles,
+		RecordProfile: create_camera.RecordProfile,
+		OnDemand:      create_camera.OnDemand,
+		Onvif:         onvifConfig,
+		Pipeline:      create_camera.Pipeline,
+		Disabled:      create_camera.Disabled,
+		Recording:     create_camera.Recording,
+		CreatedTs:     time.Now().UTC(),
+		UpdatedTs:     time.Now().UTC(),
+	}
+	if err := saveCamera(&camera, nil); err != nil {
+		return nil, err
 	}
 	onAddCamera(&camera)
 	return &camera, nil
 }
 
 type CameraUpdateST struct {
-	Name      *string `json:"name"`
-	Url       *string `json:"url"`
-	RtspUrl   *string `json:"rtsp_url"`
-	Disabled  *bool   `json:"disabled"`
-	Recording *bool   `json:"recording"`
+	Name          *string                     `json:"name"`
+	Url           *string                     `json:"url"`
+	RtspUrl       *string                     `json:"rtsp_url"`
+	Source        *string                     `json:"source"`
+	StreamKey     *string                     `json:"stream_key"`
+	File          *models.CameraFileST        `json:"file"`
+	RtspOptions   *models.CameraRtspOptionsST `json:"rtsp_options"`
+	Profiles      *[]models.CameraProfileST   `json:"profiles"`
+	RecordProfile *string                     `json:"record_profile"`
+	OnDemand      *bool                       `json:"on_demand"`
+	Onvif         *models.CameraOnvifST       `json:"onvif"`
+	Pipeline      *[]models.CameraProcessorST `json:"pipeline"`
+	Disabled      *bool                       `json:"disabled"`
+	Recording     *bool                       `json:"recording"`
 }
 
 func UpdateCamera(id string, update_camera *CameraUpdateST) (*models.CameraST, error) {
@@ -138,6 +192,38 @@ func UpdateCamera(id string, update_camera *CameraUpdateST) (*models.CameraST, e
 	if update_camera.RtspUrl != nil {
 		camera.RtspUrl = *update_camera.RtspUrl
 	}
+	if update_camera.Source != nil {
+		camera.Source = *update_camera.Source
+	}
+	if update_camera.StreamKey != nil {
+		camera.StreamKey = *update_camera.StreamKey
+	}
+	if update_camera.File != nil {
+		camera.File = update_camera.File
+	}
+	if update_camera.RtspOptions != nil {
+		camera.RtspOptions = update_camera.RtspOptions
+	}
+	if update_camera.Profiles != nil {
+		camera.Profiles = *update_camera.Profiles
+	}
+	if update_camera.RecordProfile != nil {
+		camera.RecordProfile = *update_camera.RecordProfile
+	}
+	if update_camera.OnDemand != nil {
+		camera.OnDemand = *update_camera.OnDemand
+	}
+	if update_camera.Pipeline != nil {
+		camera.Pipeline = *update_camera.Pipeline
+	}
+	if update_camera.Onvif != nil {
+		onvifConfig := *update_camera.Onvif
+		// the password is write only, keep it when the device stays the same
+		if onvifConfig.Password == "" && prevCamera.Onvif != nil && prevCamera.Onvif.XAddr == onvifConfig.XAddr && prevCamera.Onvif.Username == onvifConfig.Username {
+			onvifConfig.Password = prevCamera.Onvif.Password
+		}
+		camera.Onvif = resolveCameraOnvif(&onvifConfig)
+	}
 	if update_camera.Disabled != nil {
 		camera.Disabled = *update_camera.Disabled
 	}
@@ -145,24 +231,181 @@ func UpdateCamera(id string, update_camera *CameraUpdateST) (*models.CameraST, e
 		camera.Recording = *update_camera.Recording
 	}
 	camera.UpdatedTs = time.Now().UTC()
-	update_err := writeCamera(path, &camera)
-	if update_err != nil {
-		return nil, update_err
+	if err := saveCamera(&camera, prevCamera); err != nil {
+		return nil, err
 	}
 	onUpdateCamera(&camera, prevCamera)
 	return &camera, nil
 }
 
+// saveCamera validates and writes a camera, its stream key is checked and
+// indexed under streamKeysMutex so no other camera takes it in between.
+func saveCamera(camera, prevCamera *models.CameraST) error {
+	streamKeysMutex.Lock()
+	defer streamKeysMutex.Unlock()
+	if err := validateCameraSource(camera); err != nil {
+		return err
+	}
+	if err := validateCameraProfiles(camera); err != nil {
+		return err
+	}
+	if err := pipeline.Validate(camera); err != nil {
+		return err
+	}
+	if err := writeCamera(cameraPath(camera.Id), camera); err != nil {
+		return err
+	}
+	indexStreamKeyLocked(camera, prevCamera)
+	return nil
+}
+
+func GetCameraByStreamKey(streamKey string) (*models.CameraST, error) {
+	streamKeysMutex.RLock()
+	defer streamKeysMutex.RUnlock()
+	return getCameraByStreamKeyLocked(streamKey)
+}
+
+func getCameraByStreamKeyLocked(streamKey string) (*models.CameraST, error) {
+	id, ok := streamKeys[streamKey]
+	if !ok || streamKey == "" {
+		return nil, ErrorCameraStreamKeyNotFound
+	}
+	camera, err := GetCamera(id)
+	if err != nil || !camera.IsPublished() || !util.SecretEquals(camera.StreamKey, streamKey) {
+		return nil, ErrorCameraStreamKeyNotFound
+	}
+	return camera, nil
+}
+
+// indexStreamKeyLocked moves the stream key of a saved camera from prevCamera,
+// either is nil when the camera was created or deleted.
+func indexStreamKeyLocked(camera, prevCamera *models.CameraST) {
+	if prevCamera != nil && streamKeys[prevCamera.StreamKey] == prevCamera.Id {
+		delete(streamKeys, prevCamera.StreamKey)
+	}
+	if camera != nil && camera.IsPublished() && camera.StreamKey != "" {
+		streamKeys[camera.StreamKey] = camera.Id
+	}
+}
+
+func validateCameraSource(camera *models.CameraST) error {
+	camera.Source = camera.SourceType()
+	switch camera.Source {
+	case models.CameraSourceRTSP:
+		return validateCameraRtspOptions(camera.RtspOptions)
+	case models.CameraSourceRTMP, models.CameraSourceWHIP:
+		// a published stream only exists while the publisher is connected
+		if camera.OnDemand {
+			return ErrorCameraOnDemandSource
+		}
+		if camera.StreamKey == "" {
+			camera.StreamKey = uuid.New().String()
+		}
+		if other, err := getCameraByStreamKeyLocked(camera.StreamKey); err == nil && other.Id != camera.Id {
+			return ErrorCameraStreamKeyInUse
+		}
+		return nil
+	case models.CameraSourceFile:
+		return validateCameraFile(camera.File)
+	default:
+		return ErrorCameraInvalidSource
+	}
+}
+
+func validateCameraFile(file *models.CameraFileST) error {
+	if file == nil {
+		return ErrorCameraInvalidFile
+	}
+	if file.Path != "" {
+		if file.CameraId != "" {
+			return ErrorCameraInvalidFile
+		}
+		path, err := util.ConfinePath(config.Config.Media.Folder, file.Path)
+		if errors.Is(err, util.ErrorPathOutsideRoot) {
+			return ErrorCameraFilePath
+		} else if err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err != nil {
+			return err
+		} else if !info.Mode().IsRegular() {
+			return ErrorCameraFilePath
+		}
+		return nil
+	}
+	if file.Start == nil || file.End == nil || !file.Start.Before(*file.End) {
+		return ErrorCameraInvalidFile
+	}
+	// the id is part of the camera and recordings paths
+	if file.CameraId == "" || file.CameraId == "." || file.CameraId == ".." || strings.ContainsAny(file.CameraId, `/\`) {
+		return ErrorCameraInvalidFile
+	}
+	_, err := GetCamera(file.CameraId)
+	return err
+}
+
+func validateCameraRtspOptions(options *models.CameraRtspOptionsST) error {
+	if options == nil {
+		return nil
+	}
+	switch strings.ToLower(options.Transport) {
+	case "", models.RtspTransportTCP:
+	case models.RtspTransportUDP:
+		return ErrorCameraRtspUDP
+	default:
+		return ErrorCameraRtspTransport
+	}
+	if options.CACert != "" {
+		if options.InsecureSkipVerify {
+			return ErrorCameraRtspTLS
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(options.CACert)) {
+			return ErrorCameraRtspCACert
+		}
+	}
+	if options.ConnectTimeoutSeconds < 0 || options.IOTimeoutSeconds < 0 {
+		return ErrorCameraRtspTimeout
+	}
+	return nil
+}
+
+func validateCameraProfiles(camera *models.CameraST) error {
+	if len(camera.Profiles) > 0 && camera.SourceType() != models.CameraSourceRTSP {
+		return ErrorCameraProfileSource
+	}
+	names := make(map[string]bool)
+	for _, profile := range camera.Profiles {
+		if profile.Name == "" || profile.Name == models.CameraProfileMain || strings.ContainsAny(profile.Name, "@/") || profile.RtspUrl == "" || names[profile.Name] {
+			return ErrorCameraInvalidProfile
+		}
+		names[profile.Name] = true
+		if err := validateCameraRtspOptions(profile.RtspOptions); err != nil {
+			return err
+		}
+	}
+	if !camera.HasProfile(camera.RecordProfile) {
+		return ErrorCameraRecordProfile
+	}
+	return nil
+}
+
 func DeleteCamera(id string) (*models.CameraST, error) {
 	path := cameraPath(id)
 	camera, err := readCamera(path)
 	if err != nil {
 		return nil, err
 	}
+	streamKeysMutex.Lock()
 	err = os.Remove(path)
+	if err == nil {
+		indexStreamKeyLocked(nil, camera)
+	}
+	streamKeysMutex.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	deleteSnapshot(id)
+	removeOnvifCamera(id)
 	onRemoveCamera(camera)
 	return camera, nil
 }
@@ -171,7 +414,7 @@ func createCameraUUID(retries int) (string, error) {
 	for {
 		id := uuid.New().String()
 		err := cameraExists(id)
-		if err == nil {
+		if errors.Is(err, fs.ErrNotExist) {
 			return id, nil
 		} else if retries <= 0 {
 			return "", errors.New("failed to create camera id")
@@ -186,6 +429,11 @@ func InitCameras() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	streamKeysMutex.Lock()
+	for _, camera := range allCameras {
+		indexStreamKeyLocked(camera, nil)
+	}
+	streamKeysMutex.Unlock()
 	for _, camera := range allCameras {
 		onAddCamera(camera)
 	}
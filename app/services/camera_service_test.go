@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+)
+
+func TestValidateCameraFile(t *testing.T) {
+	setupTestCameras(t)
+	media := t.TempDir()
+	config.Config.Media.Folder = media
+	outside := filepath.Join(t.TempDir(), "outside.mp4")
+	for _, path := range []string{filepath.Join(media, "loop.mp4"), outside} {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(media, "clips"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(media, "link.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	camera, err := CreateCamera(&CameraCreateST{RtspUrl: "rtsp://127.0.0.1/recorded", Disabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	end := time.Now()
+
+	tests := []struct {
+		name string
+		file models.CameraFileST
+		err  error
+	}{
+		{"relative", models.CameraFileST{Path: "loop.mp4"}, nil},
+		{"absolute", models.CameraFileST{Path: filepath.Join(media, "loop.mp4")}, nil},
+		{"outside", models.CameraFileST{Path: outside}, ErrorCameraFilePath},
+		{"parent", models.CameraFileST{Path: "clips/../loop.mp4"}, ErrorCameraFilePath},
+		{"escape", models.CameraFileST{Path: "../outside.mp4"}, ErrorCameraFilePath},
+		{"symlink", models.CameraFileST{Path: "link.mp4"}, ErrorCameraFilePath},
+		{"folder", models.CameraFileST{Path: "clips"}, ErrorCameraFilePath},
+		{"missing", models.CameraFileST{Path: "missing.mp4"}, os.ErrNotExist},
+		{"recordings", models.CameraFileST{CameraId: camera.Id, Start: &start, End: &end}, nil},
+		{"unknown camera", models.CameraFileST{CameraId: "missing", Start: &start, End: &end}, os.ErrNotExist},
+		{"camera path", models.CameraFileST{CameraId: "../" + camera.Id, Start: &start, End: &end}, ErrorCameraInvalidFile},
+		{"empty range", models.CameraFileST{CameraId: camera.Id, Start: &end, End: &start}, ErrorCameraInvalidFile},
+		{"path and camera", models.CameraFileST{Path: "loop.mp4", CameraId: camera.Id}, ErrorCameraInvalidFile},
+	}
+	for _, test := range tests {
+		file := test.file
+		err := validateCameraFile(&file)
+		if test.err == nil && err != nil || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestValidateCameraSourceOnDemand(t *testing.T) {
+	setupTestCameras(t)
+	tests := []struct {
+		source string
+		err    error
+	}{
+		{models.CameraSourceRTSP, nil},
+		{models.CameraSourceRTMP, ErrorCameraOnDemandSource},
+		{models.CameraSourceWHIP, ErrorCameraOnDemandSource},
+	}
+	for _, test := range tests {
+		camera := models.CameraST{Source: test.source, RtspUrl: "rtsp://127.0.0.1/stream", OnDemand: true}
+		if err := validateCameraSource(&camera); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.source, test.err, err)
+		}
+	}
+}
+
+func TestValidateCameraRtspOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options models.CameraRtspOptionsST
+		err     error
+	}{
+		{"tcp", models.CameraRtspOptionsST{Transport: models.RtspTransportTCP}, nil},
+		{"udp", models.CameraRtspOptionsST{Transport: models.RtspTransportUDP}, ErrorCameraRtspUDP},
+		{"unknown transport", models.CameraRtspOptionsST{Transport: "http"}, ErrorCameraRtspTransport},
+		{"invalid ca", models.CameraRtspOptionsST{CACert: "not a certificate"}, ErrorCameraRtspCACert},
+		{"negative timeout", models.CameraRtspOptionsST{IOTimeoutSeconds: -1}, ErrorCameraRtspTimeout},
+	}
+	for _, test := range tests {
+		options := test.options
+		if err := validateCameraRtspOptions(&options); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestCameraStreamKey(t *testing.T) {
+	setupTestCameras(t)
+	streamKeys = make(map[string]string)
+
+	const creates = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, creates)
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := CreateCamera(&CameraCreateST{Source: models.CameraSourceRTMP, StreamKey: "shared", Disabled: true})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if err != ErrorCameraStreamKeyInUse {
+			t.Fatalf("expected %v, got %v", ErrorCameraStreamKeyInUse, err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected one camera with the shared key, got %d", created)
+	}
+	camera, err := GetCameraByStreamKey("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "moved"
+	if _, err := UpdateCamera(camera.Id, &CameraUpdateST{StreamKey: &key}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		streamKey string
+		err       error
+	}{
+		{"old key", "shared", ErrorCameraStreamKeyNotFound},
+		{"new key", "moved", nil},
+		{"empty key", "", ErrorCameraStreamKeyNotFound},
+	}
+	for _, test := range tests {
+		if _, err := GetCameraByStreamKey(test.streamKey); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := DeleteCamera(camera.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetCameraByStreamKey("moved"); err != ErrorCameraStreamKeyNotFound {
+		t.Errorf("deleted: expected %v, got %v", ErrorCameraStreamKeyNotFound, err)
+	}
+	if _, err := CreateCamera(&CameraCreateST{Source: models.CameraSourceWHIP, StreamKey: "moved", Disabled: true}); err != nil {
+		t.Errorf("expected the key of a deleted camera to be free, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/onvif"
+)
+
+var (
+	ErrorDiscoverAddress = errors.New("discover address must be a multicast or link local ip:port")
+)
+
+type CameraCandidateST struct {
+	Device   models.OnvifDeviceST `json:"device" validate:"required"`
+	Camera   *CameraCreateST      `json:"camera,omitempty"`
+	CameraId string               `json:"camera_id,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+var profileNameRegex = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// discoverAddressAllowed keeps probes on the LAN, any other address would
+// let API callers send UDP to arbitrary hosts.
+var discoverAddressAllowed = func(ip net.IP) bool {
+	return ip.IsMulticast() || ip.IsLinkLocalUnicast()
+}
+
+func validateDiscoverAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrorDiscoverAddress
+	}
+	if ip := net.ParseIP(host); ip == nil || !discoverAddressAllowed(ip) {
+		return ErrorDiscoverAddress
+	}
+	return nil
+}
+
+// DiscoverCameras probes the LAN for ONVIF devices and reads their media
+// profiles, every device with profiles has a camera ready to create.
+// Devices that already have a camera are not created again.
+func DiscoverCameras(body *models.DiscoverBodyST) ([]CameraCandidateST, error) {
+	if err := validateDiscoverAddress(body.Address); err != nil {
+		return nil, err
+	}
+	timeoutMS := body.TimeoutMS
+	if timeoutMS <= 0 {
+		timeoutMS = config.Config.Onvif.Discover.TimeoutMS
+	}
+	matches, err := onvif.Probe(body.Address, time.Duration(timeoutMS)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]CameraCandidateST, len(matches))
+	var wg sync.WaitGroup
+	for i := range matches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			candidates[i] = discoverCamera(&matches[i], body.Username, body.Password)
+		}(i)
+	}
+	wg.Wait()
+	if body.Create {
+		existing, err := existingCameraKeys()
+		if err != nil {
+			return nil, err
+		}
+		for i := range candidates {
+			if candidates[i].Camera == nil {
+				continue
+			}
+			keys := candidateKeys(candidates[i].Camera)
+			if id := existingCameraId(existing, keys); id != "" {
+				candidates[i].CameraId = id
+				continue
+			}
+			camera, err := CreateCamera(candidates[i].Camera)
+			if err != nil {
+				candidates[i].Error = err.Error()
+				continue
+			}
+			candidates[i].CameraId = camera.Id
+			for _, key := range keys {
+				existing[key] = camera.Id
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// existingCameraKeys maps the onvif xaddr and rtsp urls of every camera to
+// its id, urls without credentials so discovered ones match.
+func existingCameraKeys() (map[string]string, error) {
+	cameras, err := ListCameras()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]string)
+	for _, camera := range cameras {
+		if camera.Onvif != nil && camera.Onvif.XAddr != "" {
+			existing[camera.Onvif.XAddr] = camera.Id
+		}
+		if camera.RtspUrl != "" {
+			existing[withoutCredentials(camera.RtspUrl)] = camera.Id
+		}
+	}
+	return existing, nil
+}
+
+func candidateKeys(camera *CameraCreateST) []string {
+	var keys []string
+	if camera.Onvif != nil && camera.Onvif.XAddr != "" {
+		keys = append(keys, camera.Onvif.XAddr)
+	}
+	if camera.RtspUrl != "" {
+		keys = append(keys, withoutCredentials(camera.RtspUrl))
+	}
+	return keys
+}
+
+func existingCameraId(existing map[string]string, keys []string) string {
+	for _, key := range keys {
+		if id, ok := existing[key]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func discoverCamera(match *onvif.ProbeMatchST, username, password string) CameraCandidateST {
+	candidate := CameraCandidateST{
+		Device: models.OnvifDeviceST{
+			Address:  match.Address,
+			XAddr:    match.XAddrs[0],
+			Name:     match.Name,
+			Hardware: match.Hardware,
+			Profiles: []models.OnvifProfileST{},
+		},
+	}
+	var err error
+	for _, xaddr := range match.XAddrs {
+		candidate.Device.XAddr = xaddr
+		if err = readOnvifDevice(&candidate.Device, username, password); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("%s: ONVIF discovery failed %s\n", candidate.Device.XAddr, err)
+		candidate.Error = err.Error()
+		return candidate
+	}
+	candidate.Camera = cameraFromOnvifDevice(&candidate.Device, username, password)
+	return candidate
+}
+
+func readOnvifDevice(device *models.OnvifDeviceST, username, password string) error {
+	client := newOnvifClient(device.XAddr, username, password)
+	capabilities, err := client.GetCapabilities()
+	if err != nil {
+		return err
+	}
+	if information, err := client.GetDeviceInformation(); err == nil {
+		device.Manufacturer = information.Manufacturer
+		device.Model = information.Model
+		device.SerialNumber = information.SerialNumber
+	}
+	mediaXAddr := capabilities.Media.XAddr
+	if mediaXAddr == "" {
+		mediaXAddr = device.XAddr
+	}
+	profiles, err := client.GetProfiles(mediaXAddr)
+	if err != nil {
+		return err
+	}
+	device.Profiles = device.Profiles[:0]
+	for _, profile := range profiles {
+		streamUri, err := client.GetStreamUri(mediaXAddr, profile.Token)
+		if err != nil {
+			log.Printf("%s: ONVIF stream uri failed for %s %s\n", device.XAddr, profile.Token, err)
+			continue
+		}
+		snapshotUri, _ := client.GetSnapshotUri(mediaXAddr, profile.Token)
+		encoder := profile.VideoEncoderConfiguration
+		device.Profiles = append(device.Profiles, models.OnvifProfileST{
+			Token:       profile.Token,
+			Name:        profile.Name,
+			Encoding:    encoder.Encoding,
+			Width:       encoder.Resolution.Width,
+			Height:      encoder.Resolution.Height,
+			FrameRate:   encoder.RateControl.FrameRateLimit,
+			RtspUrl:     streamUri,
+			SnapshotUrl: snapshotUri,
+		})
+	}
+	return nil
+}
+
+func withCredentials(rawUrl, username, password string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || username == "" || u.User != nil {
+		return rawUrl
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String()
+}
+
+func withoutCredentials(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	u.User = nil
+	return u.String()
+}
+
+func cameraFromOnvifDevice(device *models.OnvifDeviceST, username, password string) *CameraCreateST {
+	if len(device.Profiles) == 0 {
+		return nil
+	}
+	name := device.Name
+	if name == "" {
+		name = strings.TrimSpace(device.Manufacturer + " " + device.Model)
+	}
+	if name == "" {
+		name = device.XAddr
+	}
+	main := device.Profiles[0]
+	camera := &CameraCreateST{
+		Name:    name,
+		Url:     withCredentials(main.SnapshotUrl, username, password),
+		RtspUrl: withCredentials(main.RtspUrl, username, password),
+		Source:  models.CameraSourceRTSP,
+		Onvif: &models.CameraOnvifST{
+			XAddr:    device.XAddr,
+			Username: username,
+			Password: password,
+		},
+	}
+	names := map[string]bool{models.CameraProfileMain: true}
+	for i, profile := range device.Profiles[1:] {
+		profileName := strings.Trim(profileNameRegex.ReplaceAllString(strings.ToLower(profile.Name), "_"), "_")
+		if profileName == "" || names[profileName] {
+			profileName = fmt.Sprintf("profile%d", i+2)
+		}
+		names[profileName] = true
+		camera.Profiles = append(camera.Profiles, models.CameraProfileST{
+			Name:    profileName,
+			RtspUrl: withCredentials(profile.RtspUrl, username, password),
+		})
+	}
+	return camera
+}
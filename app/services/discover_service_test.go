@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/onvif/onviftest"
+)
+
+func setupTestCameras(t *testing.T) {
+	t.Helper()
+	config.Config.Cameras.Folder = t.TempDir()
+	config.Config.Onvif.Timeout.Seconds = 2
+	config.Config.Onvif.Discover.TimeoutMS = 300
+}
+
+// allowLoopbackDiscovery lets probes reach a responder on loopback.
+func allowLoopbackDiscovery(t *testing.T) {
+	t.Helper()
+	allowed := discoverAddressAllowed
+	discoverAddressAllowed = func(ip net.IP) bool {
+		return ip.IsLoopback() || allowed(ip)
+	}
+	t.Cleanup(func() {
+		discoverAddressAllowed = allowed
+	})
+}
+
+func TestDiscoverCameras(t *testing.T) {
+	setupTestCameras(t)
+	allowLoopbackDiscovery(t)
+	device := onviftest.NewDevice()
+	defer device.Close()
+	responder, err := onviftest.NewResponder(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+
+	body := &models.DiscoverBodyST{
+		Address:  responder.Addr(),
+		Username: "admin",
+		Password: "secret",
+		Create:   true,
+	}
+	candidates, err := DiscoverCameras(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 {
+		t.Fatalf("expected 1 candidate, got %d", len(candidates))
+	}
+	candidate := candidates[0]
+	if candidate.Error != "" || candidate.Camera == nil || candidate.CameraId == "" {
+		t.Fatalf("candidate was not created %+v", candidate)
+	}
+	if candidate.Device.Name != "Fake Camera 0" || candidate.Device.Manufacturer != "Fake" || len(candidate.Device.Profiles) != 2 {
+		t.Fatalf("unexpected device %+v", candidate.Device)
+	}
+	if candidate.Device.Profiles[0].RtspUrl != device.RtspUrl("profile_1") || candidate.Device.Profiles[0].Width != 1920 {
+		t.Fatalf("unexpected main profile %+v", candidate.Device.Profiles[0])
+	}
+	camera, err := GetCamera(candidate.CameraId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if camera.RtspUrl != withCredentials(device.RtspUrl("profile_1"), "admin", "secret") {
+		t.Fatalf("unexpected rtsp url %s", camera.RtspUrl)
+	}
+	if len(camera.Profiles) != 1 || camera.Profiles[0].Name != "sub" {
+		t.Fatalf("unexpected profiles %+v", camera.Profiles)
+	}
+	if camera.Onvif == nil || camera.Onvif.XAddr != device.XAddr() {
+		t.Fatalf("unexpected onvif %+v", camera.Onvif)
+	}
+
+	// discovering again finds the camera that was created
+	candidates, err = DiscoverCameras(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].CameraId != camera.Id {
+		t.Fatalf("expected the existing camera %s, got %+v", camera.Id, candidates)
+	}
+	cameras, err := ListCameras()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cameras) != 1 {
+		t.Fatalf("expected 1 camera, got %d", len(cameras))
+	}
+}
+
+func TestDiscoverCamerasAddress(t *testing.T) {
+	setupTestCameras(t)
+	for _, address := range []string{"8.8.8.8:3702", "127.0.0.1:3702", "example.com:3702", "239.255.255.250"} {
+		if _, err := DiscoverCameras(&models.DiscoverBodyST{Address: address}); !errors.Is(err, ErrorDiscoverAddress) {
+			t.Errorf("%s: expected ErrorDiscoverAddress, got %v", address, err)
+		}
+	}
+	if err := validateDiscoverAddress("239.255.255.250:3702"); err != nil {
+		t.Errorf("multicast address rejected %s", err)
+	}
+	if err := validateDiscoverAddress("169.254.10.20:3702"); err != nil {
+		t.Errorf("link local address rejected %s", err)
+	}
+}
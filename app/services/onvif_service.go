@@ -0,0 +1,275 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/onvif"
+)
+
+var (
+	ErrorCameraNoPTZ          = errors.New("camera has no onvif PTZ")
+	ErrorPTZInvalidAction     = errors.New("invalid PTZ action")
+	ErrorPTZActionUnsupported = errors.New("PTZ action is not supported by the camera")
+	ErrorPTZMissingValue      = errors.New("PTZ action needs pan, tilt or zoom")
+	ErrorPTZMissingPreset     = errors.New("PTZ goto_preset needs a preset_token")
+)
+
+// onvifTimeSyncInterval is how long the clock offset of a device is used
+// before it is read again.
+const onvifTimeSyncInterval = time.Hour
+
+func newOnvifClient(xaddr, username, password string) *onvif.Client {
+	client := onvif.NewClient(xaddr, username, password, time.Duration(config.Config.Onvif.Timeout.Seconds)*time.Second)
+	if err := client.SyncTime(); err != nil {
+		log.Printf("%s: ONVIF time sync failed %s\n", xaddr, err)
+	}
+	return client
+}
+
+// onvifCameraST is the client of a camera's device, it is kept between PTZ
+// commands so the clock offset is not read on every one.
+type onvifCameraST struct {
+	mutex    sync.Mutex
+	config   models.CameraOnvifST
+	client   *onvif.Client
+	syncedTs time.Time
+	// resolved is read from the device when the saved config has unknown
+	// PTZ capabilities
+	resolved *models.CameraOnvifST
+}
+
+var (
+	onvifCamerasMutex sync.Mutex
+	onvifCameras      = make(map[string]*onvifCameraST)
+)
+
+// getOnvifCamera returns the client of the camera, a new one when the
+// device or credentials changed.
+func getOnvifCamera(id string, cameraOnvif *models.CameraOnvifST) *onvifCameraST {
+	onvifConfig := models.CameraOnvifST{
+		XAddr:        cameraOnvif.XAddr,
+		Username:     cameraOnvif.Username,
+		Password:     cameraOnvif.Password,
+		ProfileToken: cameraOnvif.ProfileToken,
+	}
+	onvifCamerasMutex.Lock()
+	defer onvifCamerasMutex.Unlock()
+	onvifCamera, ok := onvifCameras[id]
+	if !ok || onvifCamera.config != onvifConfig {
+		onvifCamera = &onvifCameraST{
+			config: onvifConfig,
+			client: onvif.NewClient(onvifConfig.XAddr, onvifConfig.Username, onvifConfig.Password, time.Duration(config.Config.Onvif.Timeout.Seconds)*time.Second),
+		}
+		onvifCameras[id] = onvifCamera
+	}
+	return onvifCamera
+}
+
+func removeOnvifCamera(id string) {
+	onvifCamerasMutex.Lock()
+	defer onvifCamerasMutex.Unlock()
+	delete(onvifCameras, id)
+}
+
+// syncedClient returns the client once its clock offset is at most
+// onvifTimeSyncInterval old, a failed sync is retried on the next command.
+func (onvifCamera *onvifCameraST) syncedClient() *onvif.Client {
+	onvifCamera.mutex.Lock()
+	defer onvifCamera.mutex.Unlock()
+	if time.Since(onvifCamera.syncedTs) > onvifTimeSyncInterval {
+		if err := onvifCamera.client.SyncTime(); err != nil {
+			log.Printf("%s: ONVIF time sync failed %s\n", onvifCamera.config.XAddr, err)
+		} else {
+			onvifCamera.syncedTs = time.Now()
+		}
+	}
+	return onvifCamera.client
+}
+
+// resolve reads the PTZ service and capabilities the first time they are
+// needed.
+func (onvifCamera *onvifCameraST) resolve() (*models.CameraOnvifST, error) {
+	client := onvifCamera.syncedClient()
+	onvifCamera.mutex.Lock()
+	defer onvifCamera.mutex.Unlock()
+	if onvifCamera.resolved == nil {
+		resolved, err := readCameraOnvif(client, &onvifCamera.config)
+		if err != nil {
+			return nil, err
+		}
+		onvifCamera.resolved = resolved
+	}
+	return onvifCamera.resolved, nil
+}
+
+// resolveCameraOnvif reads the PTZ service and capabilities of the device,
+// an empty xaddr removes the onvif config. A device that can not be reached
+// does not stop the camera from being saved, its PTZ capabilities are left
+// unknown and read on the first PTZ command.
+func resolveCameraOnvif(onvifConfig *models.CameraOnvifST) *models.CameraOnvifST {
+	if onvifConfig == nil || onvifConfig.XAddr == "" {
+		return nil
+	}
+	client := newOnvifClient(onvifConfig.XAddr, onvifConfig.Username, onvifConfig.Password)
+	resolved, err := readCameraOnvif(client, onvifConfig)
+	if err != nil {
+		log.Printf("%s: ONVIF device unavailable, PTZ capabilities unknown %s\n", onvifConfig.XAddr, err)
+		return &models.CameraOnvifST{
+			XAddr:        onvifConfig.XAddr,
+			Username:     onvifConfig.Username,
+			Password:     onvifConfig.Password,
+			ProfileToken: onvifConfig.ProfileToken,
+		}
+	}
+	return resolved
+}
+
+// readCameraOnvif asks the device for its services, PTZ is empty when the
+// device has no PTZ service.
+func readCameraOnvif(client *onvif.Client, onvifConfig *models.CameraOnvifST) (*models.CameraOnvifST, error) {
+	resolved := models.CameraOnvifST{
+		XAddr:        onvifConfig.XAddr,
+		Username:     onvifConfig.Username,
+		Password:     onvifConfig.Password,
+		ProfileToken: onvifConfig.ProfileToken,
+	}
+	capabilities, err := client.GetCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	mediaXAddr := capabilities.Media.XAddr
+	if mediaXAddr == "" {
+		mediaXAddr = resolved.XAddr
+	}
+	profiles, err := client.GetProfiles(mediaXAddr)
+	if err != nil {
+		return nil, err
+	}
+	if resolved.ProfileToken == "" {
+		for _, profile := range profiles {
+			if profile.PTZConfiguration.Token != "" {
+				resolved.ProfileToken = profile.Token
+				break
+			}
+		}
+	}
+	if resolved.ProfileToken == "" && len(profiles) > 0 {
+		resolved.ProfileToken = profiles[0].Token
+	}
+	resolved.PTZXAddr = capabilities.PTZ.XAddr
+	ptz := &models.PTZCapabilitiesST{}
+	resolved.PTZ = ptz
+	if resolved.PTZXAddr == "" {
+		return &resolved, nil
+	}
+	nodes, err := client.GetNodes(resolved.PTZXAddr)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		spaces := node.SupportedPTZSpaces
+		ptz.ContinuousMove = ptz.ContinuousMove || len(spaces.ContinuousPanTiltVelocitySpace) > 0
+		ptz.RelativeMove = ptz.RelativeMove || len(spaces.RelativePanTiltTranslationSpace) > 0 || len(spaces.RelativeZoomTranslationSpace) > 0
+		ptz.AbsoluteMove = ptz.AbsoluteMove || len(spaces.AbsolutePanTiltPositionSpace) > 0 || len(spaces.AbsoluteZoomPositionSpace) > 0
+		ptz.Zoom = ptz.Zoom || len(spaces.ContinuousZoomVelocitySpace) > 0
+		ptz.Presets = ptz.Presets || node.MaximumNumberOfPresets > 0
+	}
+	return &resolved, nil
+}
+
+func ptzSupported(ptz *models.PTZCapabilitiesST, action string) (bool, error) {
+	switch action {
+	case models.PTZActionContinuousMove:
+		return ptz.ContinuousMove, nil
+	case models.PTZActionRelativeMove:
+		return ptz.RelativeMove, nil
+	case models.PTZActionAbsoluteMove:
+		return ptz.AbsoluteMove, nil
+	case models.PTZActionZoom:
+		return ptz.Zoom, nil
+	case models.PTZActionGetPresets, models.PTZActionGotoPreset:
+		return ptz.Presets, nil
+	case models.PTZActionStop:
+		return true, nil
+	default:
+		return false, ErrorPTZInvalidAction
+	}
+}
+
+func CameraPTZ(id string, body *models.PTZBodyST) (*models.PTZResponseST, error) {
+	camera, err := GetCamera(id)
+	if err != nil {
+		return nil, err
+	}
+	if camera.Onvif == nil {
+		return nil, ErrorCameraNoPTZ
+	}
+	onvifCamera := getOnvifCamera(id, camera.Onvif)
+	cameraOnvif := camera.Onvif
+	if cameraOnvif.PTZ == nil {
+		if cameraOnvif, err = onvifCamera.resolve(); err != nil {
+			return nil, err
+		}
+	}
+	if cameraOnvif.PTZXAddr == "" {
+		return nil, ErrorCameraNoPTZ
+	}
+	if supported, err := ptzSupported(cameraOnvif.PTZ, body.Action); err != nil {
+		return nil, err
+	} else if !supported {
+		return nil, ErrorPTZActionUnsupported
+	}
+	vector := &onvif.VectorST{Pan: body.Pan, Tilt: body.Tilt, Zoom: body.Zoom}
+	hasValue := body.Pan != nil || body.Tilt != nil || body.Zoom != nil
+	client := onvifCamera.syncedClient()
+	xaddr, token := cameraOnvif.PTZXAddr, cameraOnvif.ProfileToken
+	response := &models.PTZResponseST{Action: body.Action}
+	switch body.Action {
+	case models.PTZActionContinuousMove, models.PTZActionZoom:
+		if body.Action == models.PTZActionZoom {
+			vector = &onvif.VectorST{Zoom: body.Zoom}
+			hasValue = body.Zoom != nil
+		}
+		if !hasValue {
+			return nil, ErrorPTZMissingValue
+		}
+		err = client.ContinuousMove(xaddr, token, vector, time.Duration(body.TimeoutMS)*time.Millisecond)
+	case models.PTZActionRelativeMove:
+		if !hasValue {
+			return nil, ErrorPTZMissingValue
+		}
+		err = client.RelativeMove(xaddr, token, vector, body.Speed)
+	case models.PTZActionAbsoluteMove:
+		if !hasValue {
+			return nil, ErrorPTZMissingValue
+		}
+		err = client.AbsoluteMove(xaddr, token, vector, body.Speed)
+	case models.PTZActionStop:
+		err = client.Stop(xaddr, token)
+	case models.PTZActionGetPresets:
+		var presets []onvif.PTZPresetST
+		if presets, err = client.GetPresets(xaddr, token); err == nil {
+			response.Presets = make([]models.PTZPresetST, len(presets))
+			for i, preset := range presets {
+				response.Presets[i] = models.PTZPresetST{
+					Token: preset.Token,
+					Name:  preset.Name,
+				}
+			}
+		}
+	case models.PTZActionGotoPreset:
+		if body.PresetToken == "" {
+			return nil, ErrorPTZMissingPreset
+		}
+		err = client.GotoPreset(xaddr, token, body.PresetToken, body.Speed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/aicacia/streams/app/onvif/onviftest"
+)
+
+// TestCameraOnvifPassword checks the onvif password is kept on updates that
+// leave it out and is never returned by the API.
+func TestCameraOnvifPassword(t *testing.T) {
+	setupTestCameras(t)
+	device := onviftest.NewDevice()
+	defer device.Close()
+
+	camera, err := CreateCamera(&CameraCreateST{
+		RtspUrl:  device.RtspUrl("profile_1"),
+		Disabled: true,
+		Onvif: &models.CameraOnvifST{
+			XAddr:    device.XAddr(),
+			Username: "admin",
+			Password: "secret",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if camera.Onvif.ProfileToken != "profile_1" || camera.Onvif.PTZXAddr != device.PTZXAddr() || camera.Onvif.PTZ == nil || !camera.Onvif.PTZ.ContinuousMove {
+		t.Fatalf("onvif was not resolved %+v", camera.Onvif)
+	}
+	redacted := camera.Redacted()
+	if redacted.Onvif.Password != "" || camera.Onvif.Password != "secret" {
+		t.Fatal("password was not redacted from a copy")
+	}
+
+	tests := []struct {
+		name     string
+		onvif    models.CameraOnvifST
+		password string
+	}{
+		{"same device", *redacted.Onvif, "secret"},
+		{"new password", models.CameraOnvifST{XAddr: device.XAddr(), Username: "admin", Password: "changed"}, "changed"},
+		{"new username", models.CameraOnvifST{XAddr: device.XAddr(), Username: "operator"}, ""},
+	}
+	for _, test := range tests {
+		onvifConfig := test.onvif
+		if _, err := UpdateCamera(camera.Id, &CameraUpdateST{Onvif: &onvifConfig}); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		stored, err := GetCamera(camera.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Onvif.Password != test.password {
+			t.Errorf("%s: expected password %q, got %q", test.name, test.password, stored.Onvif.Password)
+		}
+	}
+}
+
+// TestCameraPTZOffline checks a camera saves while its device is offline and
+// the PTZ capabilities are read on the first command.
+func TestCameraPTZOffline(t *testing.T) {
+	setupTestCameras(t)
+	offline := onviftest.NewDevice()
+	offline.Close()
+
+	camera, err := CreateCamera(&CameraCreateST{
+		RtspUrl:  "rtsp://127.0.0.1/offline",
+		Disabled: true,
+		Onvif:    &models.CameraOnvifST{XAddr: offline.XAddr()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if camera.Onvif == nil || camera.Onvif.PTZ != nil {
+		t.Fatalf("expected unknown PTZ capabilities %+v", camera.Onvif)
+	}
+	if _, err := CameraPTZ(camera.Id, &models.PTZBodyST{Action: models.PTZActionStop}); err == nil {
+		t.Fatal("expected PTZ to fail while the device is offline")
+	}
+
+	// the device comes online at a new address with the config unresolved
+	device := onviftest.NewDevice()
+	defer device.Close()
+	camera.Onvif = &models.CameraOnvifST{XAddr: device.XAddr(), Username: "admin", Password: "secret"}
+	if err := writeCamera(cameraPath(camera.Id), camera); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := CameraPTZ(camera.Id, &models.PTZBodyST{Action: models.PTZActionStop}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := device.Count("Stop"); count != 3 {
+		t.Fatalf("expected 3 stops, got %d", count)
+	}
+	if count := device.Count("GetSystemDateAndTime"); count != 1 {
+		t.Fatalf("expected the clock offset to be read once, got %d", count)
+	}
+	if count := device.Count("GetNodes"); count != 1 {
+		t.Fatalf("expected the PTZ capabilities to be read once, got %d", count)
+	}
+
+	if _, err := CameraPTZ("missing", &models.PTZBodyST{Action: models.PTZActionStop}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a missing camera, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/util"
+)
+
+var (
+	ErrorCameraNoSnapshotUrl = errors.New("camera has no snapshot url")
+	ErrorSnapshotNotImage    = errors.New("camera snapshot url did not return an image")
+	ErrorSnapshotStatus      = errors.New("camera snapshot url returned")
+)
+
+const snapshotMaxBytes = 16 << 20
+
+type SnapshotST struct {
+	ContentType string
+	Body        []byte
+	Ts          time.Time
+}
+
+type snapshotCacheST struct {
+	mutex    sync.Mutex
+	url      string
+	snapshot *SnapshotST
+}
+
+var snapshotsMutex sync.Mutex
+var snapshots = make(map[string]*snapshotCacheST)
+
+// GetCameraSnapshot returns the camera's snapshot image, fetched from the
+// camera url with any credentials in it and cached for a short time.
+func GetCameraSnapshot(id string) (*SnapshotST, error) {
+	camera, err := GetCamera(id)
+	if err != nil {
+		return nil, err
+	}
+	if camera.Url == "" {
+		return nil, ErrorCameraNoSnapshotUrl
+	}
+	snapshotsMutex.Lock()
+	cache, ok := snapshots[id]
+	if !ok {
+		cache = &snapshotCacheST{}
+		snapshots[id] = cache
+	}
+	snapshotsMutex.Unlock()
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	maxAge := time.Duration(config.Config.Snapshot.Cache.MS) * time.Millisecond
+	if cache.snapshot != nil && cache.url == camera.Url && time.Since(cache.snapshot.Ts) < maxAge {
+		return cache.snapshot, nil
+	}
+	snapshot, err := fetchSnapshot(camera.Url)
+	if err != nil {
+		return nil, err
+	}
+	cache.url = camera.Url
+	cache.snapshot = snapshot
+	return snapshot, nil
+}
+
+func deleteSnapshot(id string) {
+	snapshotsMutex.Lock()
+	defer snapshotsMutex.Unlock()
+	delete(snapshots, id)
+}
+
+func fetchSnapshot(rawUrl string) (*SnapshotST, error) {
+	snapshotUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	username := snapshotUrl.User.Username()
+	password, _ := snapshotUrl.User.Password()
+	snapshotUrl.User = nil
+
+	client := util.NewInsecureClient()
+	client.Timeout = time.Duration(config.Config.Snapshot.Timeout.Seconds) * time.Second
+	resp, err := snapshotRequest(&client, snapshotUrl, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && username != "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authorization, err := util.Authorization(challenge, http.MethodGet, snapshotUrl.RequestURI(), username, password)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = snapshotRequest(&client, snapshotUrl, authorization); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w %s", ErrorSnapshotStatus, resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return nil, ErrorSnapshotNotImage
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, snapshotMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+		if !strings.HasPrefix(contentType, "image/") {
+			return nil, ErrorSnapshotNotImage
+		}
+	}
+	return &SnapshotST{
+		ContentType: contentType,
+		Body:        body,
+		Ts:          time.Now().UTC(),
+	}, nil
+}
+
+func snapshotRequest(client *http.Client, snapshotUrl *url.URL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, snapshotUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return client.Do(req)
+}
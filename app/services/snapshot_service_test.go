@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/aicacia/streams/app/util"
+)
+
+var testSnapshotImage = []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00}
+
+// newSnapshotServer serves an image to admin:secret after the challenge,
+// requests counts every request it gets.
+func newSnapshotServer(t *testing.T, challenge string, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if challenge != "" && !snapshotAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testSnapshotImage)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func snapshotAuthorized(r *http.Request) bool {
+	if username, password, ok := r.BasicAuth(); ok {
+		return username == "admin" && password == "secret"
+	}
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != "Digest" {
+		return false
+	}
+	digest := util.ParseAuthParams(params)
+	newHash := md5.New
+	if digest["algorithm"] == "SHA-256" {
+		newHash = sha256.New
+	}
+	hashHex := func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	ha1 := hashHex("admin:cam:secret")
+	ha2 := hashHex(r.Method + ":" + r.URL.RequestURI())
+	response := hashHex(ha1 + ":nonce:" + ha2)
+	if digest["qop"] == "auth" {
+		response = hashHex(ha1 + ":nonce:" + digest["nc"] + ":" + digest["cnonce"] + ":auth:" + ha2)
+	}
+	return digest["username"] == "admin" && digest["uri"] == r.URL.RequestURI() && digest["opaque"] == "opaque" && digest["response"] == response
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	config.Config.Snapshot.Timeout.Seconds = 5
+	tests := []struct {
+		name      string
+		challenge string
+		userinfo  string
+		err       error
+	}{
+		{"no auth", "", "", nil},
+		{"basic", `Basic realm="cam"`, "admin:secret@", nil},
+		{"digest", `Digest realm="cam", nonce="nonce", opaque="opaque"`, "admin:secret@", nil},
+		{"digest qop", `Digest realm="cam", qop="auth,auth-int", nonce="nonce", opaque="opaque", algorithm=MD5`, "admin:secret@", nil},
+		{"digest sha-256", `Digest realm="cam", qop="auth", nonce="nonce", opaque="opaque", algorithm=SHA-256`, "admin:secret@", nil},
+		{"wrong password", `Digest realm="cam", nonce="nonce", opaque="opaque"`, "admin:wrong@", ErrorSnapshotStatus},
+		{"no credentials", `Digest realm="cam", nonce="nonce", opaque="opaque"`, "", ErrorSnapshotStatus},
+		{"unknown scheme", `Bearer realm="cam"`, "admin:secret@", util.ErrorAuthNotSupported},
+		{"unknown qop", `Digest realm="cam", qop="auth-int", nonce="nonce"`, "admin:secret@", util.ErrorAuthNotSupported},
+	}
+	for _, test := range tests {
+		var requests atomic.Int32
+		server := newSnapshotServer(t, test.challenge, &requests)
+		snapshot, err := fetchSnapshot(strings.Replace(server.URL, "://", "://"+test.userinfo, 1) + "/snapshot.jpg?channel=1")
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if snapshot.ContentType != "image/jpeg" || string(snapshot.Body) != string(testSnapshotImage) {
+			t.Errorf("%s: expected the image, got %s", test.name, snapshot.ContentType)
+		}
+	}
+}
+
+func TestGetCameraSnapshotCache(t *testing.T) {
+	setupTestCameras(t)
+	config.Config.Snapshot.Timeout.Seconds = 5
+	config.Config.Snapshot.Cache.MS = 200
+	var requests atomic.Int32
+	server := newSnapshotServer(t, `Digest realm="cam", qop="auth", nonce="nonce", opaque="opaque"`, &requests)
+	url := strings.Replace(server.URL, "://", "://admin:secret@", 1) + "/snapshot.jpg"
+	camera, err := CreateCamera(&CameraCreateST{Url: url, RtspUrl: "rtsp://127.0.0.1/stream", Disabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		wait     time.Duration
+		url      string
+		requests int32
+	}{
+		{"first fetch challenged", 0, "", 2},
+		{"cache hit", 0, "", 2},
+		{"cache expired", 250 * time.Millisecond, "", 4},
+		{"cache hit again", 0, "", 4},
+		{"url changed", 0, url + "?channel=2", 6},
+	}
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		if step.url != "" {
+			if _, err := UpdateCamera(camera.Id, &CameraUpdateST{Url: &step.url}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		snapshot, err := GetCameraSnapshot(camera.Id)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if string(snapshot.Body) != string(testSnapshotImage) {
+			t.Errorf("%s: expected the image", step.name)
+		}
+		if got := requests.Load(); got != step.requests {
+			t.Errorf("%s: expected %d requests, got %d", step.name, step.requests, got)
+		}
+	}
+}
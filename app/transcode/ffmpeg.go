@@ -0,0 +1,227 @@
+package transcode
+
+import (
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/aacparser"
+)
+
+const transcoderChanSize = 256
+const pcmaFrameSize = 160
+const pcmaFrameDuration = 20 * time.Millisecond
+const opusFrameDuration = 20 * time.Millisecond
+
+func init() {
+	RegisterAudioTranscoder(av.AAC, newFFmpegAACTranscoder, ffmpegAvailable)
+}
+
+// the configured ffmpeg is looked up once, LookPath walks PATH on every call
+var (
+	ffmpegPathMutex sync.Mutex
+	ffmpegPathName  string
+	ffmpegPathValue string
+	ffmpegPathErr   error
+)
+
+func ffmpegPath() (string, error) {
+	ffmpegPathMutex.Lock()
+	defer ffmpegPathMutex.Unlock()
+	name := config.Config.Transcode.Audio.FFmpeg
+	if name != ffmpegPathName || (ffmpegPathValue == "" && ffmpegPathErr == nil) {
+		ffmpegPathName = name
+		ffmpegPathValue, ffmpegPathErr = exec.LookPath(name)
+		if ffmpegPathErr != nil {
+			log.Printf("ffmpeg %s not found, AAC audio will not be transcoded %s\n", name, ffmpegPathErr)
+		}
+	}
+	return ffmpegPathValue, ffmpegPathErr
+}
+
+func ffmpegAvailable() bool {
+	_, err := ffmpegPath()
+	return err == nil
+}
+
+// ffmpegTranscoder pipes ADTS frames through ffmpeg. mutex only guards the
+// state flags, pipe I/O happens outside of it so a full stdin can not block
+// the stdout reader or Close.
+type ffmpegTranscoder struct {
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+	input      aacparser.CodecData
+	output     av.AudioCodecData
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	packets    chan *av.Packet
+	// start is the time of the first packet written, the reader takes it
+	// before its first output
+	start   chan time.Duration
+	started bool
+	closed  bool
+	// time is owned by the stdout reader
+	time    time.Duration
+	hasTime bool
+}
+
+func ffmpegOutputArgs(output string) []string {
+	if output == "opus" {
+		return []string{
+			"-ac", "2", "-ar", "48000", "-c:a", "libopus",
+			"-frame_duration", "20", "-application", "lowdelay",
+			"-page_duration", "20000", "-flush_packets", "1",
+			"-f", "ogg", "pipe:1",
+		}
+	}
+	return []string{"-ac", "1", "-ar", "8000", "-c:a", "pcm_alaw", "-f", "alaw", "pipe:1"}
+}
+
+func newFFmpegAACTranscoder(input av.AudioCodecData) (AudioTranscoder, error) {
+	aacCodec, ok := input.(aacparser.CodecData)
+	if !ok {
+		return nil, ErrorTranscoderNotFound
+	}
+	path, err := ffmpegPath()
+	if err != nil {
+		return nil, err
+	}
+	output := strings.ToLower(config.Config.Transcode.Audio.Codec)
+	args := append([]string{
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-probesize", "32", "-analyzeduration", "0",
+		"-f", "adts", "-i", "pipe:0", "-vn",
+	}, ffmpegOutputArgs(output)...)
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := &ffmpegTranscoder{
+		input:   aacCodec,
+		cmd:     cmd,
+		stdin:   stdin,
+		packets: make(chan *av.Packet, transcoderChanSize),
+		start:   make(chan time.Duration, 1),
+	}
+	if output == "opus" {
+		t.output = codec.NewOpusCodecData(48000, av.CH_STEREO)
+		go t.readOpus(stdout)
+	} else {
+		t.output = codec.NewPCMAlawCodecData()
+		go t.readPCMA(stdout)
+	}
+	return t, nil
+}
+
+func (t *ffmpegTranscoder) Codec() av.AudioCodecData {
+	return t.output
+}
+
+func (t *ffmpegTranscoder) Packets() <-chan *av.Packet {
+	return t.packets
+}
+
+func (t *ffmpegTranscoder) WritePacket(pkt *av.Packet) error {
+	t.mutex.Lock()
+	closed, started := t.closed, t.started
+	t.started = true
+	t.mutex.Unlock()
+	if closed {
+		return ErrorTranscoderClosed
+	}
+	if !started {
+		t.start <- pkt.Time
+	}
+	frame := make([]byte, aacparser.ADTSHeaderLength+len(pkt.Data))
+	aacparser.FillADTSHeader(frame, t.input.Config, 1024, len(pkt.Data))
+	copy(frame[aacparser.ADTSHeaderLength:], pkt.Data)
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	_, err := t.stdin.Write(frame)
+	return err
+}
+
+func (t *ffmpegTranscoder) emit(data []byte, duration time.Duration) {
+	if !t.hasTime {
+		// ffmpeg only writes after it was given input so start was sent
+		t.time = <-t.start
+		t.hasTime = true
+	}
+	t.packets <- &av.Packet{
+		Idx:      0,
+		Time:     t.time,
+		Duration: duration,
+		Data:     data,
+	}
+	t.time += duration
+}
+
+func (t *ffmpegTranscoder) readPCMA(stdout io.Reader) {
+	defer close(t.packets)
+	for {
+		frame := make([]byte, pcmaFrameSize)
+		if _, err := io.ReadFull(stdout, frame); err != nil {
+			if err != io.EOF && !t.isClosed() {
+				log.Println("ffmpeg transcoder", err)
+			}
+			return
+		}
+		t.emit(frame, pcmaFrameDuration)
+	}
+}
+
+func (t *ffmpegTranscoder) readOpus(stdout io.Reader) {
+	defer close(t.packets)
+	reader := newOggReader(stdout)
+	headers := 0
+	for {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			if err != io.EOF && !t.isClosed() {
+				log.Println("ffmpeg transcoder", err)
+			}
+			return
+		}
+		// the first two packets are the OpusHead and OpusTags headers
+		if headers < 2 {
+			headers++
+			continue
+		}
+		t.emit(packet, opusFrameDuration)
+	}
+}
+
+func (t *ffmpegTranscoder) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.closed
+}
+
+func (t *ffmpegTranscoder) Close() error {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mutex.Unlock()
+	t.stdin.Close()
+	if t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	return t.cmd.Wait()
+}
@@ -0,0 +1,192 @@
+package transcode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+// stubFFmpeg points the transcoder at a script that never reads its input.
+func stubFFmpeg(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config.Config.Transcode.Audio.Enabled = true
+	config.Config.Transcode.Audio.FFmpeg = path
+	config.Config.Transcode.Audio.Codec = "pcma"
+}
+
+func testAACCodec(t *testing.T) aacparser.CodecData {
+	t.Helper()
+	input, err := aacparser.NewCodecDataFromMPEG4AudioConfig(aacparser.MPEG4AudioConfig{
+		ObjectType:      aacparser.AOT_AAC_LC,
+		SampleRate:      44100,
+		SampleRateIndex: 4,
+		ChannelLayout:   av.CH_MONO,
+		ChannelConfig:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input
+}
+
+func TestCanTranscodeAudio(t *testing.T) {
+	input := testAACCodec(t)
+	stubFFmpeg(t)
+	if !CanTranscodeAudio(input) {
+		t.Fatal("expected AAC to be transcoded with ffmpeg installed")
+	}
+	config.Config.Transcode.Audio.FFmpeg = filepath.Join(t.TempDir(), "missing")
+	if CanTranscodeAudio(input) {
+		t.Fatal("expected AAC not to be transcoded without ffmpeg")
+	}
+	if _, err := NewAudioTranscoder(input); err != ErrorTranscoderUnavailable {
+		t.Fatalf("expected unavailable, got %v", err)
+	}
+	stubFFmpeg(t)
+	config.Config.Transcode.Audio.Enabled = false
+	if CanTranscodeAudio(input) {
+		t.Fatal("expected AAC not to be transcoded when disabled")
+	}
+}
+
+// TestFFmpegCloseWhileWriting closes a transcoder whose stdin is full, Close
+// must not wait on the blocked write.
+func TestFFmpegCloseWhileWriting(t *testing.T) {
+	stubFFmpeg(t)
+	transcoder, err := NewAudioTranscoder(testAACCodec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		packet := &av.Packet{Data: make([]byte, 4096)}
+		for {
+			if err := transcoder.WritePacket(packet); err != nil {
+				written <- err
+				return
+			}
+			packet.Time += 20 * time.Millisecond
+		}
+	}()
+	// let the pipe fill up
+	time.Sleep(200 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		transcoder.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a pending write")
+	}
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write was not released by Close")
+	}
+	if err := transcoder.WritePacket(&av.Packet{}); err != ErrorTranscoderClosed {
+		t.Fatalf("expected closed, got %v", err)
+	}
+	for range transcoder.Packets() {
+	}
+}
+
+// catFFmpeg points the transcoder at a script that echoes its input, the
+// ADTS frames come back as PCMA frames.
+func catFFmpeg(t *testing.T) {
+	t.Helper()
+	stubFFmpeg(t)
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec cat\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config.Config.Transcode.Audio.FFmpeg = path
+}
+
+func TestAudioStage(t *testing.T) {
+	catFFmpeg(t)
+	stage, err := NewAudioStage([]av.CodecData{h264Codec(t), testAACCodec(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stage.Close()
+	if stage.Idx() != 1 || stage.Codecs()[0].Type() != av.H264 || stage.Codecs()[1].Type() != av.PCM_ALAW {
+		t.Fatalf("unexpected stage of track %d with %v", stage.Idx(), stage.Codecs())
+	}
+	if stage.WritePacket(&av.Packet{Idx: 0, Data: make([]byte, 100)}) {
+		t.Fatal("video was taken by the audio stage")
+	}
+	for i := 0; i < 4; i++ {
+		if !stage.WritePacket(&av.Packet{Idx: 1, Data: make([]byte, 100)}) {
+			t.Fatal("audio was not taken by the audio stage")
+		}
+	}
+	select {
+	case pkt := <-stage.Packets():
+		if pkt.Idx != 1 || len(pkt.Data) != pcmaFrameSize {
+			t.Fatalf("unexpected packet of track %d with %d bytes", pkt.Idx, len(pkt.Data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no transcoded packet")
+	}
+}
+
+// TestAudioStageStalled writes to a transcoder that never reads, the writer
+// must not block and the audio is dropped.
+func TestAudioStageStalled(t *testing.T) {
+	stubFFmpeg(t)
+	stage, err := NewAudioStage([]av.CodecData{testAACCodec(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 10000; i++ {
+			stage.WritePacket(&av.Packet{Data: make([]byte, 4096)})
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled transcoder blocked the writer")
+	}
+	if stage.Dropped() == 0 {
+		t.Fatal("expected dropped packets")
+	}
+	stage.Close()
+	for range stage.Packets() {
+	}
+}
+
+func TestAudioStageNotFound(t *testing.T) {
+	stubFFmpeg(t)
+	if _, err := NewAudioStage([]av.CodecData{h264Codec(t)}); err != ErrorTranscoderNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func h264Codec(t *testing.T) av.CodecData {
+	t.Helper()
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(
+		[]byte{0x67, 0x42, 0x00, 0x29, 0xe2, 0x90, 0x14, 0x07, 0xb6, 0x02, 0xdc, 0x04, 0x04, 0x06, 0x90, 0x78, 0x91, 0x15},
+		[]byte{0x68, 0xce, 0x3c, 0x80},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
@@ -0,0 +1,69 @@
+package transcode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var ErrorInvalidOggPage = errors.New("invalid ogg page")
+
+var oggCapturePattern = []byte("OggS")
+
+const oggPageHeaderSize = 27
+
+type oggReader struct {
+	r      io.Reader
+	lacing []byte
+	data   []byte
+	packet []byte
+}
+
+func newOggReader(r io.Reader) *oggReader {
+	return &oggReader{r: r}
+}
+
+func (o *oggReader) readPage() error {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := io.ReadFull(o.r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:4], oggCapturePattern) {
+		return ErrorInvalidOggPage
+	}
+	lacing := make([]byte, int(header[26]))
+	if _, err := io.ReadFull(o.r, lacing); err != nil {
+		return err
+	}
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(o.r, data); err != nil {
+		return err
+	}
+	o.lacing = lacing
+	o.data = data
+	return nil
+}
+
+func (o *oggReader) ReadPacket() ([]byte, error) {
+	for {
+		if len(o.lacing) == 0 {
+			if err := o.readPage(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		size := int(o.lacing[0])
+		o.lacing = o.lacing[1:]
+		o.packet = append(o.packet, o.data[:size]...)
+		o.data = o.data[size:]
+		if size < 255 {
+			packet := o.packet
+			o.packet = nil
+			return packet, nil
+		}
+	}
+}
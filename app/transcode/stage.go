@@ -0,0 +1,135 @@
+package transcode
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/deepch/vdk/av"
+)
+
+// stageQueueSize bounds the audio waiting for the transcoder, about a second
+// of AAC.
+const stageQueueSize = 64
+
+// AudioStageST transcodes the first audio track of a stream that has a
+// transcoder, once for everyone reading Packets. Packets are queued so a
+// stalled transcoder drops audio instead of blocking the writer.
+type AudioStageST struct {
+	idx        int8
+	input      []av.CodecData
+	codecs     []av.CodecData
+	transcoder AudioTranscoder
+	queue      chan *av.Packet
+	packets    chan *av.Packet
+	done       chan struct{}
+	closeOnce  sync.Once
+	dropped    atomic.Uint64
+}
+
+// NewAudioStage returns ErrorTranscoderNotFound when no track of codecs has
+// a transcoder, the other errors are those of NewAudioTranscoder.
+func NewAudioStage(codecs []av.CodecData) (*AudioStageST, error) {
+	err := ErrorTranscoderNotFound
+	for idx, codec := range codecs {
+		var transcoder AudioTranscoder
+		if transcoder, err = NewAudioTranscoder(codec); err == ErrorTranscoderNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		stage := &AudioStageST{
+			idx:        int8(idx),
+			input:      codecs,
+			codecs:     append([]av.CodecData(nil), codecs...),
+			transcoder: transcoder,
+			queue:      make(chan *av.Packet, stageQueueSize),
+			packets:    make(chan *av.Packet, transcoderChanSize),
+			done:       make(chan struct{}),
+		}
+		stage.codecs[idx] = transcoder.Codec()
+		go stage.writeWorker()
+		go stage.readWorker()
+		return stage, nil
+	}
+	return nil, err
+}
+
+// Input is the codecs the stage was made for.
+func (stage *AudioStageST) Input() []av.CodecData {
+	return stage.input
+}
+
+// Codecs is the codecs of the stream with the transcoded track replaced by
+// the codec of its transcoder.
+func (stage *AudioStageST) Codecs() []av.CodecData {
+	return stage.codecs
+}
+
+// Idx is the track that is transcoded.
+func (stage *AudioStageST) Idx() int8 {
+	return stage.idx
+}
+
+// Dropped is the number of packets the transcoder could not keep up with.
+func (stage *AudioStageST) Dropped() uint64 {
+	return stage.dropped.Load()
+}
+
+// WritePacket queues a packet of the transcoded track without blocking, it
+// reports false for the packets of the other tracks.
+func (stage *AudioStageST) WritePacket(pkt *av.Packet) bool {
+	if pkt.Idx != stage.idx {
+		return false
+	}
+	select {
+	case <-stage.done:
+	case stage.queue <- pkt:
+	default:
+		stage.dropped.Add(1)
+	}
+	return true
+}
+
+// Packets has the transcoded packets with the Idx of the track, it is
+// closed when the transcoder stops.
+func (stage *AudioStageST) Packets() <-chan *av.Packet {
+	return stage.packets
+}
+
+func (stage *AudioStageST) writeWorker() {
+	for {
+		select {
+		case <-stage.done:
+			return
+		case pkt := <-stage.queue:
+			if err := stage.transcoder.WritePacket(pkt); err != nil {
+				if err != ErrorTranscoderClosed {
+					log.Println("audio transcoder", err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// readWorker keeps draining the transcoder after Close so it can exit.
+func (stage *AudioStageST) readWorker() {
+	defer close(stage.packets)
+	for pkt := range stage.transcoder.Packets() {
+		pkt.Idx = stage.idx
+		select {
+		case <-stage.done:
+		case stage.packets <- pkt:
+		}
+	}
+}
+
+func (stage *AudioStageST) Close() error {
+	var err error
+	stage.closeOnce.Do(func() {
+		close(stage.done)
+		err = stage.transcoder.Close()
+	})
+	return err
+}
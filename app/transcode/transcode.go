@@ -0,0 +1,88 @@
+package transcode
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+)
+
+var (
+	ErrorTranscoderNotFound    = errors.New("no audio transcoder for codec")
+	ErrorTranscoderDisabled    = errors.New("audio transcoding is disabled")
+	ErrorTranscoderClosed      = errors.New("audio transcoder closed")
+	ErrorTranscoderUnavailable = errors.New("audio transcoder is not installed")
+)
+
+// AudioTranscoder converts the packets of one audio track into a codec
+// WebRTC viewers can play, output packets arrive on Packets until Close.
+type AudioTranscoder interface {
+	Codec() av.AudioCodecData
+	WritePacket(pkt *av.Packet) error
+	Packets() <-chan *av.Packet
+	Close() error
+}
+
+type AudioTranscoderFactory func(codec av.AudioCodecData) (AudioTranscoder, error)
+
+// AudioTranscoderAvailable reports whether a transcoder can run on this host,
+// e.g. that the binary it needs is installed.
+type AudioTranscoderAvailable func() bool
+
+type transcoderST struct {
+	factory   AudioTranscoderFactory
+	available AudioTranscoderAvailable
+}
+
+var transcodersMutex sync.RWMutex
+var transcoders = make(map[av.CodecType]transcoderST)
+
+// RegisterAudioTranscoder adds the transcoder of a codec, available may be
+// nil when it can always run.
+func RegisterAudioTranscoder(codecType av.CodecType, factory AudioTranscoderFactory, available AudioTranscoderAvailable) {
+	transcodersMutex.Lock()
+	defer transcodersMutex.Unlock()
+	transcoders[codecType] = transcoderST{
+		factory:   factory,
+		available: available,
+	}
+}
+
+func getAudioTranscoderFactory(codec av.CodecData) (AudioTranscoderFactory, error) {
+	if _, ok := codec.(av.AudioCodecData); !ok {
+		return nil, ErrorTranscoderNotFound
+	}
+	transcodersMutex.RLock()
+	transcoder, ok := transcoders[codec.Type()]
+	transcodersMutex.RUnlock()
+	if !ok || transcoder.factory == nil {
+		return nil, ErrorTranscoderNotFound
+	}
+	if !config.Config.Transcode.Audio.Enabled {
+		return nil, ErrorTranscoderDisabled
+	}
+	if transcoder.available != nil && !transcoder.available() {
+		return nil, ErrorTranscoderUnavailable
+	}
+	return transcoder.factory, nil
+}
+
+// CanTranscodeAudio reports whether viewers can be sent codec transcoded.
+func CanTranscodeAudio(codec av.CodecData) bool {
+	return CheckAudioTranscoder(codec) == nil
+}
+
+// CheckAudioTranscoder returns why codec can not be transcoded, or nil.
+func CheckAudioTranscoder(codec av.CodecData) error {
+	_, err := getAudioTranscoderFactory(codec)
+	return err
+}
+
+func NewAudioTranscoder(codec av.CodecData) (AudioTranscoder, error) {
+	factory, err := getAudioTranscoderFactory(codec)
+	if err != nil {
+		return nil, err
+	}
+	return factory(codec.(av.AudioCodecData))
+}
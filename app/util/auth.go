@@ -0,0 +1,114 @@
+package util
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+var ErrorAuthNotSupported = errors.New("unsupported auth challenge")
+
+// Authorization answers a basic or digest (RFC 7616, qop auth) challenge
+// for a request of method to uri.
+func Authorization(challenge, method, uri, username, password string) (string, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		req := http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	case "digest":
+	default:
+		return "", fmt.Errorf("%w %q", ErrorAuthNotSupported, scheme)
+	}
+	digest := ParseAuthParams(params)
+	var newHash func() hash.Hash
+	switch algorithm := strings.ToUpper(digest["algorithm"]); algorithm {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("%w digest algorithm %q", ErrorAuthNotSupported, algorithm)
+	}
+	hashHex := func(s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	ha1 := hashHex(username + ":" + digest["realm"] + ":" + password)
+	ha2 := hashHex(method + ":" + uri)
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, username),
+		fmt.Sprintf(`realm="%s"`, digest["realm"]),
+		fmt.Sprintf(`nonce="%s"`, digest["nonce"]),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+	qops := strings.Split(digest["qop"], ",")
+	if digest["qop"] == "" {
+		fields = append(fields, fmt.Sprintf(`response="%s"`, hashHex(ha1+":"+digest["nonce"]+":"+ha2)))
+	} else {
+		hasAuth := false
+		for _, qop := range qops {
+			hasAuth = hasAuth || strings.TrimSpace(qop) == "auth"
+		}
+		if !hasAuth {
+			return "", fmt.Errorf("%w digest qop %q", ErrorAuthNotSupported, digest["qop"])
+		}
+		cnonceBytes := make([]byte, 8)
+		if _, err := rand.Read(cnonceBytes); err != nil {
+			return "", err
+		}
+		cnonce := hex.EncodeToString(cnonceBytes)
+		nc := "00000001"
+		response := hashHex(ha1 + ":" + digest["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		fields = append(fields,
+			"qop=auth",
+			"nc="+nc,
+			fmt.Sprintf(`cnonce="%s"`, cnonce),
+			fmt.Sprintf(`response="%s"`, response),
+		)
+	}
+	if digest["algorithm"] != "" {
+		fields = append(fields, "algorithm="+digest["algorithm"])
+	}
+	if opaque, ok := digest["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// ParseAuthParams splits the parameters of a challenge or an Authorization
+// header into lowercase keys and unquoted values.
+func ParseAuthParams(params string) map[string]string {
+	result := make(map[string]string)
+	for len(params) > 0 {
+		params = strings.TrimLeft(params, " ,")
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		result[key] = value
+		params = rest
+	}
+	return result
+}
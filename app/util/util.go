@@ -3,18 +3,55 @@ package util
 import (
 	"bufio"
 	"bytes"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"hash/fnv"
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
 )
 
+var ErrorPathOutsideRoot = errors.New("path is outside of the root folder")
+
+// ConfinePath resolves path inside root, a relative path is taken from root.
+// Paths with a ".." element or that lead out of root, also through a
+// symlink, are rejected.
+func ConfinePath(root, path string) (string, error) {
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return "", ErrorPathOutsideRoot
+		}
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrorPathOutsideRoot
+	}
+	return resolved, nil
+}
+
 func ToBytes(e any) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -54,6 +91,47 @@ func IsAudioOnly(codecs []av.CodecData) bool {
 	return true
 }
 
+// CodecsEqual is true when a and b describe the same streams, down to the
+// parameter sets of video and the configuration of audio.
+func CodecsEqual(a, b []av.CodecData) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() {
+			return false
+		}
+		switch codec := a[i].(type) {
+		case interface{ AVCDecoderConfRecordBytes() []byte }:
+			other, ok := b[i].(interface{ AVCDecoderConfRecordBytes() []byte })
+			if !ok || !bytes.Equal(codec.AVCDecoderConfRecordBytes(), other.AVCDecoderConfRecordBytes()) {
+				return false
+			}
+		case av.AudioCodecData:
+			other, ok := b[i].(av.AudioCodecData)
+			if !ok || codec.SampleRate() != other.SampleRate() || codec.ChannelLayout() != other.ChannelLayout() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// JoinNALUs packs nalus with the framing typ returned by
+// h264parser.SplitNALUs, raw packets are packed as AVCC.
+func JoinNALUs(nalus [][]byte, typ int) []byte {
+	var data []byte
+	for _, nalu := range nalus {
+		if typ == h264parser.NALU_ANNEXB {
+			data = append(data, 0, 0, 0, 1)
+		} else {
+			data = binary.BigEndian.AppendUint32(data, uint32(len(nalu)))
+		}
+		data = append(data, nalu...)
+	}
+	return data
+}
+
 func FuzzyEquals(
 	query string,
 	text string,
@@ -65,16 +143,22 @@ func FuzzyEquals(
 	}
 }
 
+// SecretEquals compares secrets like stream keys in constant time, an empty
+// secret never matches.
+func SecretEquals(secret, value string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(value)) == 1
+}
+
 func Hash(s string) uint64 {
 	h := fnv.New64a()
 	h.Write([]byte(s))
 	return h.Sum64()
 }
 
-func CodecsToStrings(codecs []av.CodecData) []string {
+func CodecsToStrings(codecs []av.CodecData, supported func(av.CodecData) bool) []string {
 	var out []string
 	for _, codec := range codecs {
-		if codec.Type() != av.H264 && codec.Type() != av.PCM_ALAW && codec.Type() != av.PCM_MULAW && codec.Type() != av.OPUS {
+		if !supported(codec) {
 			log.Println("Codec Not Supported WebRTC ignore this track", codec.Type())
 			continue
 		}
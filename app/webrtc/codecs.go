@@ -0,0 +1,23 @@
+package webrtc
+
+import (
+	"github.com/aicacia/streams/app/transcode"
+	"github.com/deepch/vdk/av"
+)
+
+func isNativeCodec(codec av.CodecData) bool {
+	switch codec.Type() {
+	case av.H264, av.PCM_ALAW, av.PCM_MULAW, av.OPUS:
+		return true
+	default:
+		return false
+	}
+}
+
+func IsCodecSupported(codec av.CodecData) bool {
+	return isNativeCodec(codec) || transcode.CanTranscodeAudio(codec)
+}
+
+func IsGridCodecSupported(codec av.CodecData) bool {
+	return codec.Type() == av.H264
+}
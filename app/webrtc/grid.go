@@ -0,0 +1,334 @@
+package webrtc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	ErrorGridNoSlots      = errors.New("WebRTC grid offer has no video transceivers")
+	ErrorGridInvalidSlot  = errors.New("WebRTC grid slot does not exist")
+	ErrorGridNotNegotiate = errors.New("WebRTC grid can not renegotiate in current signaling state")
+	ErrorGridInvalidOffer = errors.New("WebRTC grid offer is not valid base64")
+)
+
+const gridDataChannelLabel = "grid"
+
+// GridMuxer sends the video of many cameras over a single PeerConnection.
+// Every recvonly video transceiver in the viewer's offer becomes a slot with
+// its own track, cameras are assigned to slots without renegotiating and a
+// renegotiation with more transceivers adds more slots.
+type GridMuxer struct {
+	mutex   sync.RWMutex
+	options Options
+	pc      *webrtc.PeerConnection
+	channel *webrtc.DataChannel
+	slots   []*gridSlot
+	status  webrtc.ICEConnectionState
+	stop    bool
+	done    chan struct{}
+}
+
+type gridSlot struct {
+	mutex    sync.Mutex
+	track    *webrtc.TrackLocalStaticSample
+	sender   *webrtc.RTPSender
+	cameraId string
+	codec    *h264parser.CodecData
+	started  bool
+}
+
+func NewGridMuxer(options Options) *GridMuxer {
+	return &GridMuxer{
+		options: options,
+		done:    make(chan struct{}),
+	}
+}
+
+func (element *GridMuxer) Done() <-chan struct{} {
+	return element.done
+}
+
+func (element *GridMuxer) WriteHeader(sdp64 string) (answer64 string, err error) {
+	pc, err := NewPeerConnection(element.options, webrtc.Configuration{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
+	})
+	if err != nil {
+		return "", err
+	}
+	element.mutex.Lock()
+	element.pc = pc
+	element.mutex.Unlock()
+	defer func() {
+		if err != nil {
+			element.Close()
+		}
+	}()
+	pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		element.mutex.Lock()
+		element.status = connectionState
+		element.mutex.Unlock()
+		// disconnected often recovers, the grid is a long lived session
+		switch connectionState {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			element.Close()
+		}
+	})
+	pc.OnDataChannel(func(channel *webrtc.DataChannel) {
+		if channel.Label() != gridDataChannelLabel {
+			return
+		}
+		channel.OnOpen(func() {
+			element.mutex.Lock()
+			element.channel = channel
+			element.mutex.Unlock()
+			element.sendTracks()
+		})
+	})
+	answer64, err = element.negotiate(pc, sdp64, gatheringTimeout)
+	if err != nil {
+		return "", err
+	}
+	if len(element.Tracks()) == 0 {
+		return "", ErrorGridNoSlots
+	}
+	element.mutex.Lock()
+	if !element.stop {
+		addSession(element)
+	}
+	element.mutex.Unlock()
+	return answer64, nil
+}
+
+// Renegotiate applies a new offer from the viewer, new video transceivers
+// become new slots.
+func (element *GridMuxer) Renegotiate(sdp64 string) (string, error) {
+	element.mutex.RLock()
+	pc := element.pc
+	stop := element.stop
+	element.mutex.RUnlock()
+	if stop || pc == nil {
+		return "", ErrorClientOffline
+	}
+	if pc.SignalingState() != webrtc.SignalingStateStable {
+		return "", ErrorGridNotNegotiate
+	}
+	answer64, err := element.negotiate(pc, sdp64, gatheringTimeout)
+	if err != nil {
+		return "", err
+	}
+	element.sendTracks()
+	return answer64, nil
+}
+
+func (element *GridMuxer) negotiate(pc *webrtc.PeerConnection, sdp64 string, timeout time.Duration) (string, error) {
+	sdpBytes, err := base64.StdEncoding.DecodeString(sdp64)
+	if err != nil {
+		return "", fmt.Errorf("%w %s", ErrorGridInvalidOffer, err)
+	}
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(sdpBytes),
+	}); err != nil {
+		return "", err
+	}
+	if err = element.addSlots(pc); err != nil {
+		return "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err = pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-time.After(timeout):
+		return "", ErrorGatheringTimeout
+	case <-gatherComplete:
+	}
+	return base64.StdEncoding.EncodeToString([]byte(pc.LocalDescription().SDP)), nil
+}
+
+func (element *GridMuxer) addSlots(pc *webrtc.PeerConnection) error {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeVideo || transceiver.Sender() != nil {
+			continue
+		}
+		idx := len(element.slots)
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeH264,
+		}, fmt.Sprintf("grid-video-%d", idx), fmt.Sprintf("grid-%d", idx))
+		if err != nil {
+			return err
+		}
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			return err
+		}
+		go readRTCP(sender)
+		element.slots = append(element.slots, &gridSlot{
+			track:  track,
+			sender: sender,
+		})
+	}
+	return nil
+}
+
+func (element *GridMuxer) SlotCount() int {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return len(element.slots)
+}
+
+func (element *GridMuxer) getSlot(slot int) *gridSlot {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	if slot < 0 || slot >= len(element.slots) {
+		return nil
+	}
+	return element.slots[slot]
+}
+
+// SetSlot assigns a camera to a slot, the slot waits for the next key frame
+// before sending video from the new camera.
+func (element *GridMuxer) SetSlot(slot int, cameraId string) error {
+	s := element.getSlot(slot)
+	if s == nil {
+		return ErrorGridInvalidSlot
+	}
+	s.mutex.Lock()
+	s.cameraId = cameraId
+	s.codec = nil
+	s.started = false
+	s.mutex.Unlock()
+	element.sendTracks()
+	return nil
+}
+
+func (element *GridMuxer) SetSlotCodec(slot int, codec h264parser.CodecData) error {
+	s := element.getSlot(slot)
+	if s == nil {
+		return ErrorGridInvalidSlot
+	}
+	s.mutex.Lock()
+	s.codec = &codec
+	s.mutex.Unlock()
+	return nil
+}
+
+func (element *GridMuxer) WritePacket(slot int, pkt av.Packet) error {
+	element.mutex.RLock()
+	stop := element.stop
+	status := element.status
+	element.mutex.RUnlock()
+	if stop {
+		return ErrorClientOffline
+	}
+	if status != webrtc.ICEConnectionStateConnected || len(pkt.Data) < 5 {
+		return nil
+	}
+	s := element.getSlot(slot)
+	if s == nil {
+		return ErrorGridInvalidSlot
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.codec == nil {
+		return nil
+	}
+	if !s.started {
+		if !pkt.IsKeyFrame {
+			return nil
+		}
+		s.started = true
+	}
+	return writeH264(s.track, *s.codec, &pkt)
+}
+
+func (element *GridMuxer) Tracks() []models.GridTrackST {
+	element.mutex.RLock()
+	slots := element.slots
+	element.mutex.RUnlock()
+	tracks := make([]models.GridTrackST, 0, len(slots))
+	for idx, s := range slots {
+		mid := ""
+		if transceiver := element.transceiverOf(s.sender); transceiver != nil {
+			mid = transceiver.Mid()
+		}
+		s.mutex.Lock()
+		tracks = append(tracks, models.GridTrackST{
+			Slot:     idx,
+			Mid:      mid,
+			TrackId:  s.track.ID(),
+			CameraId: s.cameraId,
+		})
+		s.mutex.Unlock()
+	}
+	return tracks
+}
+
+func (element *GridMuxer) transceiverOf(sender *webrtc.RTPSender) *webrtc.RTPTransceiver {
+	element.mutex.RLock()
+	pc := element.pc
+	element.mutex.RUnlock()
+	if pc == nil {
+		return nil
+	}
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Sender() == sender {
+			return transceiver
+		}
+	}
+	return nil
+}
+
+func (element *GridMuxer) sendTracks() {
+	element.mutex.RLock()
+	channel := element.channel
+	element.mutex.RUnlock()
+	if channel == nil || channel.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	bytes, err := json.Marshal(models.GridTracksMessageST{
+		Type:   "tracks",
+		Tracks: element.Tracks(),
+	})
+	if err != nil {
+		log.Println("grid tracks message", err)
+		return
+	}
+	if err := channel.SendText(string(bytes)); err != nil {
+		log.Println("grid tracks message", err)
+	}
+}
+
+func (element *GridMuxer) Close() error {
+	element.mutex.Lock()
+	if element.stop {
+		element.mutex.Unlock()
+		return nil
+	}
+	element.stop = true
+	close(element.done)
+	pc := element.pc
+	element.mutex.Unlock()
+	removeSession(element)
+	if pc != nil {
+		return pc.Close()
+	}
+	return nil
+}
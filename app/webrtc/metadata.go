@@ -0,0 +1,81 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/models"
+	"github.com/deepch/vdk/av"
+	"github.com/pion/webrtc/v3"
+)
+
+const metadataDataChannelLabel = "metadata"
+const metadataSyncInterval = time.Second
+
+type metadataST struct {
+	mutex          sync.RWMutex
+	channel        *webrtc.DataChannel
+	lastPacketTime time.Time
+}
+
+func (m *metadataST) setChannel(channel *webrtc.DataChannel) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.channel = channel
+}
+
+func (m *metadataST) write(pkt *av.Packet, packetTime time.Time) {
+	m.mutex.Lock()
+	m.lastPacketTime = packetTime
+	m.mutex.Unlock()
+	if pkt.IsKeyFrame {
+		m.send(models.FrameMetadataST{
+			Type:       "keyframe",
+			Idx:        pkt.Idx,
+			Time:       packetTime.UnixMilli(),
+			ServerTime: time.Now().UTC().UnixMilli(),
+		})
+	}
+}
+
+func (m *metadataST) send(message models.FrameMetadataST) {
+	m.mutex.RLock()
+	channel := m.channel
+	m.mutex.RUnlock()
+	if channel == nil || channel.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		log.Println("metadata message", err)
+		return
+	}
+	if err := channel.SendText(string(bytes)); err != nil {
+		log.Println("metadata message", err)
+	}
+}
+
+func (m *metadataST) syncWorker(done <-chan struct{}) {
+	ticker := time.NewTicker(metadataSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m.mutex.RLock()
+			lastPacketTime := m.lastPacketTime
+			m.mutex.RUnlock()
+			if lastPacketTime.IsZero() {
+				continue
+			}
+			m.send(models.FrameMetadataST{
+				Type:       "sync",
+				Time:       lastPacketTime.UnixMilli(),
+				ServerTime: time.Now().UTC().UnixMilli(),
+			})
+		}
+	}
+}
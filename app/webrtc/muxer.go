@@ -0,0 +1,272 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+var (
+	ErrorNotFound          = errors.New("WebRTC Stream Not Found")
+	ErrorCodecNotSupported = errors.New("WebRTC Codec Not Supported")
+	ErrorClientOffline     = errors.New("WebRTC Client Offline")
+	ErrorNotTrackAvailable = errors.New("WebRTC Not Track Available")
+	ErrorIgnoreAudioTrack  = errors.New("WebRTC Ignore Audio Track codec not supported WebRTC support only PCM_ALAW, PCM_MULAW or OPUS")
+	ErrorGatheringTimeout  = errors.New("WebRTC ICE gathering timed out")
+)
+
+const gatheringTimeout = 10 * time.Second
+
+type Muxer struct {
+	mutex    sync.RWMutex
+	streams  map[int8]*stream
+	status   webrtc.ICEConnectionState
+	stop     bool
+	done     chan struct{}
+	pc       *webrtc.PeerConnection
+	options  Options
+	metadata *metadataST
+}
+
+type stream struct {
+	codec av.CodecData
+	track *webrtc.TrackLocalStaticSample
+}
+
+func NewMuxer(options Options) *Muxer {
+	return &Muxer{
+		options:  options,
+		streams:  make(map[int8]*stream),
+		done:     make(chan struct{}),
+		metadata: &metadataST{},
+	}
+}
+
+// Done is closed when the muxer is closed, by the caller or because the
+// connection to the viewer was lost.
+func (element *Muxer) Done() <-chan struct{} {
+	return element.done
+}
+
+func readRTCP(sender *webrtc.RTPSender) {
+	rtcpBuf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(rtcpBuf); err != nil {
+			return
+		}
+	}
+}
+
+func newTrack(codec av.CodecData) (*webrtc.TrackLocalStaticSample, error) {
+	if codec.Type().IsVideo() {
+		if codec.Type() != av.H264 {
+			return nil, ErrorCodecNotSupported
+		}
+		return webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeH264,
+		}, "streams-video", "streams-video")
+	}
+	var mimeType string
+	switch codec.Type() {
+	case av.PCM_ALAW:
+		mimeType = webrtc.MimeTypePCMA
+	case av.PCM_MULAW:
+		mimeType = webrtc.MimeTypePCMU
+	case av.OPUS:
+		mimeType = webrtc.MimeTypeOpus
+	default:
+		return nil, ErrorIgnoreAudioTrack
+	}
+	audioCodec := codec.(av.AudioCodecData)
+	return webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:  mimeType,
+		Channels:  uint16(audioCodec.ChannelLayout().Count()),
+		ClockRate: uint32(audioCodec.SampleRate()),
+	}, "streams-audio", "streams-audio")
+}
+
+func newStream(codec av.CodecData) (*stream, error) {
+	track, err := newTrack(codec)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{codec: codec, track: track}, nil
+}
+
+func (element *Muxer) WriteHeader(codecs []av.CodecData, sdp64 string) (answer64 string, err error) {
+	if len(codecs) == 0 {
+		return "", ErrorNotFound
+	}
+	sdpBytes, err := base64.StdEncoding.DecodeString(sdp64)
+	if err != nil {
+		return "", err
+	}
+	pc, err := NewPeerConnection(element.options, webrtc.Configuration{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlanWithFallback,
+	})
+	if err != nil {
+		return "", err
+	}
+	element.mutex.Lock()
+	element.pc = pc
+	element.mutex.Unlock()
+	defer func() {
+		if err != nil {
+			element.Close()
+		}
+	}()
+	for idx, codec := range codecs {
+		s, streamErr := newStream(codec)
+		if streamErr != nil {
+			log.Println(streamErr, codec.Type())
+			continue
+		}
+		sender, addErr := pc.AddTrack(s.track)
+		if addErr != nil {
+			return "", addErr
+		}
+		go readRTCP(sender)
+		element.streams[int8(idx)] = s
+	}
+	if len(element.streams) == 0 {
+		return "", ErrorNotTrackAvailable
+	}
+	pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		element.mutex.Lock()
+		element.status = connectionState
+		element.mutex.Unlock()
+		switch connectionState {
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			element.Close()
+		}
+	})
+	pc.OnDataChannel(func(channel *webrtc.DataChannel) {
+		if channel.Label() == metadataDataChannelLabel {
+			channel.OnOpen(func() {
+				element.metadata.setChannel(channel)
+				go element.metadata.syncWorker(element.done)
+			})
+		}
+	})
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(sdpBytes),
+	}); err != nil {
+		return "", err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err = pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-time.After(gatheringTimeout):
+		return "", ErrorGatheringTimeout
+	case <-gatherComplete:
+	}
+	element.mutex.Lock()
+	if !element.stop {
+		addSession(element)
+	}
+	element.mutex.Unlock()
+	return base64.StdEncoding.EncodeToString([]byte(pc.LocalDescription().SDP)), nil
+}
+
+func (element *Muxer) WritePacket(pkt av.Packet) (err error) {
+	element.mutex.RLock()
+	stop := element.stop
+	status := element.status
+	element.mutex.RUnlock()
+	if stop {
+		return ErrorClientOffline
+	}
+	if status != webrtc.ICEConnectionStateConnected {
+		return nil
+	}
+	s, ok := element.streams[pkt.Idx]
+	if !ok || len(pkt.Data) < 5 {
+		return nil
+	}
+	switch s.codec.Type() {
+	case av.H264:
+		err = writeH264(s.track, s.codec.(h264parser.CodecData), &pkt)
+	case av.PCM_ALAW, av.PCM_MULAW, av.OPUS:
+		err = s.track.WriteSample(media.Sample{Data: pkt.Data, Duration: pkt.Duration})
+	default:
+		err = ErrorCodecNotSupported
+	}
+	if err != nil {
+		element.Close()
+	}
+	return err
+}
+
+// writeH264 sends keyframes with the SPS and PPS of the packet when it
+// carries its own, otherwise with those of codec.
+func writeH264(track *webrtc.TrackLocalStaticSample, codec h264parser.CodecData, pkt *av.Packet) error {
+	nalus, _ := h264parser.SplitNALUs(pkt.Data)
+	sps, pps := codec.SPS(), codec.PPS()
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case h264parser.NALU_SPS:
+			sps = nalu
+		case h264parser.NALU_PPS:
+			pps = nalu
+		}
+	}
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		var data []byte
+		switch nalu[0] & 0x1f {
+		case 5:
+			data = append([]byte{0, 0, 0, 1}, bytes.Join([][]byte{sps, pps, nalu}, []byte{0, 0, 0, 1})...)
+		case 1:
+			data = append([]byte{0, 0, 0, 1}, nalu...)
+		default:
+			continue
+		}
+		if err := track.WriteSample(media.Sample{Data: data, Duration: pkt.Duration}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (element *Muxer) Close() error {
+	element.mutex.Lock()
+	if element.stop {
+		element.mutex.Unlock()
+		return nil
+	}
+	element.stop = true
+	close(element.done)
+	pc := element.pc
+	element.mutex.Unlock()
+	removeSession(element)
+	if pc != nil {
+		return pc.Close()
+	}
+	return nil
+}
+
+// WriteMetadata records the wall clock time of a packet and sends it to the
+// viewer's metadata data channel when the packet is a key frame.
+func (element *Muxer) WriteMetadata(pkt *av.Packet, packetTime time.Time) {
+	element.metadata.write(pkt, packetTime)
+}
@@ -0,0 +1,54 @@
+package webrtc
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// sessions are the negotiated peer connections, they are closed together
+// on shutdown.
+var sessionsMutex sync.Mutex
+var sessions = make(map[io.Closer]bool)
+
+func addSession(session io.Closer) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	sessions[session] = true
+}
+
+func removeSession(session io.Closer) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	delete(sessions, session)
+}
+
+// CloseAll closes every live, grid and WHIP peer connection at once, it
+// returns ctx's error when they are not closed by the deadline.
+func CloseAll(ctx context.Context) error {
+	sessionsMutex.Lock()
+	closers := make([]io.Closer, 0, len(sessions))
+	for session := range sessions {
+		closers = append(closers, session)
+	}
+	sessionsMutex.Unlock()
+	var wg sync.WaitGroup
+	for _, session := range closers {
+		wg.Add(1)
+		go func(session io.Closer) {
+			defer wg.Done()
+			session.Close()
+		}(session)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,137 @@
+package webrtc
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+const tcpMuxReadBufferSize = 8
+
+var muxOnce sync.Once
+var udpMux ice.UDPMux
+var tcpMux ice.TCPMux
+
+type Options struct {
+	ICEServers    []string
+	ICEUsername   string
+	ICECredential string
+	NAT1To1IPs    []string
+	NAT1To1Type   webrtc.ICECandidateType
+	PortMin       uint16
+	PortMax       uint16
+	UDPMux        ice.UDPMux
+	TCPMux        ice.TCPMux
+	DisableICEUDP bool
+	// RegisterCodecs replaces the default codecs of the MediaEngine
+	RegisterCodecs func(m *webrtc.MediaEngine) error
+}
+
+func initMux() {
+	host := net.ParseIP(config.Config.Host)
+	if port := config.Config.Ice.UDPMux.Port; port > 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: host, Port: port})
+		if err != nil {
+			log.Printf("Failed to listen for ICE UDP mux on %d: %s\n", port, err)
+		} else {
+			log.Printf("ICE UDP mux listening on %s\n", conn.LocalAddr())
+			udpMux = webrtc.NewICEUDPMux(nil, conn)
+		}
+	}
+	if port := config.Config.Ice.TCPMux.Port; port > 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: host, Port: port})
+		if err != nil {
+			log.Printf("Failed to listen for ICE TCP mux on %d: %s\n", port, err)
+		} else {
+			log.Printf("ICE TCP mux listening on %s\n", listener.Addr())
+			tcpMux = webrtc.NewICETCPMux(nil, listener, tcpMuxReadBufferSize)
+		}
+	}
+}
+
+func nat1To1CandidateType(candidateType string) webrtc.ICECandidateType {
+	switch strings.ToLower(candidateType) {
+	case "srflx":
+		return webrtc.ICECandidateTypeSrflx
+	default:
+		return webrtc.ICECandidateTypeHost
+	}
+}
+
+func DefaultOptions() Options {
+	muxOnce.Do(initMux)
+	return Options{
+		ICEServers:    config.Config.Ice.Servers,
+		ICEUsername:   config.Config.Ice.Username,
+		ICECredential: config.Config.Ice.Credential,
+		NAT1To1IPs:    config.Config.Ice.NAT1To1.IPs,
+		NAT1To1Type:   nat1To1CandidateType(config.Config.Ice.NAT1To1.CandidateType),
+		PortMin:       uint16(config.Config.Ice.Port.Min),
+		PortMax:       uint16(config.Config.Ice.Port.Max),
+		UDPMux:        udpMux,
+		TCPMux:        tcpMux,
+		DisableICEUDP: config.Config.Ice.TCPMux.Only,
+	}
+}
+
+func NewPeerConnection(options Options, configuration webrtc.Configuration) (*webrtc.PeerConnection, error) {
+	if len(options.ICEServers) > 0 {
+		configuration.ICEServers = append(configuration.ICEServers, webrtc.ICEServer{
+			URLs:           options.ICEServers,
+			Username:       options.ICEUsername,
+			Credential:     options.ICECredential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	} else {
+		configuration.ICEServers = append(configuration.ICEServers, webrtc.ICEServer{
+			URLs: []string{"stun:stun.l.google.com:19302"},
+		})
+	}
+	m := &webrtc.MediaEngine{}
+	registerCodecs := options.RegisterCodecs
+	if registerCodecs == nil {
+		registerCodecs = func(m *webrtc.MediaEngine) error {
+			return m.RegisterDefaultCodecs()
+		}
+	}
+	if err := registerCodecs(m); err != nil {
+		return nil, err
+	}
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+	s := webrtc.SettingEngine{}
+	if options.PortMin > 0 && options.PortMax >= options.PortMin {
+		if err := s.SetEphemeralUDPPortRange(options.PortMin, options.PortMax); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.NAT1To1IPs) > 0 {
+		s.SetNAT1To1IPs(options.NAT1To1IPs, options.NAT1To1Type)
+	}
+	if options.UDPMux != nil {
+		s.SetICEUDPMux(options.UDPMux)
+	}
+	if options.TCPMux != nil {
+		s.SetICETCPMux(options.TCPMux)
+		if options.DisableICEUDP {
+			s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6})
+		} else {
+			s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6})
+		}
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(s))
+	return api.NewPeerConnection(configuration)
+}
+
+func publisherCodecsTimeout() time.Duration {
+	return time.Duration(config.Config.RTSP.Connect.Timeout.Seconds) * time.Second
+}
@@ -0,0 +1,422 @@
+package webrtc
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+var (
+	ErrorWHIPNoTracks      = errors.New("WHIP offer has no audio or video tracks")
+	ErrorWHIPCodecsTimeout = errors.New("WHIP publisher did not send codec data in time")
+	ErrorWHIPCodecUpdate   = errors.New("WHIP publisher started another track")
+)
+
+const whipPacketChanSize = 1024
+const whipSampleBuilderMaxLate = 512
+const whipPLIInterval = 3 * time.Second
+
+var whipVideoRTCPFeedback = []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+
+func registerWHIPCodecs(m *webrtc.MediaEngine) error {
+	for _, c := range []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"}, PayloadType: 111},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, PayloadType: 0},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, PayloadType: 8},
+	} {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	for _, c := range []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: whipVideoRTCPFeedback}, PayloadType: 102},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: whipVideoRTCPFeedback}, PayloadType: 125},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", RTCPFeedback: whipVideoRTCPFeedback}, PayloadType: 127},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032", RTCPFeedback: whipVideoRTCPFeedback}, PayloadType: 123},
+	} {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WHIPPublisher receives a WebRTC publish and turns its H264 and
+// Opus/G.711 RTP into av.Packets, it can be read like an RTMP connection.
+// Tracks get their index in the order they start, transceivers that never
+// send or have an unsupported codec get none.
+type WHIPPublisher struct {
+	mutex   sync.RWMutex
+	pc      *webrtc.PeerConnection
+	video   bool
+	codecs  []av.CodecData
+	clock   whipClockST
+	ready   chan struct{}
+	packets chan *av.Packet
+	done    chan struct{}
+	stop    bool
+}
+
+// whipClockST is the time base the tracks of a publisher share, a track
+// starts at the arrival of its first packet and follows its RTP timestamps
+// from there so audio and video line up.
+type whipClockST struct {
+	mutex sync.Mutex
+	start time.Time
+}
+
+func (c *whipClockST) since(now time.Time) time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.start.IsZero() {
+		c.start = now
+	}
+	return now.Sub(c.start)
+}
+
+type whipTrackClockST struct {
+	clock     *whipClockST
+	clockRate time.Duration
+	started   bool
+	timestamp uint32
+	time      time.Duration
+}
+
+func (c *whipClockST) track(clockRate uint32) *whipTrackClockST {
+	return &whipTrackClockST{clock: c, clockRate: time.Duration(clockRate)}
+}
+
+// at returns the time of an RTP timestamp that arrived at now, steps are
+// taken as int32 so reordered and wrapping timestamps stay close.
+func (t *whipTrackClockST) at(timestamp uint32, now time.Time) time.Duration {
+	if !t.started {
+		t.started = true
+		t.time = t.clock.since(now)
+	} else {
+		t.time += time.Duration(int32(timestamp-t.timestamp)) * time.Second / t.clockRate
+	}
+	t.timestamp = timestamp
+	return t.time
+}
+
+func NewWHIPPublisher(options Options, offer string) (*WHIPPublisher, string, error) {
+	options.RegisterCodecs = registerWHIPCodecs
+	pc, err := NewPeerConnection(options, webrtc.Configuration{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	publisher := &WHIPPublisher{
+		pc:      pc,
+		ready:   make(chan struct{}),
+		packets: make(chan *av.Packet, whipPacketChanSize),
+		done:    make(chan struct{}),
+	}
+	answer, err := publisher.negotiate(offer)
+	if err != nil {
+		publisher.Close()
+		return nil, "", err
+	}
+	return publisher, answer, nil
+}
+
+func (p *WHIPPublisher) negotiate(offer string) (string, error) {
+	p.pc.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		switch connectionState {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			p.Close()
+		}
+	})
+	p.pc.OnTrack(p.onTrack)
+	if err := p.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	}); err != nil {
+		return "", err
+	}
+	tracks := 0
+	for _, transceiver := range p.pc.GetTransceivers() {
+		switch transceiver.Kind() {
+		case webrtc.RTPCodecTypeVideo:
+			p.video = true
+			tracks++
+		case webrtc.RTPCodecTypeAudio:
+			tracks++
+		}
+	}
+	if tracks == 0 {
+		return "", ErrorWHIPNoTracks
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(p.pc)
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err = p.pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-time.After(gatheringTimeout):
+		return "", ErrorGatheringTimeout
+	case <-gatherComplete:
+	}
+	p.mutex.Lock()
+	if !p.stop {
+		addSession(p)
+	}
+	p.mutex.Unlock()
+	return p.pc.LocalDescription().SDP, nil
+}
+
+// addCodec gives a track the next index. A publisher is ready with its first
+// video keyframe, or with its first track when it has no video, the tracks
+// that start later are announced by a codec update.
+func (p *WHIPPublisher) addCodec(codecData av.CodecData) (idx int8, ok bool) {
+	p.mutex.Lock()
+	started := p.isReadyLocked()
+	p.codecs = append(p.codecs, codecData)
+	idx = int8(len(p.codecs) - 1)
+	if !p.video {
+		p.setReadyLocked()
+	}
+	p.mutex.Unlock()
+	if started {
+		return idx, p.emit(nil)
+	}
+	return idx, true
+}
+
+func (p *WHIPPublisher) isReadyLocked() bool {
+	select {
+	case <-p.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *WHIPPublisher) setReadyLocked() {
+	if !p.isReadyLocked() {
+		close(p.ready)
+	}
+}
+
+func (p *WHIPPublisher) setReady() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.setReadyLocked()
+}
+
+func (p *WHIPPublisher) onTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	mimeType := strings.ToLower(track.Codec().MimeType)
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		go p.readH264(track)
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		go p.readAudio(codec.NewOpusCodecData(int(track.Codec().ClockRate), av.CH_STEREO), track)
+	case strings.ToLower(webrtc.MimeTypePCMA):
+		go p.readAudio(codec.NewPCMAlawCodecData(), track)
+	case strings.ToLower(webrtc.MimeTypePCMU):
+		go p.readAudio(codec.NewPCMMulawCodecData(), track)
+	default:
+		log.Println(ErrorCodecNotSupported, track.Codec().MimeType)
+	}
+}
+
+func (p *WHIPPublisher) emit(packet *av.Packet) bool {
+	select {
+	case p.packets <- packet:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *WHIPPublisher) readAudio(codecData av.CodecData, track *webrtc.TrackRemote) {
+	idx := int8(-1)
+	clock := p.clock.track(track.Codec().ClockRate)
+	var lastTime time.Duration
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		packetTime := clock.at(packet.Timestamp, time.Now())
+		duration := 20 * time.Millisecond
+		if idx < 0 {
+			var ok bool
+			if idx, ok = p.addCodec(codecData); !ok {
+				return
+			}
+		} else {
+			duration = packetTime - lastTime
+		}
+		lastTime = packetTime
+		if !p.emit(&av.Packet{
+			Idx:      idx,
+			Time:     packetTime,
+			Duration: duration,
+			Data:     packet.Payload,
+		}) {
+			return
+		}
+	}
+}
+
+func spropParameterSets(fmtp string) (sps, pps []byte) {
+	for _, param := range strings.Split(fmtp, ";") {
+		keyValue := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(keyValue) != 2 || keyValue[0] != "sprop-parameter-sets" {
+			continue
+		}
+		sets := strings.Split(keyValue[1], ",")
+		if len(sets) >= 2 {
+			sps, _ = base64.StdEncoding.DecodeString(sets[0])
+			pps, _ = base64.StdEncoding.DecodeString(sets[1])
+		}
+	}
+	return
+}
+
+func (p *WHIPPublisher) requestKeyFrame(track *webrtc.TrackRemote, keyFrame <-chan struct{}) {
+	ticker := time.NewTicker(whipPLIInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}); err != nil {
+			return
+		}
+		select {
+		case <-keyFrame:
+			return
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *WHIPPublisher) readH264(track *webrtc.TrackRemote) {
+	sps, pps := spropParameterSets(track.Codec().SDPFmtpLine)
+	idx := int8(-1)
+	keyFrame := make(chan struct{})
+	go p.requestKeyFrame(track, keyFrame)
+
+	builder := samplebuilder.New(whipSampleBuilderMaxLate, &codecs.H264Packet{}, track.Codec().ClockRate)
+	clock := p.clock.track(track.Codec().ClockRate)
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		builder.Push(packet)
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			nalus, _ := h264parser.SplitNALUs(sample.Data)
+			data := make([]byte, 0, len(sample.Data)+4*len(nalus))
+			isKeyFrame := false
+			for _, nalu := range nalus {
+				if len(nalu) == 0 {
+					continue
+				}
+				switch nalu[0] & 0x1f {
+				case 7:
+					sps = nalu
+				case 8:
+					pps = nalu
+				case 5:
+					isKeyFrame = true
+					fallthrough
+				case 1:
+					size := len(nalu)
+					data = append(data, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+					data = append(data, nalu...)
+				}
+			}
+			if idx < 0 && len(sps) > 0 && len(pps) > 0 {
+				c, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+				if err != nil {
+					log.Println("WHIP SPS/PPS", err)
+				} else {
+					var ok bool
+					if idx, ok = p.addCodec(c); !ok {
+						return
+					}
+				}
+			}
+			if idx < 0 || len(data) == 0 {
+				continue
+			}
+			packetTime := clock.at(sample.PacketTimestamp, time.Now())
+			if !p.emit(&av.Packet{
+				IsKeyFrame: isKeyFrame,
+				Idx:        idx,
+				Time:       packetTime,
+				Duration:   sample.Duration,
+				Data:       data,
+			}) {
+				return
+			}
+			if isKeyFrame && keyFrame != nil {
+				close(keyFrame)
+				keyFrame = nil
+				p.setReady()
+			}
+		}
+	}
+}
+
+func (p *WHIPPublisher) Streams() ([]av.CodecData, error) {
+	select {
+	case <-p.ready:
+	case <-p.done:
+		return nil, io.EOF
+	case <-time.After(publisherCodecsTimeout()):
+		return nil, ErrorWHIPCodecsTimeout
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]av.CodecData(nil), p.codecs...), nil
+}
+
+// ReadPacket returns ErrorWHIPCodecUpdate ahead of the packets of a track
+// that started after Streams.
+func (p *WHIPPublisher) ReadPacket() (av.Packet, error) {
+	select {
+	case packet := <-p.packets:
+		if packet == nil {
+			return av.Packet{}, ErrorWHIPCodecUpdate
+		}
+		return *packet, nil
+	case <-p.done:
+		return av.Packet{}, io.EOF
+	}
+}
+
+func (p *WHIPPublisher) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *WHIPPublisher) Close() error {
+	p.mutex.Lock()
+	if p.stop {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.stop = true
+	close(p.done)
+	p.mutex.Unlock()
+	removeSession(p)
+	return p.pc.Close()
+}
@@ -0,0 +1,90 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aicacia/streams/app/config"
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec"
+)
+
+func testWHIPPublisher(video bool) *WHIPPublisher {
+	return &WHIPPublisher{
+		video:   video,
+		ready:   make(chan struct{}),
+		packets: make(chan *av.Packet, whipPacketChanSize),
+		done:    make(chan struct{}),
+	}
+}
+
+func TestWHIPPublisherReady(t *testing.T) {
+	config.Config.RTSP.Connect.Timeout.Seconds = 1
+
+	publisher := testWHIPPublisher(true)
+	if idx, ok := publisher.addCodec(codec.NewPCMMulawCodecData()); idx != 0 || !ok {
+		t.Fatalf("expected audio at 0, got %d %v", idx, ok)
+	}
+	if publisher.isReadyLocked() {
+		t.Fatal("expected a publisher with video to wait for a keyframe")
+	}
+	if idx, _ := publisher.addCodec(codec.NewPCMAlawCodecData()); idx != 1 {
+		t.Fatalf("expected video at 1, got %d", idx)
+	}
+	publisher.setReady()
+	codecs, err := publisher.Streams()
+	if err != nil || len(codecs) != 2 {
+		t.Fatalf("expected 2 codecs, got %d %v", len(codecs), err)
+	}
+
+	if idx, ok := publisher.addCodec(codec.NewOpusCodecData(48000, av.CH_STEREO)); idx != 2 || !ok {
+		t.Fatalf("expected a late track at 2, got %d %v", idx, ok)
+	}
+	if _, err := publisher.ReadPacket(); err != ErrorWHIPCodecUpdate {
+		t.Fatalf("expected %v, got %v", ErrorWHIPCodecUpdate, err)
+	}
+	if codecs, _ := publisher.Streams(); len(codecs) != 3 {
+		t.Fatalf("expected 3 codecs after the update, got %d", len(codecs))
+	}
+
+	audioOnly := testWHIPPublisher(false)
+	audioOnly.addCodec(codec.NewPCMMulawCodecData())
+	if !audioOnly.isReadyLocked() {
+		t.Fatal("expected a publisher without video to be ready with its first track")
+	}
+
+	silent := testWHIPPublisher(true)
+	silent.addCodec(codec.NewPCMMulawCodecData())
+	if _, err := silent.Streams(); err != ErrorWHIPCodecsTimeout {
+		t.Fatalf("expected %v without a keyframe, got %v", ErrorWHIPCodecsTimeout, err)
+	}
+}
+
+func TestWHIPClock(t *testing.T) {
+	var clock whipClockST
+	start := time.Now()
+	video := clock.track(90000)
+	audio := clock.track(48000)
+	// close to the top so the video timestamps wrap
+	base := uint32(4294960000)
+
+	tests := []struct {
+		name      string
+		track     *whipTrackClockST
+		timestamp uint32
+		arrival   time.Duration
+		time      time.Duration
+	}{
+		{"video start", video, base, 0, 0},
+		{"audio starts late", audio, 7, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"video follows rtp", video, base + 9000, 300 * time.Millisecond, 100 * time.Millisecond},
+		{"audio follows rtp", audio, 7 + 960, time.Second, 120 * time.Millisecond},
+		{"video wraps", video, base + 9000*50, time.Second, 5 * time.Second},
+		{"video reordered", video, base + 9000*49, time.Second, 4900 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := test.track.at(test.timestamp, start.Add(test.arrival)); got != test.time {
+			t.Errorf("%s: expected %v, got %v", test.name, test.time, got)
+		}
+	}
+}
@@ -129,6 +129,117 @@ const docTemplate = `{
                 }
             }
         },
+        "/cameras/discover": {
+            "post": {
+                "description": "find ONVIF cameras on the LAN with WS-Discovery and read their stream profiles, each candidate camera can be sent to create camera or created right away with create, devices that already have a camera are not created again and address must be a multicast or link local ip:port",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras"
+                ],
+                "summary": "Discover Cameras",
+                "parameters": [
+                    {
+                        "description": "Discover Cameras",
+                        "name": "discover",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.DiscoverBodyST"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/services.CameraCandidateST"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/cameras/status": {
+            "get": {
+                "description": "get the status of all cameras",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras"
+                ],
+                "summary": "Get Cameras Status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.CameraStatusST"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
         "/cameras/{cameraId}": {
             "get": {
                 "description": "get camera by id",
@@ -302,6 +413,69 @@ const docTemplate = `{
                 }
             }
         },
+        "/cameras/{cameraId}/live": {
+            "get": {
+                "description": "get camera live tracks with codec details and the outputs that can play each track, a track that can not play live has a reason",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras",
+                    "live"
+                ],
+                "summary": "Get Live Tracks",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "cameraId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Camera profile, defaults to main",
+                        "name": "profile",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LiveST"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
         "/cameras/{cameraId}/live/codecs": {
             "get": {
                 "description": "get camera live codecs",
@@ -323,6 +497,12 @@ const docTemplate = `{
                         "name": "cameraId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Camera profile, defaults to main",
+                        "name": "profile",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -364,7 +544,7 @@ const docTemplate = `{
         },
         "/cameras/{cameraId}/live/sdp": {
             "post": {
-                "description": "send live offer for camera by id",
+                "description": "send live offer for camera by id, a \"metadata\" data channel in the offer receives the wall clock time of every key frame and a time sync every second",
                 "consumes": [
                     "application/json"
                 ],
@@ -384,6 +564,12 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     },
+                    {
+                        "type": "string",
+                        "description": "Camera profile, defaults to main",
+                        "name": "profile",
+                        "in": "query"
+                    },
                     {
                         "description": "Offer body",
                         "name": "offer",
@@ -451,11 +637,17 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "type": "string",
+                        "type": "integer",
                         "description": "Playback start time",
                         "name": "start",
                         "in": "query",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Camera profile, defaults to main",
+                        "name": "profile",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -492,9 +684,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/playback/{playbackId}/codecs": {
-            "get": {
-                "description": "get camera playback codecs",
+        "/cameras/{cameraId}/ptz": {
+            "post": {
+                "description": "send an ONVIF PTZ command to the camera, pan, tilt and zoom are in the device's generic -1..1 space, timeout_ms limits a continuous move",
                 "consumes": [
                     "application/json"
                 ],
@@ -502,27 +694,32 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "cameras",
-                    "playback"
+                    "cameras"
                 ],
-                "summary": "Get Playbaack Codecs",
+                "summary": "Camera PTZ",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Playback ID",
-                        "name": "playbackId",
+                        "description": "Camera ID",
+                        "name": "cameraId",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "PTZ Command",
+                        "name": "ptz",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PTZBodyST"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/models.PTZResponseST"
                         }
                     },
                     "400": {
@@ -552,43 +749,30 @@ const docTemplate = `{
                 }
             }
         },
-        "/playback/{playbackId}/sdp": {
-            "post": {
-                "description": "send playback offer for camera by id",
-                "consumes": [
-                    "application/json"
-                ],
+        "/cameras/{cameraId}/snapshot": {
+            "get": {
+                "description": "get a still image from the camera url, proxied with the camera credentials and cached for a short time",
                 "produces": [
-                    "application/json"
+                    "image/jpeg"
                 ],
                 "tags": [
-                    "cameras",
-                    "playback"
+                    "cameras"
                 ],
-                "summary": "Send playback offer",
+                "summary": "Camera Snapshot",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Playback ID",
-                        "name": "playbackId",
+                        "description": "Camera ID",
+                        "name": "cameraId",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "Offer body",
-                        "name": "offer",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.OfferBodyST"
-                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.AnswerST"
+                            "type": "file"
                         }
                     },
                     "400": {
@@ -614,59 +798,848 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ResponseErrorST"
                         }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.AnswerST": {
-            "type": "object",
-            "required": [
-                "answer_base64"
-            ],
-            "properties": {
-                "answer_base64": {
-                    "type": "string"
-                }
-            }
         },
-        "models.CameraST": {
-            "type": "object",
-            "required": [
-                "created_ts",
-                "disabled",
-                "id",
-                "name",
-                "recording",
-                "rtsp_url",
-                "updated_ts",
-                "url"
-            ],
-            "properties": {
-                "created_ts": {
-                    "type": "string"
-                },
-                "disabled": {
-                    "type": "boolean"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "recording": {
+        "/cameras/{cameraId}/status": {
+            "get": {
+                "description": "get the connection state, traffic and viewers of every stream of the camera and whether it is recording",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras"
+                ],
+                "summary": "Get Camera Status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "cameraId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.CameraStatusST"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/live/grid": {
+            "post": {
+                "description": "send a live offer for many cameras over one connection, every recvonly video transceiver in the offer is a slot and cameras are assigned to slots in order, the \"grid\" data channel receives the slot to camera mapping",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "live"
+                ],
+                "summary": "Create live grid",
+                "parameters": [
+                    {
+                        "description": "Grid offer body",
+                        "name": "offer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.GridOfferBodyST"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GridAnswerST"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/live/grid/{gridId}": {
+            "delete": {
+                "description": "close a live grid and all of its camera viewers",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "live"
+                ],
+                "summary": "Delete live grid",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Grid ID",
+                        "name": "gridId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "null"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "reassign the cameras of a live grid, include a new offer to renegotiate when more slots are needed",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "live"
+                ],
+                "summary": "Update live grid",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Grid ID",
+                        "name": "gridId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Grid update body",
+                        "name": "grid",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.GridUpdateBodyST"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GridAnswerST"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/playback/{playbackId}/codecs": {
+            "get": {
+                "description": "get camera playback codecs",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras",
+                    "playback"
+                ],
+                "summary": "Get Playbaack Codecs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Playback ID",
+                        "name": "playbackId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/playback/{playbackId}/sdp": {
+            "post": {
+                "description": "send playback offer for camera by id, a \"metadata\" data channel in the offer receives the wall clock time of every key frame and a time sync every second",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras",
+                    "playback"
+                ],
+                "summary": "Send playback offer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Playback ID",
+                        "name": "playbackId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Offer body",
+                        "name": "offer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OfferBodyST"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.AnswerST"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/whip/{cameraId}": {
+            "post": {
+                "description": "publish H264 and Opus/G.711 over WebRTC into a whip camera, the body is the SDP offer and the camera stream key is sent as a bearer token",
+                "consumes": [
+                    "application/sdp"
+                ],
+                "produces": [
+                    "application/sdp"
+                ],
+                "tags": [
+                    "cameras",
+                    "whip"
+                ],
+                "summary": "WHIP publish",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "cameraId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "SDP offer",
+                        "name": "offer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        },
+        "/whip/{cameraId}/{sessionId}": {
+            "delete": {
+                "description": "end a WHIP session, the camera waits for the next publisher",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "cameras",
+                    "whip"
+                ],
+                "summary": "End WHIP publish",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "cameraId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "WHIP Session ID",
+                        "name": "sessionId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "null"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ResponseErrorST"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.AnswerST": {
+            "type": "object",
+            "required": [
+                "answer_base64"
+            ],
+            "properties": {
+                "answer_base64": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraFileST": {
+            "type": "object",
+            "properties": {
+                "camera_id": {
+                    "type": "string"
+                },
+                "end": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "start": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraOnvifST": {
+            "type": "object",
+            "required": [
+                "xaddr"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "profile_token": {
+                    "type": "string"
+                },
+                "ptz": {
+                    "$ref": "#/definitions/models.PTZCapabilitiesST"
+                },
+                "ptz_xaddr": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                },
+                "xaddr": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraProcessorST": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "options": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.CameraProfileST": {
+            "type": "object",
+            "required": [
+                "name",
+                "rtsp_url"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "rtsp_options": {
+                    "$ref": "#/definitions/models.CameraRtspOptionsST"
+                },
+                "rtsp_url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraRtspOptionsST": {
+            "type": "object",
+            "properties": {
+                "ca_cert": {
+                    "type": "string"
+                },
+                "connect_timeout_seconds": {
+                    "type": "integer"
+                },
+                "debug": {
+                    "type": "boolean"
+                },
+                "disable_audio": {
+                    "type": "boolean"
+                },
+                "insecure_skip_verify": {
+                    "type": "boolean"
+                },
+                "io_timeout_seconds": {
+                    "type": "integer"
+                },
+                "transport": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraST": {
+            "type": "object",
+            "required": [
+                "created_ts",
+                "disabled",
+                "id",
+                "name",
+                "recording",
+                "rtsp_url",
+                "source",
+                "updated_ts",
+                "url"
+            ],
+            "properties": {
+                "created_ts": {
+                    "type": "string"
+                },
+                "disabled": {
+                    "type": "boolean"
+                },
+                "file": {
+                    "$ref": "#/definitions/models.CameraFileST"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "on_demand": {
+                    "type": "boolean"
+                },
+                "onvif": {
+                    "$ref": "#/definitions/models.CameraOnvifST"
+                },
+                "pipeline": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProcessorST"
+                    }
+                },
+                "profiles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProfileST"
+                    }
+                },
+                "record_profile": {
+                    "type": "string"
+                },
+                "recording": {
+                    "type": "boolean"
+                },
+                "rtsp_options": {
+                    "$ref": "#/definitions/models.CameraRtspOptionsST"
+                },
+                "rtsp_url": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "stream_key": {
+                    "type": "string"
+                },
+                "updated_ts": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CameraStatusST": {
+            "type": "object",
+            "required": [
+                "camera_id",
+                "streams"
+            ],
+            "properties": {
+                "camera_id": {
+                    "type": "string"
+                },
+                "disabled": {
+                    "type": "boolean"
+                },
+                "recording": {
+                    "type": "boolean"
+                },
+                "streams": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.StreamStatusST"
+                    }
+                }
+            }
+        },
+        "models.DiscoverBodyST": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "create": {
                     "type": "boolean"
                 },
-                "rtsp_url": {
+                "password": {
                     "type": "string"
                 },
-                "updated_ts": {
+                "timeout_ms": {
+                    "type": "integer"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GridAnswerST": {
+            "type": "object",
+            "required": [
+                "grid_id",
+                "tracks"
+            ],
+            "properties": {
+                "answer_base64": {
                     "type": "string"
                 },
-                "url": {
+                "grid_id": {
+                    "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GridTrackST"
+                    }
+                }
+            }
+        },
+        "models.GridOfferBodyST": {
+            "type": "object",
+            "required": [
+                "camera_ids",
+                "offer_base64"
+            ],
+            "properties": {
+                "camera_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "offer_base64": {
+                    "type": "string"
+                },
+                "profile": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GridTrackST": {
+            "type": "object",
+            "required": [
+                "mid",
+                "slot",
+                "track_id"
+            ],
+            "properties": {
+                "camera_id": {
+                    "type": "string"
+                },
+                "mid": {
+                    "type": "string"
+                },
+                "slot": {
+                    "type": "integer"
+                },
+                "track_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GridUpdateBodyST": {
+            "type": "object",
+            "required": [
+                "camera_ids"
+            ],
+            "properties": {
+                "camera_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "offer_base64": {
+                    "type": "string"
+                },
+                "profile": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.LiveST": {
+            "type": "object",
+            "required": [
+                "camera_id",
+                "profile",
+                "tracks"
+            ],
+            "properties": {
+                "camera_id": {
+                    "type": "string"
+                },
+                "profile": {
                     "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TrackST"
+                    }
                 }
             }
         },
@@ -681,6 +1654,156 @@ const docTemplate = `{
                 }
             }
         },
+        "models.OnvifDeviceST": {
+            "type": "object",
+            "required": [
+                "address",
+                "profiles",
+                "xaddr"
+            ],
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "hardware": {
+                    "type": "string"
+                },
+                "manufacturer": {
+                    "type": "string"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "profiles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.OnvifProfileST"
+                    }
+                },
+                "serial_number": {
+                    "type": "string"
+                },
+                "xaddr": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OnvifProfileST": {
+            "type": "object",
+            "required": [
+                "name",
+                "token"
+            ],
+            "properties": {
+                "encoding": {
+                    "type": "string"
+                },
+                "frame_rate": {
+                    "type": "integer"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "rtsp_url": {
+                    "type": "string"
+                },
+                "snapshot_url": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.PTZBodyST": {
+            "type": "object",
+            "required": [
+                "action"
+            ],
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "pan": {
+                    "type": "number"
+                },
+                "preset_token": {
+                    "type": "string"
+                },
+                "speed": {
+                    "type": "number"
+                },
+                "tilt": {
+                    "type": "number"
+                },
+                "timeout_ms": {
+                    "type": "integer"
+                },
+                "zoom": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.PTZCapabilitiesST": {
+            "type": "object",
+            "properties": {
+                "absolute_move": {
+                    "type": "boolean"
+                },
+                "continuous_move": {
+                    "type": "boolean"
+                },
+                "presets": {
+                    "type": "boolean"
+                },
+                "relative_move": {
+                    "type": "boolean"
+                },
+                "zoom": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.PTZPresetST": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.PTZResponseST": {
+            "type": "object",
+            "required": [
+                "action"
+            ],
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "presets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PTZPresetST"
+                    }
+                }
+            }
+        },
         "models.ResponseErrorST": {
             "type": "object",
             "required": [
@@ -692,21 +1815,181 @@ const docTemplate = `{
                 }
             }
         },
+        "models.StreamStatusST": {
+            "type": "object",
+            "required": [
+                "profile",
+                "state",
+                "stream_id"
+            ],
+            "properties": {
+                "backoff_seconds": {
+                    "type": "number"
+                },
+                "bitrate_kbps": {
+                    "type": "number"
+                },
+                "connected_ts": {
+                    "type": "string"
+                },
+                "dropped_gops": {
+                    "type": "integer"
+                },
+                "dropped_packets": {
+                    "description": "DroppedPackets and DroppedGOPs add up the viewers of the stream that\nfell behind, the ones that left included",
+                    "type": "integer"
+                },
+                "fps": {
+                    "type": "number"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_error_ts": {
+                    "type": "string"
+                },
+                "packets_per_second": {
+                    "type": "number"
+                },
+                "profile": {
+                    "type": "string"
+                },
+                "reconnects": {
+                    "type": "integer"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "stream_id": {
+                    "type": "string"
+                },
+                "tracks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TrackST"
+                    }
+                },
+                "uptime_seconds": {
+                    "type": "number"
+                },
+                "viewers": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.TrackST": {
+            "type": "object",
+            "required": [
+                "codec",
+                "idx",
+                "outputs",
+                "type"
+            ],
+            "properties": {
+                "channels": {
+                    "type": "integer"
+                },
+                "codec": {
+                    "type": "string"
+                },
+                "fps": {
+                    "type": "integer"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "idx": {
+                    "type": "integer"
+                },
+                "outputs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "description": "Reason is set when the track can not be played live over webrtc",
+                    "type": "string"
+                },
+                "sample_rate": {
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CameraCandidateST": {
+            "type": "object",
+            "required": [
+                "device"
+            ],
+            "properties": {
+                "camera": {
+                    "$ref": "#/definitions/services.CameraCreateST"
+                },
+                "camera_id": {
+                    "type": "string"
+                },
+                "device": {
+                    "$ref": "#/definitions/models.OnvifDeviceST"
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
         "services.CameraCreateST": {
             "type": "object",
             "properties": {
                 "disabled": {
                     "type": "boolean"
                 },
+                "file": {
+                    "$ref": "#/definitions/models.CameraFileST"
+                },
                 "name": {
                     "type": "string"
                 },
+                "on_demand": {
+                    "type": "boolean"
+                },
+                "onvif": {
+                    "$ref": "#/definitions/models.CameraOnvifST"
+                },
+                "pipeline": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProcessorST"
+                    }
+                },
+                "profiles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProfileST"
+                    }
+                },
+                "record_profile": {
+                    "type": "string"
+                },
                 "recording": {
                     "type": "boolean"
                 },
+                "rtsp_options": {
+                    "$ref": "#/definitions/models.CameraRtspOptionsST"
+                },
                 "rtsp_url": {
                     "type": "string"
                 },
+                "source": {
+                    "type": "string"
+                },
+                "stream_key": {
+                    "type": "string"
+                },
                 "url": {
                     "type": "string"
                 }
@@ -718,15 +2001,48 @@ const docTemplate = `{
                 "disabled": {
                     "type": "boolean"
                 },
+                "file": {
+                    "$ref": "#/definitions/models.CameraFileST"
+                },
                 "name": {
                     "type": "string"
                 },
+                "on_demand": {
+                    "type": "boolean"
+                },
+                "onvif": {
+                    "$ref": "#/definitions/models.CameraOnvifST"
+                },
+                "pipeline": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProcessorST"
+                    }
+                },
+                "profiles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.CameraProfileST"
+                    }
+                },
+                "record_profile": {
+                    "type": "string"
+                },
                 "recording": {
                     "type": "boolean"
                 },
+                "rtsp_options": {
+                    "$ref": "#/definitions/models.CameraRtspOptionsST"
+                },
                 "rtsp_url": {
                     "type": "string"
                 },
+                "source": {
+                    "type": "string"
+                },
+                "stream_key": {
+                    "type": "string"
+                },
                 "url": {
                     "type": "string"
                 }
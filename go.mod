@@ -11,6 +11,11 @@ require (
 	github.com/gofiber/swagger v0.1.9
 	github.com/google/uuid v1.3.0
 	github.com/magiconair/properties v1.8.6
+	github.com/pion/ice/v2 v2.3.0
+	github.com/pion/interceptor v0.1.12
+	github.com/pion/rtcp v1.2.10
+	github.com/pion/rtp v1.7.13
+	github.com/pion/webrtc/v3 v3.1.55
 	github.com/swaggo/swag v1.8.10
 )
 
@@ -30,13 +35,9 @@ require (
 	github.com/philhofer/fwd v1.1.2 // indirect
 	github.com/pion/datachannel v1.5.5 // indirect
 	github.com/pion/dtls/v2 v2.2.4 // indirect
-	github.com/pion/ice/v2 v2.3.0 // indirect
-	github.com/pion/interceptor v0.1.12 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.7 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtcp v1.2.10 // indirect
-	github.com/pion/rtp v1.7.13 // indirect
 	github.com/pion/sctp v1.8.6 // indirect
 	github.com/pion/sdp/v3 v3.0.6 // indirect
 	github.com/pion/srtp/v2 v2.0.12 // indirect
@@ -44,7 +45,6 @@ require (
 	github.com/pion/transport/v2 v2.0.1 // indirect
 	github.com/pion/turn/v2 v2.1.0 // indirect
 	github.com/pion/udp v0.1.4 // indirect
-	github.com/pion/webrtc/v3 v3.1.55 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/savsgio/dictpool v0.0.0-20221023140959-7bf2e61cea94 // indirect
 	github.com/savsgio/gotils v0.0.0-20230208104028-c358bd845dee // indirect
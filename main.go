@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/aicacia/streams/app"
 	"github.com/aicacia/streams/app/config"
 	"github.com/aicacia/streams/app/rtsp"
 	"github.com/aicacia/streams/app/services"
+	"github.com/aicacia/streams/app/webrtc"
 	_ "github.com/aicacia/streams/docs"
 	"github.com/aicacia/streams/pkg/router"
 	"github.com/gofiber/fiber/v2"
@@ -39,6 +44,7 @@ func main() {
 	config.InitConfig()
 
 	rtsp.InitClients()
+	rtsp.InitRTMP()
 	rtsp.InitRecord()
 
 	services.InitCameras()
@@ -56,5 +62,43 @@ func main() {
 	app.Get("/dashboard", monitor.New())
 	router.InstallRouter(app)
 
-	log.Fatal(app.Listen(fmt.Sprintf("%s:%d", config.Config.Host, config.Config.Port)))
+	go func() {
+		if err := app.Listen(fmt.Sprintf("%s:%d", config.Config.Host, config.Config.Port)); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	shutdown(app)
+}
+
+// shutdown stops following camera events and accepting requests and
+// publishers, closes every peer connection, closes the recordings and
+// disconnects the cameras, giving up at the deadline.
+func shutdown(server *fiber.App) {
+	timeout := time.Duration(config.Config.Shutdown.Timeout.Seconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	log.Printf("Shutting down within %s\n", timeout)
+
+	if err := rtsp.StopEvents(ctx); err != nil {
+		log.Printf("Failed to stop camera events %s\n", err)
+	}
+	deadline, _ := ctx.Deadline()
+	if err := server.ShutdownWithTimeout(time.Until(deadline)); err != nil {
+		log.Printf("Failed to stop http server %s\n", err)
+	}
+	if err := rtsp.CloseRTMP(); err != nil {
+		log.Printf("Failed to stop rtmp server %s\n", err)
+	}
+	if err := webrtc.CloseAll(ctx); err != nil {
+		log.Printf("Failed to close peer connections %s\n", err)
+	}
+	if err := rtsp.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown did not finish %s\n", err)
+		return
+	}
+	log.Println("Shutdown complete")
 }
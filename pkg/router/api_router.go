@@ -11,7 +11,8 @@ type ApiRouter struct {
 
 func (h ApiRouter) InstallRouter(app *fiber.App) {
 	group := app.Group("", cors.New(cors.Config{
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization, Forwarded",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, Forwarded",
+		ExposeHeaders: "Location",
 	}))
 
 	group.Get("/health", controllers.GetHealthCheck)
@@ -21,17 +22,35 @@ func (h ApiRouter) InstallRouter(app *fiber.App) {
 	cameras := group.Group("/cameras")
 	cameras.Get("", controllers.GetCameras)
 	cameras.Patch("", controllers.PostCreateCamera)
+	cameras.Post("/discover", controllers.PostDiscoverCameras)
+	cameras.Get("/status", controllers.GetCamerasStatus)
 
 	cameras_by_id := cameras.Group("/:cameraId")
 	cameras_by_id.Get("", controllers.GetCameraById)
 	cameras_by_id.Patch("", controllers.PatchUpdateCamera)
 	cameras_by_id.Put("", controllers.PatchUpdateCamera)
 	cameras_by_id.Delete("", controllers.DeleteCamera)
+	cameras_by_id.Get("/snapshot", controllers.GetCameraSnapshot)
+	cameras_by_id.Get("/status", controllers.GetCameraStatus)
+	cameras_by_id.Post("/ptz", controllers.PostCameraPTZ)
 
 	camera_live := cameras_by_id.Group("/live")
+	camera_live.Get("", controllers.GetLive)
 	camera_live.Get("/codecs", controllers.GetLiveCodecs)
 	camera_live.Post("/sdp", controllers.PostLiveSdp)
 
+	live := group.Group("/live")
+	live_grid := live.Group("/grid")
+	live_grid.Post("", controllers.PostLiveGrid)
+	live_grid_by_id := live_grid.Group("/:gridId")
+	live_grid_by_id.Patch("", controllers.PatchLiveGrid)
+	live_grid_by_id.Delete("", controllers.DeleteLiveGrid)
+
+	whip := group.Group("/whip")
+	whip_by_camera := whip.Group("/:cameraId")
+	whip_by_camera.Post("", controllers.PostWHIP)
+	whip_by_camera.Delete("/:sessionId", controllers.DeleteWHIP)
+
 	camera_playback := cameras_by_id.Group("/playback")
 	camera_playback.Post("", controllers.PostCreatePlayback)
 